@@ -54,8 +54,14 @@ func main() {
 
 	log.Printf("Bearer :%s\n", auth)
 
+	// Encrypted tokens keys, the current one first. Signed only tokens are
+	// still accepted with their secret until all the clients have been upgraded
+	keys := pydio.NewKeyring(strings.Split(os.Getenv("PYDIO_TOKEN_KEYS"), ",")...)
+	keys.LegacySecret = os.Getenv("PYDIO_TOKEN_LEGACY_SECRET")
+	keys.Legacy = keys.LegacySecret != "" && os.Getenv("PYDIO_TOKEN_LEGACY") != "false"
+
 	// Get user information
-	user, err := pydio.NewUserFromJWT(auth, "secret")
+	user, err := pydio.NewUserFromToken(auth, keys)
 	if err != nil {
 		log.Panicln("Failed to retrieve user: ", err)
 	}
@@ -35,8 +35,11 @@ import (
 
 	"github.com/pydio/pydio-booster/com"
 	"github.com/pydio/pydio-booster/conf"
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
 	"github.com/pydio/pydio-booster/log"
 	"github.com/pydio/pydio-booster/scheduler"
+	"github.com/pydio/pydio-booster/websocket"
 
 	// List of plugins used in the soft
 	_ "github.com/mholt/caddy/caddyhttp/basicauth"
@@ -46,7 +49,11 @@ import (
 	_ "github.com/mholt/caddy/caddyhttp/status"
 	_ "github.com/mholt/caddy/caddyhttp/websocket"
 	_ "github.com/pydio/pydio-booster/server/middleware/pydioadmin"
+	_ "github.com/pydio/pydio-booster/server/middleware/pydiocors"
 	_ "github.com/pydio/pydio-booster/server/middleware/pydiodownload"
+	_ "github.com/pydio/pydio-booster/server/middleware/pydiosse"
+	_ "github.com/pydio/pydio-booster/server/middleware/pydiostat"
+	_ "github.com/pydio/pydio-booster/server/middleware/pydiotransfer"
 	_ "github.com/pydio/pydio-booster/server/middleware/pydioupload"
 	_ "github.com/pydio/pydio-booster/server/middleware/pydiows"
 )
@@ -58,6 +65,16 @@ type Configuration struct {
 	Scheduler conf.SchedulerConf
 	Nsq       conf.NsqConf
 	Log       conf.LogConf
+	Client    conf.ClientConf
+
+	// Normalization of the paths per repository id, "*" applying to all of them
+	Normalization map[string]conf.NormalizationConf
+
+	// Events the websocket clients may publish, by name
+	Events map[string]conf.EventConf
+
+	// Tokens of the users and options sent by Pydio
+	Tokens conf.TokensConf
 }
 
 // Flags that control program flow or startup
@@ -98,10 +115,15 @@ func main() {
 	caddy.AppVersion = "local"
 
 	// List all directives used and defined by pydio
+	// pydiocors comes first so that preflights never reach the other directives
+	httpserver.RegisterDevDirective("pydiocors", "")
 	httpserver.RegisterDevDirective("pydioadmin", "")
 	httpserver.RegisterDevDirective("pydiodownload", "")
 	httpserver.RegisterDevDirective("pydioupload", "")
+	httpserver.RegisterDevDirective("pydiotransfer", "")
+	httpserver.RegisterDevDirective("pydiostat", "")
 	httpserver.RegisterDevDirective("pydiows", "")
+	httpserver.RegisterDevDirective("pydiosse", "")
 
 	if plugins {
 		fmt.Println(caddy.DescribePlugins())
@@ -141,6 +163,35 @@ func main() {
 
 	log.Infof("Set log level to %d", loglevel)
 
+	// Backend client shared by the middlewares, the remote io and the scheduler
+	if err := pydhttp.SetDefaultClient(&config.Client); err != nil {
+		log.Errorln(err)
+		os.Exit(2)
+	}
+
+	// Normalization of the paths of the repositories
+	for repo, c := range config.Normalization {
+		n, err := pydio.NewNormalization(c.Form, c.FoldCase)
+		if err != nil {
+			log.Errorln(err)
+			os.Exit(2)
+		}
+
+		pydio.SetNormalization(repo, n)
+	}
+
+	for event, c := range config.Events {
+		websocket.SetEventPolicy(event, websocket.EventPolicy{
+			Topic: c.Topic,
+			Write: c.Write,
+		})
+	}
+
+	keys := pydio.NewKeyring(config.Tokens.Keys...)
+	keys.Legacy = config.Tokens.Legacy
+	keys.LegacySecret = config.Tokens.LegacySecret
+	pydio.SetKeyring(keys)
+
 	// Start your engines
 	instance, err := caddy.Start(config.Configuration.CaddyFile)
 	if err != nil {
@@ -155,6 +206,33 @@ func main() {
 			com.StopProducer()
 		}()
 		com.NewCom(&config.Nsq)
+
+		// Used by the locks and the progress of the transfers
+		if err := com.NewProducer(); err != nil {
+			log.Errorln(err)
+		}
+
+		if config.Nsq.Locks {
+			if err := startLockManager(); err != nil {
+				log.Errorln(err)
+				os.Exit(2)
+			}
+		}
+
+		if err := websocket.SetQueueConfig(websocket.QueueConfig{
+			Size:   config.Nsq.Queue.Size,
+			Policy: config.Nsq.Queue.Policy,
+		}); err != nil {
+			log.Errorln(err)
+			os.Exit(2)
+		}
+
+		if config.Nsq.Backlog > 0 {
+			if err := startBacklog(config.Nsq.Backlog); err != nil {
+				log.Errorln(err)
+				os.Exit(2)
+			}
+		}
 	}
 
 	if (config.Scheduler != conf.SchedulerConf{}) {
@@ -166,3 +244,28 @@ func main() {
 	com.StopProducer()
 	log.Infoln("Exiting without listening!!")
 }
+
+// startLockManager sharing the node locks through NSQ
+func startLockManager() error {
+	locks, err := com.NewLockManager()
+	if err != nil {
+		return err
+	}
+
+	pydio.SetLockManager(locks)
+
+	return nil
+}
+
+// startBacklog recording the instant messages for the websocket clients that reconnect
+func startBacklog(size int) error {
+	b := websocket.NewBacklog(size)
+
+	if err := b.Start(); err != nil {
+		return err
+	}
+
+	websocket.SetBacklog(b)
+
+	return nil
+}
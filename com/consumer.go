@@ -20,6 +20,7 @@
 package com
 
 import (
+	"errors"
 	"sync"
 
 	"github.com/nsqio/go-nsq"
@@ -42,6 +43,9 @@ var (
 
 // NewConsumer that will register a handler for different topic and channels
 func NewConsumer(topic string, channel string) (*Consumer, error) {
+	if !IsRunning() {
+		return nil, errors.New("NSQ must be running")
+	}
 
 	connected := false
 
@@ -75,13 +79,6 @@ func (c *Consumer) Start() error {
 		return err
 	}
 
-	// Making sure the consumer stops if everything stops
-	go func() {
-		defer c.queue.Stop()
-
-		consumerWg.Wait()
-	}()
-
 	c.Connected = true
 
 	consumerWg.Add(1)
@@ -96,13 +93,15 @@ func (c *Consumer) AddHandler(handler func(*nsq.Message) error) {
 	c.Handlers = append(c.Handlers, handler)
 }
 
-//Stop consumer listening
+//Stop consumer listening. It can be called more than once
 func (c *Consumer) Stop() {
+	c.queue.Stop()
+
 	if !c.Connected {
 		return
 	}
 
-	c.queue.Stop()
+	c.Connected = false
 
 	consumerWg.Done()
 }
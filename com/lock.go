@@ -0,0 +1,316 @@
+/*Package com controls the communication layer of the Pydio app
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package com
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/nu7hatch/gouuid"
+	"github.com/pydio/pydio-booster/io"
+	"github.com/pydio/pydio-booster/log"
+)
+
+// LockTopic on which the instances share their node locks
+const LockTopic = "lock"
+
+// Delays of the rounds between the instances
+const (
+	// LockClaimTimeout is the time given to a new lock to go round the instances
+	LockClaimTimeout = 5 * time.Second
+
+	// LockSyncTimeout is the time given to the other instances to share
+	// their locks with a new one
+	LockSyncTimeout = time.Second
+)
+
+// ErrLockNotShared is returned when a lock did not go round the instances in time
+var ErrLockNotShared = errors.New("Lock could not be shared with the other instances")
+
+// lockBus carries the lock events between the instances, all of them
+// receiving the events in the same order
+type lockBus interface {
+	// Subscribe to the events on a channel of its own
+	Subscribe(channel string, handler func(*nsq.Message) error) (stop func(), err error)
+
+	Publish(m Message) error
+}
+
+// nsqBus carries the lock events through NSQ
+type nsqBus struct{}
+
+func (nsqBus) Subscribe(channel string, handler func(*nsq.Message) error) (func(), error) {
+	c, err := NewConsumer(LockTopic, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	c.AddHandler(handler)
+
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	return c.Stop, nil
+}
+
+func (nsqBus) Publish(m Message) error {
+	return Publish(m)
+}
+
+// lockEvent sent when a lock is taken or released
+type lockEvent struct {
+	Origin string      `json:"origin"`
+	Action string      `json:"action"`
+	Lock   *pydio.Lock `json:"lock"`
+}
+
+// LockManager shares the node locks of the instances through NSQ.
+// Each instance keeps a copy of all the locks. A new lock is announced to
+// the other instances and only granted once the announcement comes back :
+// all the instances receive the claims in the same order and the first one
+// for a node wins everywhere. A new instance asks the others for their
+// locks before granting any
+type LockManager struct {
+	*pydio.MemoryLockManager
+
+	id   string
+	bus  lockBus
+	stop func()
+
+	// Claims waiting for their announcement to come back
+	mu     sync.Mutex
+	claims map[string]chan struct{}
+
+	synced chan struct{}
+}
+
+// NewLockManager listening to the locks of the other instances.
+// The producer must be started
+func NewLockManager() (*LockManager, error) {
+	return newLockManager(nsqBus{})
+}
+
+func newLockManager(bus lockBus) (*LockManager, error) {
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &LockManager{
+		MemoryLockManager: pydio.NewMemoryLockManager(),
+		id:                u4.String(),
+		bus:               bus,
+		claims:            make(map[string]chan struct{}),
+		synced:            make(chan struct{}),
+	}
+
+	// Channel deleted by NSQ once the instance stops
+	m.stop, err = bus.Subscribe(u4.String()+"#ephemeral", m.handle)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.publish("sync", nil); err != nil {
+		m.stop()
+		return nil, err
+	}
+
+	time.AfterFunc(LockSyncTimeout, func() { close(m.synced) })
+
+	return m, nil
+}
+
+// Lock the node once all the instances agree on it
+func (m *LockManager) Lock(node *pydio.Node, owner string, timeout time.Duration) (*pydio.Lock, error) {
+	<-m.synced
+
+	l, err := m.MemoryLockManager.Lock(node, owner, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.claim(context.Background(), node, l); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Acquire a transient lock once all the instances agree on it. Losing
+// the node to a transient lock of another instance waits for its release
+func (m *LockManager) Acquire(ctx context.Context, node *pydio.Node, owner string) (*pydio.Lock, error) {
+	select {
+	case <-m.synced:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		l, err := m.MemoryLockManager.Acquire(ctx, node, owner)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.claim(ctx, node, l)
+		if err != pydio.ErrLocked {
+			if err != nil {
+				return nil, err
+			}
+
+			return l, nil
+		}
+	}
+}
+
+// Refresh the lock and share its new expiry
+func (m *LockManager) Refresh(token string, timeout time.Duration) (*pydio.Lock, error) {
+	l, err := m.MemoryLockManager.Refresh(token, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, m.publish("lock", l)
+}
+
+// Unlock the node and share the release
+func (m *LockManager) Unlock(token string) error {
+	if err := m.MemoryLockManager.Unlock(token); err != nil {
+		return err
+	}
+
+	return m.publish("unlock", &pydio.Lock{Token: token})
+}
+
+// Stop listening to the other instances
+func (m *LockManager) Stop() {
+	m.stop()
+}
+
+// claim the node for the new lock, held locally, and wait for the claim to
+// go round the instances. The claims of the others received meanwhile win
+func (m *LockManager) claim(ctx context.Context, node *pydio.Node, l *pydio.Lock) error {
+	back := make(chan struct{})
+
+	m.mu.Lock()
+	m.claims[l.Token] = back
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.claims, l.Token)
+		m.mu.Unlock()
+	}()
+
+	if err := m.publish("lock", l); err != nil {
+		m.MemoryLockManager.Unlock(l.Token)
+		return err
+	}
+
+	timer := time.NewTimer(LockClaimTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-back:
+	case <-timer.C:
+		m.Unlock(l.Token)
+		return ErrLockNotShared
+	case <-ctx.Done():
+		m.Unlock(l.Token)
+		return ctx.Err()
+	}
+
+	if err := m.Check(node, l.Token); err != nil {
+		return pydio.ErrLocked
+	}
+
+	return nil
+}
+
+func (m *LockManager) publish(action string, l *pydio.Lock) error {
+	b, err := json.Marshal(&lockEvent{
+		Origin: m.id,
+		Action: action,
+		Lock:   l,
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.bus.Publish(Message{
+		Topic:   LockTopic,
+		Content: b,
+	})
+}
+
+func (m *LockManager) handle(message *nsq.Message) error {
+	var e lockEvent
+	if err := json.Unmarshal(message.Body, &e); err != nil {
+		log.Errorln("[com] Could not decode lock event", err)
+		return nil
+	}
+
+	if e.Origin == m.id {
+		if e.Action == "lock" && e.Lock != nil {
+			m.mu.Lock()
+			if back, ok := m.claims[e.Lock.Token]; ok {
+				close(back)
+				delete(m.claims, e.Lock.Token)
+			}
+			m.mu.Unlock()
+		}
+
+		return nil
+	}
+
+	switch e.Action {
+	case "sync":
+		for _, l := range m.Locks() {
+			if err := m.publish("held", l); err != nil {
+				log.Errorln("[com] Could not share lock", err)
+			}
+		}
+	}
+
+	if e.Lock == nil {
+		return nil
+	}
+
+	switch e.Action {
+	case "lock":
+		// Only a claim that has not gone round yet gives way
+		m.mu.Lock()
+		m.Claim(e.Lock, func(current *pydio.Lock) bool {
+			_, ok := m.claims[current.Token]
+			return ok
+		})
+		m.mu.Unlock()
+	case "held":
+		m.Put(e.Lock)
+	case "unlock":
+		m.Remove(e.Lock.Token)
+	}
+
+	return nil
+}
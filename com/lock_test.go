@@ -0,0 +1,206 @@
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package com
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/pydio/pydio-booster/io"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// memoryBus delivers the events to all the subscribers in the order they
+// are published, as an NSQ topic does
+type memoryBus struct {
+	mu       sync.Mutex
+	channels map[string]chan *nsq.Message
+}
+
+func newMemoryBus() *memoryBus {
+	return &memoryBus{channels: make(map[string]chan *nsq.Message)}
+}
+
+func (b *memoryBus) Subscribe(channel string, handler func(*nsq.Message) error) (func(), error) {
+	messages := make(chan *nsq.Message, 1000)
+	done := make(chan struct{})
+
+	b.mu.Lock()
+	b.channels[channel] = messages
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case m := <-messages:
+				handler(m)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.channels, channel)
+			b.mu.Unlock()
+
+			close(done)
+		})
+	}, nil
+}
+
+func (b *memoryBus) Publish(m Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, messages := range b.channels {
+		messages <- &nsq.Message{Body: m.Content}
+	}
+
+	return nil
+}
+
+// eventually the condition is true, or false after a few seconds
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+
+	return cond()
+}
+
+func TestLockManager(t *testing.T) {
+
+	bus := newMemoryBus()
+	node := pydio.NewNode("my-files", "folder", "file.txt")
+
+	a, _ := newLockManager(bus)
+	defer a.Stop()
+
+	b, _ := newLockManager(bus)
+	defer b.Stop()
+
+	var winner *pydio.Lock
+
+	Convey("The instances listen to the locks on channels deleted with them", t, func() {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+
+		So(bus.channels, ShouldHaveLength, 2)
+		for channel := range bus.channels {
+			So(strings.HasSuffix(channel, "#ephemeral"), ShouldBeTrue)
+		}
+	})
+
+	Convey("Two instances locking the same node agree on a single lock", t, func() {
+		type result struct {
+			lock *pydio.Lock
+			err  error
+		}
+
+		results := make(chan result, 2)
+		for _, m := range []*LockManager{a, b} {
+			go func(m *LockManager) {
+				l, err := m.Lock(node, "admin", 0)
+				results <- result{l, err}
+			}(m)
+		}
+
+		first, second := <-results, <-results
+		if first.err != nil {
+			first, second = second, first
+		}
+
+		So(first.err, ShouldBeNil)
+		So(second.err, ShouldEqual, pydio.ErrLocked)
+
+		winner = first.lock
+
+		So(eventually(func() bool {
+			return a.Check(node, winner.Token) == nil && b.Check(node, winner.Token) == nil
+		}), ShouldBeTrue)
+	})
+
+	Convey("A new instance gets the locks already held", t, func() {
+		c, err := newLockManager(bus)
+		So(err, ShouldBeNil)
+		defer c.Stop()
+
+		_, err = c.Lock(node, "admin", 0)
+		So(err, ShouldEqual, pydio.ErrLocked)
+		So(c.Get(node).Token, ShouldEqual, winner.Token)
+	})
+
+	Convey("Unlocking a node releases it everywhere", t, func() {
+		So(a.Unlock(winner.Token), ShouldBeNil)
+
+		So(eventually(func() bool { return a.Get(node) == nil && b.Get(node) == nil }), ShouldBeTrue)
+	})
+
+	Convey("Transient locks of several instances serialize the writers", t, func() {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+
+		writing, written := 0, 0
+		overlapped := false
+
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+
+			go func(m *LockManager) {
+				defer wg.Done()
+
+				l, err := m.Acquire(context.Background(), node, "admin")
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				writing++
+				overlapped = overlapped || writing > 1
+				mu.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+
+				mu.Lock()
+				writing--
+				written++
+				mu.Unlock()
+
+				m.Unlock(l.Token)
+			}([]*LockManager{a, b}[i%2])
+		}
+
+		wg.Wait()
+
+		So(written, ShouldEqual, 6)
+		So(overlapped, ShouldBeFalse)
+	})
+}
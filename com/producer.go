@@ -48,6 +48,10 @@ func NewProducer() error {
 
 // Publish a message to the standard communication channel
 func Publish(m Message) error {
+	if producer == nil {
+		return errors.New("NSQ producer is not started")
+	}
+
 	err := producer.Publish(m.Topic, m.Content)
 
 	return err
@@ -49,6 +49,23 @@ type LogConf struct {
 type NsqConf struct {
 	Host string
 	Port int
+
+	// Locks shares the node locks with the other instances
+	Locks bool
+
+	// Backlog of instant messages kept per repository for the websocket
+	// clients that reconnect. No backlog is kept if zero
+	Backlog int
+
+	// Queue of the messages waiting for each websocket client
+	Queue QueueConf
+}
+
+// QueueConf definition for the outbound queue of a websocket client.
+// Policy is drop_oldest, coalesce or disconnect, applied when the queue is full
+type QueueConf struct {
+	Size   int
+	Policy string
 }
 
 // SchedulerConf definition
@@ -59,6 +76,51 @@ type SchedulerConf struct {
 	Minutes int
 }
 
+// ClientConf definition for the http client used to reach the Pydio backend.
+// Timeouts are expressed in seconds, 0 meaning the default value is used
+type ClientConf struct {
+	// CAFile is a PEM bundle of the authorities trusted for the backend certificate
+	CAFile string
+
+	// CertFile and KeyFile are the client certificate used for mutual TLS
+	CertFile string
+	KeyFile  string
+
+	// InsecureSkipVerify disables the verification of the backend certificate
+	InsecureSkipVerify bool
+
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       int
+	DialTimeout           int
+	TLSHandshakeTimeout   int
+	ResponseHeaderTimeout int
+}
+
+// TokensConf definition for the tokens of the users and options sent by
+// Pydio. Keys are the secrets of the encrypted tokens, the current one
+// first so that the previous ones still decrypt during a rotation. Legacy
+// accepts the signed only tokens, checked with LegacySecret
+type TokensConf struct {
+	Keys         []string
+	Legacy       bool
+	LegacySecret string
+}
+
+// EventConf definition for an event the websocket clients may publish, to
+// the NSQ topic. Write events require a writable repository
+type EventConf struct {
+	Topic string
+	Write bool
+}
+
+// NormalizationConf definition for the paths of a repository.
+// Form is nfc, nfd or empty to keep the paths as sent by the clients
+type NormalizationConf struct {
+	Form     string
+	FoldCase bool
+}
+
 // Configurer interface
 type Configurer interface {
 	GetCaddyFilePath() string
@@ -20,23 +20,38 @@
 package pydhttp
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
-	"io"
-	"io/ioutil"
-	"time"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 
-	"golang.org/x/net/context"
+	pydio "github.com/pydio/pydio-booster/io"
 )
 
-// ContextValue Pipe and buffer
-type ContextValue struct {
-	reader io.Reader
-	writer io.Writer
+var (
+	// ErrNotInContext is returned when no value was set for a key
+	ErrNotInContext = errors.New("No value in context for this key")
 
-	closed bool
+	// ErrNoValue is returned when a value was closed without being resolved
+	ErrNoValue = errors.New("Value closed before being resolved")
+)
+
+type contextKey string
+
+// Value set in the context by a middleware and resolved later on by a job.
+// The value is either a typed object or raw encoded data (json or xml)
+type Value struct {
+	done chan struct{}
+	once sync.Once
 
-	buf []byte
-	off int64
+	data interface{}
+	err  error
 }
 
 // Decoder for the context value
@@ -49,88 +64,284 @@ type Encoder interface {
 	Encode(interface{}) error
 }
 
-// NewContext with the key value
-func NewContext(ctx context.Context, key interface{}, value interface{}) context.Context {
-	return context.WithValue(ctx, key, value)
+// NewValue that still needs to be resolved
+func NewValue() *Value {
+	return &Value{
+		done: make(chan struct{}),
+	}
 }
 
-// FromContext value of the given key
-func FromContext(ctx context.Context, key interface{}, value interface{}) (err error) {
+// NewContext with the key value. If the value is not a *Value, it is
+// considered as already resolved
+func NewContext(ctx context.Context, key string, value interface{}) context.Context {
+	v, ok := value.(*Value)
+	if !ok {
+		v = NewValue()
+		v.Resolve(value)
+	}
 
-	c1 := make(chan error, 1)
-	go func() {
-		if reader, ok := ctx.Value(key).(io.Reader); ok {
-			if writer, ok := value.(io.Writer); ok {
-				_, err = io.Copy(writer, reader)
-				c1 <- err
-			} else {
-				c1 <- errors.New("Context value receiver is not a writer")
-			}
-		} else {
-			c1 <- errors.New("Context value is not a reader")
-		}
-	}()
+	return context.WithValue(ctx, contextKey(key), v)
+}
 
-	select {
-	case err = <-c1:
-	case <-time.After(time.Second * 30):
-		err = errors.New("Cannot convert to io.Reader - Timed out")
+// ValueFromContext retrieves the value of the given key, resolved or not
+func ValueFromContext(ctx context.Context, key string) (*Value, bool) {
+	v, ok := ctx.Value(contextKey(key)).(*Value)
+	return v, ok
+}
+
+// FromContext waits for the value of the given key and decodes it into out
+func FromContext(ctx context.Context, key string, out interface{}) error {
+	v, ok := ValueFromContext(ctx, key)
+	if !ok {
+		return ErrNotInContext
 	}
 
-	return
+	return v.Decode(ctx, out)
 }
 
-// NewContextValue object
-func NewContextValue() *ContextValue {
+// NodeFromContext waits for the node sitting in the context
+func NodeFromContext(ctx context.Context) (*pydio.Node, error) {
+	var node *pydio.Node
+	if err := FromContext(ctx, "node", &node); err != nil {
+		return nil, err
+	}
 
-	reader, writer := io.Pipe()
+	return node, nil
+}
 
-	return &ContextValue{
-		reader: reader,
-		writer: writer,
+// OptionsFromContext waits for the options sitting in the context. Options
+// sent as a token are decrypted with the default keyring
+func OptionsFromContext(ctx context.Context) (*pydio.Options, error) {
+	v, ok := ValueFromContext(ctx, "options")
+	if !ok {
+		return nil, ErrNotInContext
+	}
+
+	data, err := v.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if token, ok := tokenFromData(data); ok {
+		return pydio.NewOptionsFromToken(token, pydio.DefaultKeyring())
 	}
-}
 
-// Read a context value, first from the Pipe if it hasn't been read,
-// then from the buffer
-func (c *ContextValue) Read(p []byte) (n int, err error) {
+	var options *pydio.Options
+	if err := decode(data, &options); err != nil {
+		return nil, err
+	}
 
-	if !c.closed {
-		var data []byte
+	return options, nil
+}
+
+// userValue accepts both a user and the <tree><user/></tree> response sent by Pydio
+type userValue struct {
+	pydio.User
+	Tree *pydio.User `xml:"user" json:"-"`
+}
 
-		data, _ = ioutil.ReadAll(c.reader)
+// UserFromContext waits for the user sitting in the context
+func UserFromContext(ctx context.Context) (*pydio.User, error) {
+	v, ok := ValueFromContext(ctx, "user")
+	if !ok {
+		return nil, ErrNotInContext
+	}
 
-		n = len(data)
+	data, err := v.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		c.buf = make([]byte, n)
-		copy(c.buf, data)
+	switch user := data.(type) {
+	case *pydio.User:
+		return user, nil
+	case pydio.User:
+		return &user, nil
+	}
 
-		go c.Close()
+	if token, ok := tokenFromData(data); ok {
+		return pydio.NewUserFromToken(token, pydio.DefaultKeyring())
 	}
 
-	n = copy(p, c.buf[c.off:])
-	c.off += int64(n)
+	var u userValue
+	if err := decode(data, &u); err != nil {
+		return nil, err
+	}
 
-	if n < len(p) {
-		err = io.EOF
-		c.off = 0
+	if u.Tree != nil {
+		return u.Tree, nil
 	}
 
-	return
+	return &u.User, nil
 }
 
-// Close the pipe writer
-func (c *ContextValue) Close() error {
-	c.closed = true
+// AuthFromContext waits for the auth params sitting in the context
+func AuthFromContext(ctx context.Context) (*Auth, error) {
+	var auth *Auth
+	if err := FromContext(ctx, "auth", &auth); err != nil {
+		return nil, err
+	}
 
-	if pipeWriter, ok := c.writer.(*io.PipeWriter); ok {
-		return pipeWriter.Close()
+	return auth, nil
+}
+
+// TokenFromContext waits for the token sitting in the context
+func TokenFromContext(ctx context.Context) (*Token, error) {
+	var token *Token
+	if err := FromContext(ctx, "token", &token); err != nil {
+		return nil, err
 	}
 
+	return token, nil
+}
+
+// Resolve the value. Only the first call is taken into account
+func (v *Value) Resolve(data interface{}) {
+	v.once.Do(func() {
+		v.data = data
+		close(v.done)
+	})
+}
+
+// Reject the value with an error. Only the first call is taken into account
+func (v *Value) Reject(err error) {
+	v.once.Do(func() {
+		v.err = err
+		close(v.done)
+	})
+}
+
+// Encode resolves the value, so that a Value can be given as an encoder to a job
+func (v *Value) Encode(data interface{}) error {
+	v.Resolve(data)
+	return nil
+}
+
+// Close the value, waiters receive ErrNoValue if it hasn't been resolved
+func (v *Value) Close() error {
+	v.Reject(ErrNoValue)
 	return nil
 }
 
-// Write content to the pipe end
-func (c *ContextValue) Write(p []byte) (n int, err error) {
-	return c.writer.Write(p)
+// Wait for the value to be resolved or the context to be done
+func (v *Value) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-v.done:
+		return v.data, v.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Decode waits for the value and decodes it into out
+func (v *Value) Decode(ctx context.Context, out interface{}) error {
+	data, err := v.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	return decode(data, out)
+}
+
+// tokenFromData returns the token of raw data, possibly json encoded
+func tokenFromData(data interface{}) (string, bool) {
+	var str string
+
+	switch raw := data.(type) {
+	case []byte:
+		str = string(raw)
+	case string:
+		str = raw
+	default:
+		return "", false
+	}
+
+	str = strings.TrimSpace(str)
+
+	if unquoted, err := strconv.Unquote(str); err == nil {
+		str = unquoted
+	}
+
+	return str, pydio.IsToken(str)
+}
+
+// decode data into out, assigning it directly when the types match
+func decode(data interface{}, out interface{}) error {
+	target := reflect.ValueOf(out)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("Decoding target must be a non nil pointer")
+	}
+
+	if data == nil {
+		return ErrNoValue
+	}
+
+	src := reflect.ValueOf(data)
+
+	// Walking down the target pointers until we find a matching type
+	for t := target; t.Kind() == reflect.Ptr; t = t.Elem() {
+		elem := t.Type().Elem()
+
+		switch {
+		case src.Type().AssignableTo(elem):
+			t.Elem().Set(src)
+			return nil
+		case src.Kind() == reflect.Ptr && !src.IsNil() && src.Elem().Type().AssignableTo(elem):
+			t.Elem().Set(src.Elem())
+			return nil
+		}
+
+		if elem.Kind() != reflect.Ptr {
+			break
+		}
+
+		if t.Elem().IsNil() {
+			t.Elem().Set(reflect.New(elem.Elem()))
+		}
+	}
+
+	switch raw := data.(type) {
+	case []byte:
+		return unmarshal(raw, out)
+	case string:
+		return unmarshal([]byte(raw), out)
+	}
+
+	// Different types, going through their json representation
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, out)
+}
+
+// unmarshal raw json or xml data
+func unmarshal(b []byte, out interface{}) error {
+	b = bytes.TrimSpace(b)
+
+	if len(b) == 0 {
+		return ErrNoValue
+	}
+
+	switch b[0] {
+	case '<':
+		return xml.Unmarshal(b, out)
+	case '"':
+		// Encoded string, possibly containing an encoded object
+		str, err := strconv.Unquote(string(b))
+		if err != nil {
+			return err
+		}
+
+		if err := unmarshal([]byte(str), out); err == nil {
+			return nil
+		}
+	}
+
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("Could not decode value : %v", err)
+	}
+
+	return nil
 }
@@ -20,25 +20,16 @@
 package pydhttp
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"io"
-	"strings"
+	"strconv"
 	"testing"
+	"time"
 
 	pydio "github.com/pydio/pydio-booster/io"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-type key string
-
-const (
-	firstKey  key = "first"
-	secondKey key = "second"
-	thirdKey  key = "third"
-)
-
 var (
 	ctx  context.Context
 	str  string
@@ -52,73 +43,152 @@ func init() {
 }
 
 func TestContext(t *testing.T) {
+
 	Convey("Writing a string in the context and reading it back", t, func() {
 		var localStr string
 
-		// Creating context value (pipe)
-		v := NewContextValue()
-		ctx = context.WithValue(ctx, firstKey, v)
+		v := NewValue()
+		ctx := NewContext(ctx, "first", v)
 
-		// Go Routine that will write the string (strings Reader)
+		// Go Routine that will resolve the string later on
 		go func() {
-			io.Copy(v, strings.NewReader(str))
+			v.Encode(str)
 			v.Close()
 		}()
 
-		// Reading from the context value and write it to a local node (buffer)
-		buf := bytes.NewBufferString(localStr)
-		err := FromContext(ctx, firstKey, buf)
-
+		err := FromContext(ctx, "first", &localStr)
 		So(err, ShouldBeNil)
-		So(buf.String(), ShouldEqual, str)
+		So(localStr, ShouldEqual, str)
 	})
 
 	Convey("Writing a node in the context and reading it back", t, func() {
-		var localJSON string
-		var localNode *pydio.Node
+		v := NewValue()
+		ctx := NewContext(ctx, "node", v)
+
+		go func() {
+			v.Encode(*node)
+			v.Close()
+		}()
+
+		localNode, err := NodeFromContext(ctx)
+		So(err, ShouldBeNil)
+		So(localNode, ShouldResemble, node)
+	})
 
-		// Creating context value (pipe)
-		v := NewContextValue()
-		ctx = context.WithValue(ctx, secondKey, v)
+	Convey("Writing an encoded node in the context and reading it back", t, func() {
+		v := NewValue()
+		ctx := NewContext(ctx, "node", v)
 
-		// Go Routine that will write the node (encoding)
 		go func() {
-			enc := json.NewEncoder(v)
-			enc.Encode(node)
+			b, _ := json.Marshal(node)
+			v.Encode(string(b))
 			v.Close()
 		}()
 
-		// Reading from the context value and write it to a local node (decoding)
-		buf := bytes.NewBufferString(localJSON)
-		err := FromContext(ctx, secondKey, buf)
+		localNode, err := NodeFromContext(ctx)
 		So(err, ShouldBeNil)
+		So(localNode, ShouldResemble, node)
+	})
+
+	Convey("Writing a string in context and reading it back as a node", t, func() {
+		var localStr string
+
+		ctx := NewContext(ctx, "third", str)
 
-		dec := json.NewDecoder(buf)
-		err = dec.Decode(&localNode)
+		err := FromContext(ctx, "third", &localStr)
 		So(err, ShouldBeNil)
 
-		// Tests
+		localNode := pydio.NewNode(localStr)
 		So(localNode, ShouldResemble, node)
 	})
 
-	Convey("Writing a string in context and reading it back as a node", t, func() {
-		var localJSON string
-		var localNode *pydio.Node
+	Convey("Reading options encoded as a quoted json string", t, func() {
+		ctx := NewContext(ctx, "options", `"{\"PATH\":\"/tmp/file\",\"OPTIONS\":{\"TYPE\":\"fs\",\"PATH\":\"/data\"}}"`)
 
-		v := NewContextValue()
-		ctx = context.WithValue(ctx, thirdKey, v)
+		options, err := OptionsFromContext(ctx)
+		So(err, ShouldBeNil)
+		So(options.Path, ShouldEqual, "/tmp/file")
+		So(options.FileOptions.Type, ShouldEqual, "fs")
+		So(options.FileOptions.Path, ShouldEqual, "/data")
+	})
 
-		go func() {
-			io.Copy(v, strings.NewReader(str))
-			v.Close()
-		}()
+	Convey("Reading a user sent back as xml by Pydio", t, func() {
+		ctx := NewContext(ctx, "user", `<?xml version="1.0" encoding="UTF-8"?>
+			<tree>
+				<user groupPath="/" id="admin">
+					<repositories><repo id="1" acl="rw"></repo></repositories>
+				</user>
+			</tree>`)
 
-		buf := bytes.NewBufferString(localJSON)
-		err := FromContext(ctx, thirdKey, buf)
+		user, err := UserFromContext(ctx)
 		So(err, ShouldBeNil)
+		So(user.ID, ShouldEqual, "admin")
+		So(user.GroupPath, ShouldEqual, "/")
+		So(user.Repos, ShouldResemble, []pydio.Repo{{ID: "1", ACL: "rw"}})
+	})
 
-		localNode = pydio.NewNode(buf.String())
+	Convey("Reading a typed user", t, func() {
+		ctx := NewContext(ctx, "user", &pydio.User{ID: "admin"})
 
-		So(localNode, ShouldResemble, node)
+		user, err := UserFromContext(ctx)
+		So(err, ShouldBeNil)
+		So(user.ID, ShouldEqual, "admin")
+	})
+
+	Convey("Reading options and user sent as encrypted tokens", t, func() {
+		keys := pydio.NewKeyring("current", "previous")
+		pydio.SetKeyring(keys)
+		defer pydio.SetKeyring(&pydio.Keyring{})
+
+		optionsToken, err := pydio.NewKeyring("previous").Encrypt("options", &pydio.Options{Path: "/tmp/file"}, 1)
+		So(err, ShouldBeNil)
+
+		userToken, err := keys.Encrypt("user", &pydio.User{ID: "admin"}, 1)
+		So(err, ShouldBeNil)
+
+		ctx := NewContext(ctx, "options", []byte(optionsToken))
+		ctx = NewContext(ctx, "user", strconv.Quote(userToken))
+
+		options, err := OptionsFromContext(ctx)
+		So(err, ShouldBeNil)
+		So(options.Path, ShouldEqual, "/tmp/file")
+
+		user, err := UserFromContext(ctx)
+		So(err, ShouldBeNil)
+		So(user.ID, ShouldEqual, "admin")
+
+		Convey("signed only tokens are refused without a legacy secret", func() {
+			keys.Legacy = true
+
+			ctx := NewContext(ctx, "user", "aaa.bbb.ccc")
+
+			_, err := UserFromContext(ctx)
+			So(err, ShouldEqual, pydio.ErrLegacyToken)
+		})
+	})
+
+	Convey("Reading a value that is never resolved", t, func() {
+		Convey("returns an error once the value is closed", func() {
+			v := NewValue()
+			ctx := NewContext(ctx, "node", v)
+
+			go v.Close()
+
+			_, err := NodeFromContext(ctx)
+			So(err, ShouldEqual, ErrNoValue)
+		})
+
+		Convey("returns an error once the context is cancelled", func() {
+			ctx, cancel := context.WithTimeout(NewContext(ctx, "node", NewValue()), 10*time.Millisecond)
+			defer cancel()
+
+			_, err := NodeFromContext(ctx)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+		})
+
+		Convey("returns an error if the key is not in the context", func() {
+			_, err := NodeFromContext(ctx)
+			So(err, ShouldEqual, ErrNotInContext)
+		})
 	})
 }
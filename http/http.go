@@ -22,10 +22,17 @@ package pydhttp
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
+	pydconf "github.com/pydio/pydio-booster/conf"
 	"github.com/pydio/pydio-booster/log"
 )
 
@@ -34,14 +41,53 @@ var (
 	ErrRedirectViaPydioClient = errors.New("Redirection is handled by the Pydio HTTP Client")
 )
 
+// Default values of the client configuration
+const (
+	DefaultMaxIdleConns          = 100
+	DefaultMaxIdleConnsPerHost   = 10
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultDialTimeout           = 30 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultResponseHeaderTimeout = 5 * time.Minute
+)
+
+var (
+	defaultClient     *Client
+	defaultClientLock sync.RWMutex
+)
+
 // Client extension to the http client
 type Client http.Client
 
-// NewClient with Redirection handling
+// NewClient with Redirection handling and the default configuration
 func NewClient() *Client {
+	client, _ := NewClientFromConf(&pydconf.ClientConf{})
+
+	return client
+}
+
+// NewClientFromConf returns a client with Redirection handling, configured for the backend
+func NewClientFromConf(conf *pydconf.ClientConf) (*Client, error) {
+
+	tlsConfig, err := newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   seconds(conf.DialTimeout, DefaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
 
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          positive(conf.MaxIdleConns, DefaultMaxIdleConns),
+		MaxIdleConnsPerHost:   positive(conf.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost),
+		IdleConnTimeout:       seconds(conf.IdleConnTimeout, DefaultIdleConnTimeout),
+		TLSHandshakeTimeout:   seconds(conf.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: seconds(conf.ResponseHeaderTimeout, DefaultResponseHeaderTimeout),
 	}
 
 	return &Client{
@@ -49,7 +95,93 @@ func NewClient() *Client {
 		CheckRedirect: func(r *http.Request, via []*http.Request) error {
 			return ErrRedirectViaPydioClient
 		},
+	}, nil
+}
+
+// SetDefaultClient configures the client shared by all the backend calls
+func SetDefaultClient(conf *pydconf.ClientConf) error {
+	client, err := NewClientFromConf(conf)
+	if err != nil {
+		return err
 	}
+
+	if conf.InsecureSkipVerify {
+		log.Errorln("WARNING : the backend certificate will not be verified")
+	}
+
+	defaultClientLock.Lock()
+	defer defaultClientLock.Unlock()
+
+	defaultClient = client
+
+	return nil
+}
+
+// DefaultClient shared by all the backend calls
+func DefaultClient() *Client {
+	defaultClientLock.RLock()
+	client := defaultClient
+	defaultClientLock.RUnlock()
+
+	if client != nil {
+		return client
+	}
+
+	defaultClientLock.Lock()
+	defer defaultClientLock.Unlock()
+
+	if defaultClient == nil {
+		defaultClient = NewClient()
+	}
+
+	return defaultClient
+}
+
+func newTLSConfig(conf *pydconf.ClientConf) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+	}
+
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in %s", conf.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func seconds(value int, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+
+	return time.Duration(value) * time.Second
+}
+
+func positive(value int, def int) int {
+	if value <= 0 {
+		return def
+	}
+
+	return value
 }
 
 // Do the Request through Client
@@ -20,12 +20,17 @@
 package pydhttp
 
 import (
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"testing"
 
+	pydconf "github.com/pydio/pydio-booster/conf"
+
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -83,3 +88,41 @@ func TestAPI(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 }
+
+func TestClient(t *testing.T) {
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	Convey("The default client verifies the backend certificate", t, func() {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+
+		_, err := NewClient().Do(req)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A client trusting the backend CA bundle", t, func() {
+		ca, err := ioutil.TempFile("", "pydio-ca")
+		So(err, ShouldBeNil)
+		defer os.Remove(ca.Name())
+
+		pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		ca.Close()
+
+		client, err := NewClientFromConf(&pydconf.ClientConf{CAFile: ca.Name()})
+		So(err, ShouldBeNil)
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+
+		resp, err := client.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+	})
+
+	Convey("A missing CA bundle is an error", t, func() {
+		_, err := NewClientFromConf(&pydconf.ClientConf{CAFile: "/nonexistent/ca.pem"})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,387 @@
+// Package pydhttp contains all http related work
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydhttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+var (
+	// ErrInactiveToken is returned when the authorization server does not know the token
+	ErrInactiveToken = errors.New("OAuth token is not active")
+
+	// ErrUnknownKey is returned when the token was signed by a key missing from the JWKS
+	ErrUnknownKey = errors.New("OAuth token signed by an unknown key")
+
+	// ErrInvalidClaims is returned when the expiry, issuer or audience of the token do not match
+	ErrInvalidClaims = errors.New("OAuth token claims are not valid")
+)
+
+// DefaultOAuthCacheTTL during which a validated token is not checked again
+const DefaultOAuthCacheTTL = 5 * time.Minute
+
+// minimum delay between two JWKS refreshes triggered by an unknown key
+const jwksRefreshDelay = time.Minute
+
+// Claims of an OAuth access token
+type Claims map[string]interface{}
+
+// OAuth validates the bearer tokens either by introspection (RFC 7662)
+// or locally with the keys of the authorization server (JWKS)
+type OAuth struct {
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+
+	JWKSURL  string
+	Issuer   string
+	Audience string
+
+	CacheTTL time.Duration
+
+	lock  sync.Mutex
+	cache map[string]cachedClaims
+
+	keysLock    sync.Mutex
+	keys        *jose.JsonWebKeySet
+	keysFetched time.Time
+}
+
+type cachedClaims struct {
+	claims Claims
+	expiry time.Time
+}
+
+// BearerToken sent with the request, in the Authorization header or the access_token query argument
+func BearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	return r.URL.Query().Get("access_token")
+}
+
+// Validate the token and returns its claims
+func (o *OAuth) Validate(ctx context.Context, token string) (Claims, error) {
+	if token == "" {
+		return nil, ErrInactiveToken
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if claims, ok := o.cached(key); ok {
+		return claims, nil
+	}
+
+	var claims Claims
+	var err error
+
+	if o.JWKSURL != "" {
+		claims, err = o.verify(ctx, token)
+	} else {
+		claims, err = o.introspect(ctx, token)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	o.store(key, claims)
+
+	return claims, nil
+}
+
+func (o *OAuth) cached(key string) (Claims, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	c, ok := o.cache[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(c.expiry) {
+		delete(o.cache, key)
+		return nil, false
+	}
+
+	return c.claims, true
+}
+
+func (o *OAuth) store(key string, claims Claims) {
+	ttl := o.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultOAuthCacheTTL
+	}
+
+	expiry := time.Now().Add(ttl)
+
+	// Never keeping a token longer than its own expiry
+	if exp, ok := claims.Int("exp"); ok && time.Unix(exp, 0).Before(expiry) {
+		expiry = time.Unix(exp, 0)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.cache == nil {
+		o.cache = make(map[string]cachedClaims)
+	}
+
+	now := time.Now()
+	for k, c := range o.cache {
+		if now.After(c.expiry) {
+			delete(o.cache, k)
+		}
+	}
+
+	o.cache[key] = cachedClaims{claims, expiry}
+}
+
+// introspect the token with the authorization server
+func (o *OAuth) introspect(ctx context.Context, token string) (Claims, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequest("POST", o.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.ClientID != "" {
+		req.SetBasicAuth(o.ClientID, o.ClientSecret)
+	}
+	SetRequestID(ctx, req)
+
+	resp, err := DefaultClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Introspection failed : %s", resp.Status)
+	}
+
+	var claims Claims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		return nil, ErrInactiveToken
+	}
+
+	return claims, nil
+}
+
+// verify the token signature with the keys of the authorization server
+func (o *OAuth) verify(ctx context.Context, token string) (Claims, error) {
+	object, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(object.Signatures) != 1 {
+		return nil, ErrUnknownKey
+	}
+
+	header := object.Signatures[0].Header
+	if strings.HasPrefix(header.Algorithm, "HS") || header.Algorithm == "none" {
+		// Symmetric keys are never published, refusing them avoids algorithm confusion
+		return nil, ErrUnknownKey
+	}
+
+	key, err := o.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := object.Verify(key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+
+	// Nobody can revoke a token checked locally, it must expire
+	if _, ok := claims.Int("exp"); !ok {
+		return nil, ErrInvalidClaims
+	}
+
+	return claims, nil
+}
+
+// key with the given id, refreshing the key set if it is unknown
+func (o *OAuth) key(ctx context.Context, kid string) (*jose.JsonWebKey, error) {
+	o.keysLock.Lock()
+	defer o.keysLock.Unlock()
+
+	if key := findKey(o.keys, kid); key != nil {
+		return key, nil
+	}
+
+	if time.Since(o.keysFetched) < jwksRefreshDelay {
+		return nil, ErrUnknownKey
+	}
+
+	keys, err := fetchJWKS(ctx, o.JWKSURL)
+	o.keysFetched = time.Now()
+	if err != nil {
+		return nil, err
+	}
+
+	o.keys = keys
+
+	if key := findKey(o.keys, kid); key != nil {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func findKey(keys *jose.JsonWebKeySet, kid string) *jose.JsonWebKey {
+	if keys == nil {
+		return nil
+	}
+
+	if kid != "" {
+		if found := keys.Key(kid); len(found) > 0 {
+			return &found[0]
+		}
+		return nil
+	}
+
+	// No key id, only possible if there is no ambiguity
+	if len(keys.Keys) == 1 {
+		return &keys.Keys[0]
+	}
+
+	return nil
+}
+
+func fetchJWKS(ctx context.Context, jwksURL string) (*jose.JsonWebKeySet, error) {
+	req, err := http.NewRequest("GET", jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	SetRequestID(ctx, req)
+
+	resp, err := DefaultClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not retrieve the keys : %s", resp.Status)
+	}
+
+	var keys jose.JsonWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+
+	return &keys, nil
+}
+
+func (o *OAuth) checkClaims(claims Claims) error {
+	now := time.Now().Unix()
+
+	if exp, ok := claims.Int("exp"); ok && now > exp {
+		return ErrInvalidClaims
+	}
+
+	if nbf, ok := claims.Int("nbf"); ok && now < nbf {
+		return ErrInvalidClaims
+	}
+
+	if o.Issuer != "" && claims.String("iss") != o.Issuer {
+		return ErrInvalidClaims
+	}
+
+	if o.Audience != "" && !claims.Has("aud", o.Audience) {
+		return ErrInvalidClaims
+	}
+
+	return nil
+}
+
+// String value of the claim
+func (c Claims) String(name string) string {
+	str, _ := c[name].(string)
+	return str
+}
+
+// Int value of the claim
+func (c Claims) Int(name string) (int64, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return int64(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	}
+
+	return 0, false
+}
+
+// Has checks if the claim is or contains the value
+func (c Claims) Has(name string, value string) bool {
+	switch v := c[name].(type) {
+	case string:
+		return v == value
+	case []interface{}:
+		for _, elem := range v {
+			if elem == value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydhttp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// fakeAuthServer answers introspection requests and publishes its keys
+func fakeAuthServer(key *rsa.PrivateKey, calls *int32) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+
+		if id, secret, _ := r.BasicAuth(); id != "booster" || secret != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		claims := map[string]interface{}{"active": false}
+		if r.FormValue("token") == "valid" {
+			claims = map[string]interface{}{
+				"active": true,
+				"sub":    "alice",
+				"iss":    "https://auth.pydio.dev",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+			}
+		}
+
+		json.NewEncoder(w).Encode(claims)
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JsonWebKeySet{
+			Keys: []jose.JsonWebKey{{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"}},
+		})
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func signClaims(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.RS256, &jose.JsonWebKey{Key: key, KeyID: kid})
+	So(err, ShouldBeNil)
+
+	payload, _ := json.Marshal(claims)
+	object, err := signer.Sign(payload)
+	So(err, ShouldBeNil)
+
+	token, err := object.CompactSerialize()
+	So(err, ShouldBeNil)
+
+	return token
+}
+
+func TestOAuth(t *testing.T) {
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var calls int32
+	server := fakeAuthServer(key, &calls)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	Convey("Introspecting a token", t, func() {
+		o := &OAuth{
+			IntrospectionURL: server.URL + "/introspect",
+			ClientID:         "booster",
+			ClientSecret:     "s3cr3t",
+			Issuer:           "https://auth.pydio.dev",
+		}
+
+		atomic.StoreInt32(&calls, 0)
+
+		claims, err := o.Validate(ctx, "valid")
+		So(err, ShouldBeNil)
+		So(claims.String("sub"), ShouldEqual, "alice")
+
+		// Second validation comes from the cache
+		_, err = o.Validate(ctx, "valid")
+		So(err, ShouldBeNil)
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+
+		_, err = o.Validate(ctx, "revoked")
+		So(err, ShouldEqual, ErrInactiveToken)
+	})
+
+	Convey("Introspecting with a wrong issuer", t, func() {
+		o := &OAuth{
+			IntrospectionURL: server.URL + "/introspect",
+			ClientID:         "booster",
+			ClientSecret:     "s3cr3t",
+			Issuer:           "https://other.pydio.dev",
+		}
+
+		_, err := o.Validate(ctx, "valid")
+		So(err, ShouldEqual, ErrInvalidClaims)
+	})
+
+	Convey("Verifying a token with the published keys", t, func() {
+		o := &OAuth{
+			JWKSURL:  server.URL + "/jwks",
+			Audience: "pydio",
+		}
+
+		token := signClaims(key, "k1", map[string]interface{}{
+			"sub": "bob",
+			"aud": []string{"pydio"},
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+
+		claims, err := o.Validate(ctx, token)
+		So(err, ShouldBeNil)
+		So(claims.String("sub"), ShouldEqual, "bob")
+
+		expired := signClaims(key, "k1", map[string]interface{}{
+			"sub": "bob",
+			"aud": "pydio",
+			"exp": time.Now().Add(-time.Hour).Unix(),
+		})
+
+		_, err = o.Validate(ctx, expired)
+		So(err, ShouldEqual, ErrInvalidClaims)
+
+		endless := signClaims(key, "k1", map[string]interface{}{"sub": "bob", "aud": "pydio"})
+
+		_, err = o.Validate(ctx, endless)
+		So(err, ShouldEqual, ErrInvalidClaims)
+
+		other, _ := rsa.GenerateKey(rand.Reader, 2048)
+		forged := signClaims(other, "k1", map[string]interface{}{"sub": "mallory", "aud": "pydio"})
+
+		_, err = o.Validate(ctx, forged)
+		So(err, ShouldNotBeNil)
+
+		unknown := signClaims(key, "k2", map[string]interface{}{"sub": "bob", "aud": "pydio"})
+
+		_, err = o.Validate(ctx, unknown)
+		So(err, ShouldEqual, ErrUnknownKey)
+	})
+
+	Convey("Reading the bearer token of a request", t, func() {
+		r := httptest.NewRequest("GET", "/io/my-files", nil)
+		r.Header.Set("Authorization", "Bearer abc")
+		So(BearerToken(r), ShouldEqual, "abc")
+
+		r = httptest.NewRequest("GET", "/io/my-files?access_token=def", nil)
+		So(BearerToken(r), ShouldEqual, "def")
+	})
+}
@@ -0,0 +1,94 @@
+// Package pydhttp contains all http related work
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydhttp
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/nu7hatch/gouuid"
+	pydiolog "github.com/pydio/pydio-booster/log"
+)
+
+// RequestIDHeader used to correlate a request across booster, Pydio and the logs
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// Request ids sent by the client are only kept if they are safe to log
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// NewRequestID generates a unique request id
+func NewRequestID() string {
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return randomString(32)
+	}
+
+	return u4.String()
+}
+
+// NewRequestIDContext returns a context carrying the request id
+func NewRequestIDContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id carried by the context, if any
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID makes sure the request carries a request id, either the one
+// received in the headers or a new one, and returns it in the response headers
+func WithRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	ctx := r.Context()
+
+	if RequestIDFromContext(ctx) != "" {
+		return r
+	}
+
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = NewRequestID()
+	}
+
+	w.Header().Set(RequestIDHeader, id)
+
+	return r.WithContext(NewRequestIDContext(ctx, id))
+}
+
+// SetRequestID forwards the request id of the context to an outgoing request
+func SetRequestID(ctx context.Context, req *http.Request) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+}
+
+// RequestLogger returns a logger adding the request id of the context to every line
+func RequestLogger(ctx context.Context, logger *pydiolog.Logger) *pydiolog.Logger {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return logger.WithPrefix("[" + id + "] ")
+	}
+
+	return logger
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestID(t *testing.T) {
+
+	Convey("A request without id gets a new one", t, func() {
+		w := httptest.NewRecorder()
+		r := WithRequestID(w, httptest.NewRequest("GET", "/io/my-files/file", nil))
+
+		id := RequestIDFromContext(r.Context())
+		So(id, ShouldNotBeEmpty)
+		So(w.Header().Get(RequestIDHeader), ShouldEqual, id)
+	})
+
+	Convey("A request id sent by the client is kept", t, func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/io/my-files/file", nil)
+		r.Header.Set(RequestIDHeader, "php-58a2c1f3.42")
+
+		r = WithRequestID(w, r)
+
+		So(RequestIDFromContext(r.Context()), ShouldEqual, "php-58a2c1f3.42")
+		So(w.Header().Get(RequestIDHeader), ShouldEqual, "php-58a2c1f3.42")
+	})
+
+	Convey("An invalid request id sent by the client is replaced", t, func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/io/my-files/file", nil)
+		r.Header.Set(RequestIDHeader, "bad id\n")
+
+		r = WithRequestID(w, r)
+
+		So(RequestIDFromContext(r.Context()), ShouldNotEqual, "bad id\n")
+		So(RequestIDFromContext(r.Context()), ShouldNotBeEmpty)
+	})
+
+	Convey("The request id is forwarded to outgoing requests", t, func() {
+		ctx := NewRequestIDContext(context.Background(), "abc")
+		req, _ := http.NewRequest("GET", "http://localhost/api", nil)
+
+		SetRequestID(ctx, req)
+
+		So(req.Header.Get(RequestIDHeader), ShouldEqual, "abc")
+	})
+}
@@ -30,12 +30,21 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
+var (
+	// ErrInvalidHash is returned when an auth_hash is not formatted as nonce:hmac
+	ErrInvalidHash = errors.New("Invalid auth hash")
+
+	// ErrHashMismatch is returned when an auth_hash was not signed by the token
+	ErrHashMismatch = errors.New("Auth hash does not match the token")
+)
+
 // Token public and private parts
 type Token struct {
 	T string
@@ -53,7 +62,7 @@ func NewToken(token string, password string) *Token {
 
 // NewTokenFromURLWithCookie retrieves a token pair by sending a message containing a cookie to a specific URL
 func NewTokenFromURLWithCookie(url *url.URL, cookie *http.Cookie) (token *Token, err error) {
-	client := NewClient()
+	client := DefaultClient()
 
 	req, err := http.NewRequest("GET", url.String(), nil)
 	req.AddCookie(cookie)
@@ -78,7 +87,7 @@ func NewTokenFromURLWithCookie(url *url.URL, cookie *http.Cookie) (token *Token,
 
 // NewTokenFromURLWithBasicAuth retrieves a token pair by sending a message with query arguments
 func NewTokenFromURLWithBasicAuth(url *url.URL, username string, password string) (token *Token, err error) {
-	client := NewClient()
+	client := DefaultClient()
 
 	req, err := http.NewRequest("GET", url.String(), nil)
 	req.SetBasicAuth(username, password)
@@ -116,10 +125,6 @@ func NewTokenFromJWT(str string, signatureSecret string) (token *Token, err erro
 		return nil, errors.New("Token has been tampered with")
 	}
 
-	if err != nil {
-		return nil, err
-	}
-
 	token = NewToken("", "")
 
 	claim := decryptedToken.Claims["token"]
@@ -130,9 +135,14 @@ func NewTokenFromJWT(str string, signatureSecret string) (token *Token, err erro
 	}
 
 	b, err := jwt.DecodeSegment(claimStr)
+	if err != nil {
+		return nil, err
+	}
 
 	// Unmarshalling to an Token Structure
-	err = json.Unmarshal(b, &token)
+	if err = json.Unmarshal(b, &token); err != nil {
+		return nil, err
+	}
 
 	return token, nil
 }
@@ -144,23 +154,15 @@ func (t *Token) GetQueryArgs(uri string) *Auth {
 		return nil
 	}
 
-	replacer := strings.NewReplacer("%2F", "/")
-
-	uri = replacer.Replace(url.PathEscape(uri))
-
 	b := randomString(10)
 
 	sha1 := sha1.New()
 	sha1.Write([]byte(b))
 
-	nonce := hex.EncodeToString(sha1.Sum(nil))
-	uri = strings.TrimRight(uri, "/")
-	msg := string(uri[:]) + ":" + string(nonce[:]) + ":" + t.P
-	hmac := hmac.New(sha256.New, []byte(t.T))
-	hmac.Write([]byte(msg))
-	hmacMsg := hex.EncodeToString(hmac.Sum(nil))
+	// The nonce starts with its issue time, signed with the rest
+	nonce := strconv.FormatInt(time.Now().Unix(), 10) + "-" + hex.EncodeToString(sha1.Sum(nil))
 
-	hash := nonce + ":" + hmacMsg
+	hash := nonce + ":" + t.sign(uri, nonce)
 
 	return &Auth{
 		Token: t.T,
@@ -168,6 +170,58 @@ func (t *Token) GetQueryArgs(uri string) *Auth {
 	}
 }
 
+// Verify the auth_hash sent by a client for the uri. The nonce is returned
+// so that the caller can make sure it is not replayed
+func (t *Token) Verify(uri string, hash string) (nonce string, err error) {
+
+	if t == nil {
+		return "", ErrInvalidHash
+	}
+
+	parts := strings.SplitN(hash, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", ErrInvalidHash
+	}
+
+	nonce = parts[0]
+
+	expected := t.sign(uri, nonce)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(parts[1]))) {
+		return "", ErrHashMismatch
+	}
+
+	return nonce, nil
+}
+
+// nonceTime is the time the nonce was issued at
+func nonceTime(nonce string) (time.Time, error) {
+	parts := strings.SplitN(nonce, "-", 2)
+	if len(parts) != 2 {
+		return time.Time{}, ErrInvalidHash
+	}
+
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidHash
+	}
+
+	return time.Unix(sec, 0), nil
+}
+
+// sign the uri with the nonce and the private part of the token
+func (t *Token) sign(uri string, nonce string) string {
+	replacer := strings.NewReplacer("%2F", "/")
+
+	uri = replacer.Replace(url.PathEscape(uri))
+	uri = strings.TrimRight(uri, "/")
+
+	msg := uri + ":" + nonce + ":" + t.P
+	hmac := hmac.New(sha256.New, []byte(t.T))
+	hmac.Write([]byte(msg))
+
+	return hex.EncodeToString(hmac.Sum(nil))
+}
+
 // JWT representation of the Token
 func (t *Token) JWT(signatureSecret string, hoursBeforeExpiry int) (string, error) {
 	payload, err := json.Marshal(t)
@@ -0,0 +1,201 @@
+// Package pydhttp contains all http related work
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownToken is returned when the auth_token is not in the store
+	ErrUnknownToken = errors.New("Unknown auth token")
+
+	// ErrReplayedNonce is returned when a nonce has already been used
+	ErrReplayedNonce = errors.New("Auth hash nonce has already been used")
+
+	// ErrExpiredNonce is returned when a nonce was not issued within the ttl
+	ErrExpiredNonce = errors.New("Auth hash nonce has expired")
+)
+
+// DefaultNonceTTL during which a nonce is valid, once
+const DefaultNonceTTL = 10 * time.Minute
+
+// TokenStore gives the private part of a token from its public part
+type TokenStore interface {
+	Get(token string) (*Token, error)
+}
+
+// MemoryTokenStore is a TokenStore kept in memory
+type MemoryTokenStore struct {
+	lock   sync.RWMutex
+	tokens map[string]*Token
+}
+
+// NewMemoryTokenStore with the given tokens
+func NewMemoryTokenStore(tokens ...*Token) *MemoryTokenStore {
+	s := &MemoryTokenStore{
+		tokens: make(map[string]*Token),
+	}
+
+	for _, token := range tokens {
+		s.Add(token)
+	}
+
+	return s
+}
+
+// NewTokenStoreFromFile reads a json list of tokens ([{"t": "public", "p": "private"}])
+func NewTokenStoreFromFile(filename string) (*MemoryTokenStore, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokens []*Token
+	if err := json.NewDecoder(file).Decode(&tokens); err != nil {
+		return nil, err
+	}
+
+	return NewMemoryTokenStore(tokens...), nil
+}
+
+// Add a token to the store
+func (s *MemoryTokenStore) Add(token *Token) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tokens[token.T] = token
+}
+
+// Get the token
+func (s *MemoryTokenStore) Get(token string) (*Token, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	t, ok := s.tokens[token]
+	if !ok {
+		return nil, ErrUnknownToken
+	}
+
+	return t, nil
+}
+
+// NonceCache remembers the nonces used until they expire. A nonce is only
+// valid within the ttl of its issue time, so it is never accepted again
+type NonceCache struct {
+	TTL time.Duration
+
+	lock   sync.Mutex
+	nonces map[string]time.Time
+	purge  time.Time
+}
+
+// NewNonceCache with nonces expiring after ttl
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	if ttl <= 0 {
+		ttl = DefaultNonceTTL
+	}
+
+	return &NonceCache{
+		TTL:    ttl,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Use the nonce issued at the given time
+func (c *NonceCache) Use(nonce string, issued time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+
+	// Allowing the same skew for the clocks of the clients
+	expiry := issued.Add(c.TTL)
+	if now.After(expiry) || issued.After(now.Add(c.TTL)) {
+		return ErrExpiredNonce
+	}
+
+	// Regularly removing the expired nonces
+	if now.After(c.purge) {
+		for n, expiry := range c.nonces {
+			if now.After(expiry) {
+				delete(c.nonces, n)
+			}
+		}
+		c.purge = now.Add(c.TTL)
+	}
+
+	if _, ok := c.nonces[nonce]; ok {
+		return ErrReplayedNonce
+	}
+
+	c.nonces[nonce] = expiry
+
+	return nil
+}
+
+// Verifier of the auth_token / auth_hash pairs sent by the clients
+type Verifier struct {
+	Store  TokenStore
+	Nonces *NonceCache
+}
+
+// NewVerifier based on a token store
+func NewVerifier(store TokenStore, ttl time.Duration) *Verifier {
+	return &Verifier{
+		Store:  store,
+		Nonces: NewNonceCache(ttl),
+	}
+}
+
+// Verify the auth for the uri and returns the matching token
+func (v *Verifier) Verify(uri string, auth *Auth) (*Token, error) {
+	if auth == nil || auth.Token == "" || auth.Hash == "" {
+		return nil, ErrInvalidHash
+	}
+
+	token, err := v.Store.Get(auth.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := token.Verify(uri, auth.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	issued, err := nonceTime(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only valid hashes get their nonce stored
+	if err := v.Nonces.Use(auth.Token+":"+nonce, issued); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
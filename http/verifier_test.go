@@ -0,0 +1,93 @@
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydhttp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifier(t *testing.T) {
+
+	token := NewToken("vXqzNCtQ5R7odtjIzVqB8OMW", "Ysz4npNH1KoOfRVfPH2J12Ia")
+	verifier := NewVerifier(NewMemoryTokenStore(token), time.Minute)
+
+	uri := "/api/my-files/upload/put/dir1"
+
+	Convey("A hash generated for the uri is valid once", t, func() {
+		auth := token.GetQueryArgs(uri)
+
+		t, err := verifier.Verify(uri, auth)
+		So(err, ShouldBeNil)
+		So(t, ShouldEqual, token)
+
+		_, err = verifier.Verify(uri, auth)
+		So(err, ShouldEqual, ErrReplayedNonce)
+	})
+
+	Convey("A hash generated for another uri is refused", t, func() {
+		auth := token.GetQueryArgs("/api/my-files/download/dir1")
+
+		_, err := verifier.Verify(uri, auth)
+		So(err, ShouldEqual, ErrHashMismatch)
+	})
+
+	Convey("A hash signed by another token is refused", t, func() {
+		auth := NewToken(token.T, "other-secret").GetQueryArgs(uri)
+
+		_, err := verifier.Verify(uri, auth)
+		So(err, ShouldEqual, ErrHashMismatch)
+	})
+
+	Convey("An unknown token or a malformed hash is refused", t, func() {
+		_, err := verifier.Verify(uri, NewToken("unknown", "secret").GetQueryArgs(uri))
+		So(err, ShouldEqual, ErrUnknownToken)
+
+		_, err = verifier.Verify(uri, &Auth{Token: token.T, Hash: "no-hmac"})
+		So(err, ShouldEqual, ErrInvalidHash)
+	})
+
+	Convey("A hash with an expired nonce is refused", t, func() {
+		nonce := fmt.Sprintf("%d-%s", time.Now().Add(-2*time.Minute).Unix(), "da39a3ee5e6b4b0d3255bfef95601890afd80709")
+		auth := &Auth{Token: token.T, Hash: nonce + ":" + token.sign(uri, nonce)}
+
+		_, err := verifier.Verify(uri, auth)
+		So(err, ShouldEqual, ErrExpiredNonce)
+
+		_, err = verifier.Verify(uri, &Auth{Token: token.T, Hash: "nonce:" + token.sign(uri, "nonce")})
+		So(err, ShouldEqual, ErrInvalidHash)
+	})
+
+	Convey("An expired nonce is never accepted again", t, func() {
+		cache := NewNonceCache(50 * time.Millisecond)
+		issued := time.Now()
+
+		So(cache.Use("nonce", issued), ShouldBeNil)
+		So(cache.Use("nonce", issued), ShouldEqual, ErrReplayedNonce)
+
+		time.Sleep(100 * time.Millisecond)
+
+		So(cache.Use("nonce", issued), ShouldEqual, ErrExpiredNonce)
+		So(cache.Use("nonce", time.Now().Add(time.Minute)), ShouldEqual, ErrExpiredNonce)
+	})
+}
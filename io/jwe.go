@@ -0,0 +1,241 @@
+// Package pydio contains all objects needed by the Pydio system
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydio
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+var (
+	// ErrNoKey is returned when encrypting with an empty keyring
+	ErrNoKey = errors.New("No key to encrypt the token")
+
+	// ErrTokenNotDecrypted is returned when no key of the keyring could decrypt the token
+	ErrTokenNotDecrypted = errors.New("Token could not be decrypted")
+
+	// ErrTokenExpired is returned when the token expiry date has passed
+	ErrTokenExpired = errors.New("Token has expired")
+
+	// ErrTokenType is returned when the token does not contain the expected value
+	ErrTokenType = errors.New("Token does not contain the expected value")
+
+	// ErrLegacyToken is returned for a signed only token when they are not accepted
+	ErrLegacyToken = errors.New("Signed only tokens are not accepted")
+
+	keyring   = &Keyring{}
+	keyringMu sync.RWMutex
+)
+
+// Keyring of the secrets used for the encrypted tokens. The first key is used
+// to encrypt, all of them are tried to decrypt so that keys can be rotated
+type Keyring struct {
+	keys [][]byte
+
+	// Legacy accepts the signed only tokens, checked with LegacySecret which
+	// must be set
+	Legacy       bool
+	LegacySecret string
+}
+
+// encryptedClaims is the payload of an encrypted token
+type encryptedClaims struct {
+	Type   string          `json:"typ"`
+	Expiry int64           `json:"exp"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// NewKeyring with the secrets, the current one first
+func NewKeyring(secrets ...string) *Keyring {
+	k := &Keyring{}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+
+		key := sha256.Sum256([]byte(secret))
+		k.keys = append(k.keys, key[:])
+	}
+
+	return k
+}
+
+// SetKeyring used to decrypt the tokens sent by Pydio
+func SetKeyring(k *Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+
+	keyring = k
+}
+
+// DefaultKeyring used to decrypt the tokens sent by Pydio. It has no key
+// and refuses the signed only tokens until one is set
+func DefaultKeyring() *Keyring {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+
+	return keyring
+}
+
+// Encrypt the value in a JWE token of the given type
+func (k *Keyring) Encrypt(typ string, v interface{}, hoursBeforeExpiry int) (string, error) {
+	if len(k.keys) == 0 {
+		return "", ErrNoKey
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(&encryptedClaims{
+		Type:   typ,
+		Expiry: time.Now().Add(time.Hour * time.Duration(hoursBeforeExpiry)).Unix(),
+		Data:   data,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encrypter, err := jose.NewEncrypter(jose.A256KW, jose.A256GCM, k.keys[0])
+	if err != nil {
+		return "", err
+	}
+
+	object, err := encrypter.Encrypt(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return object.CompactSerialize()
+}
+
+// Decrypt a JWE token of the given type into v
+func (k *Keyring) Decrypt(typ string, token string, v interface{}) error {
+	object, err := jose.ParseEncrypted(token)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	for _, key := range k.keys {
+		if payload, err = object.Decrypt(key); err == nil {
+			break
+		}
+	}
+
+	if payload == nil {
+		return ErrTokenNotDecrypted
+	}
+
+	var claims encryptedClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+
+	if claims.Type != typ {
+		return ErrTokenType
+	}
+
+	if time.Now().Unix() > claims.Expiry {
+		return ErrTokenExpired
+	}
+
+	return json.Unmarshal(claims.Data, v)
+}
+
+// isSignedOnly checks if the token is in the compact JWS form (3 parts, JWE having 5)
+func isSignedOnly(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// IsToken checks if the string is a token in the compact form, signed only or encrypted
+func IsToken(str string) bool {
+	if n := strings.Count(str, "."); n != 2 && n != 4 {
+		return false
+	}
+
+	for _, r := range str {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewOptionsFromToken decrypts the options. Signed only tokens are accepted if
+// the keyring allows the legacy form
+func NewOptionsFromToken(token string, keys *Keyring) (*Options, error) {
+	if isSignedOnly(token) {
+		if !keys.Legacy || keys.LegacySecret == "" {
+			return nil, ErrLegacyToken
+		}
+
+		return NewOptionsFromJWT(token, keys.LegacySecret)
+	}
+
+	var options Options
+	if err := keys.Decrypt("options", token, &options); err != nil {
+		return nil, err
+	}
+
+	return &options, nil
+}
+
+// NewUserFromToken decrypts the user. Signed only tokens are accepted if
+// the keyring allows the legacy form
+func NewUserFromToken(token string, keys *Keyring) (*User, error) {
+	if isSignedOnly(token) {
+		if !keys.Legacy || keys.LegacySecret == "" {
+			return nil, ErrLegacyToken
+		}
+
+		return NewUserFromJWT(token, keys.LegacySecret)
+	}
+
+	var user User
+	if err := keys.Decrypt("user", token, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// JWE encrypted representation of the Options
+func (o *Options) JWE(keys *Keyring, hoursBeforeExpiry int) (string, error) {
+	return keys.Encrypt("options", o, hoursBeforeExpiry)
+}
+
+// JWE encrypted representation of the User
+func (u *User) JWE(keys *Keyring, hoursBeforeExpiry int) (string, error) {
+	return keys.Encrypt("user", u, hoursBeforeExpiry)
+}
@@ -21,9 +21,14 @@
 package localio
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 
 	pydio "github.com/pydio/pydio-booster/io"
 	pydiolog "github.com/pydio/pydio-booster/log"
@@ -55,7 +60,7 @@ func Open(node *pydio.Node, flag int) (*pydio.File, error) {
 
 	// Creating the handlers
 	if flag&os.O_RDWR != 0 || flag&os.O_WRONLY == 0 {
-		reader = readHandler(file)
+		reader = readHandler(file, flag&os.O_RDWR == 0)
 	}
 
 	if flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 {
@@ -72,13 +77,144 @@ func Open(node *pydio.Node, flag int) (*pydio.File, error) {
 	), nil
 }
 
-func readHandler(file *os.File) *pydio.Reader {
+// ETag of the current version of the local file node
+func ETag(node *pydio.Node) (string, error) {
+	name := path.Join(node.Dir.String(), node.Basename)
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+
+	return etag(info), nil
+}
+
+// Stat of the local node
+func Stat(node *pydio.Node) (*pydio.Stat, error) {
+	info, err := os.Stat(path.Join(node.Dir.String(), node.Basename))
+	if err != nil {
+		return nil, err
+	}
+
+	return newStat(info), nil
+}
+
+// List the children of the local folder node with their stat. Symlinks are
+// skipped, as they may point outside of the repository
+func List(node *pydio.Node) ([]*pydio.Node, error) {
+	name := path.Join(node.Dir.String(), node.Basename)
+
+	infos, err := ioutil.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]*pydio.Node, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+
+		child := pydio.NewRawNode(node.Repo.String(), name, info.Name())
+		child.Stat = newStat(info)
+
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+// Checksum of the content of the local file node, as an hex encoded MD5
+func Checksum(node *pydio.Node) (string, error) {
+	file, err := os.Open(path.Join(node.Dir.String(), node.Basename))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Walk the folders and files under the local node. The node itself is
+// walked with an empty relative path. Symlinks are skipped
+func Walk(node *pydio.Node, fn func(rel string, info os.FileInfo) error) error {
+	root := path.Join(node.Dir.String(), node.Basename)
+
+	return filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			rel = ""
+		}
+
+		return fn(filepath.ToSlash(rel), info)
+	})
+}
+
+// Mkdir creates the folder of the local node and its parents
+func Mkdir(node *pydio.Node) error {
+	return os.MkdirAll(path.Join(node.Dir.String(), node.Basename), 0755)
+}
+
+// Rename the local node to the target local node
+func Rename(node *pydio.Node, target *pydio.Node) error {
+	if err := os.MkdirAll(target.Dir.String(), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(path.Join(node.Dir.String(), node.Basename), path.Join(target.Dir.String(), target.Basename))
+}
+
+// Remove the local node and all its children
+func Remove(node *pydio.Node) error {
+	return os.RemoveAll(path.Join(node.Dir.String(), node.Basename))
+}
+
+func newStat(info os.FileInfo) *pydio.Stat {
+	if info.IsDir() {
+		return &pydio.Stat{MTime: info.ModTime(), IsDir: true}
+	}
+
+	return &pydio.Stat{
+		Size:  info.Size(),
+		MTime: info.ModTime(),
+		MIME:  pydio.MIMEType(info.Name()),
+		ETag:  etag(info),
+	}
+}
+
+func etag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func readHandler(file *os.File, closeFile bool) *pydio.Reader {
 	reader, writer := io.Pipe()
 
 	go func() {
 		defer writer.Close()
 
 		io.Copy(writer, file)
+
+		// The write handler closes the file otherwise
+		if closeFile {
+			file.Close()
+		}
 	}()
 
 	return &pydio.Reader{PipeReader: reader}
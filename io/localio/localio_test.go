@@ -64,7 +64,8 @@ func TestAPI(t *testing.T) {
 
 	Convey("Write to a local node", t, func() {
 
-		file := Open(node, os.O_CREATE|os.O_WRONLY|os.O_EXCL)
+		file, err := Open(node, os.O_CREATE|os.O_WRONLY|os.O_EXCL)
+		So(err, ShouldBeNil)
 
 		bytesWritten, _ := file.Write([]byte("This is a test"))
 		file.Close()
@@ -76,7 +77,8 @@ func TestAPI(t *testing.T) {
 
 	Convey("Append to a local node", t, func() {
 
-		file := Open(node, os.O_APPEND|os.O_WRONLY|os.O_EXCL)
+		file, err := Open(node, os.O_APPEND|os.O_WRONLY|os.O_EXCL)
+		So(err, ShouldBeNil)
 
 		bytesWritten, _ := file.Write([]byte(" Appending content to a file"))
 		file.Close()
@@ -88,7 +90,8 @@ func TestAPI(t *testing.T) {
 
 	Convey("Read from a local node", t, func() {
 
-		file := Open(node, os.O_RDONLY|os.O_EXCL)
+		file, err := Open(node, os.O_RDONLY|os.O_EXCL)
+		So(err, ShouldBeNil)
 
 		bytes, err := ioutil.ReadAll(file)
 		So(err, ShouldBeNil)
@@ -103,7 +106,8 @@ func TestAPI(t *testing.T) {
 
 	Convey("Write to a local copy node", t, func() {
 
-		file := Open(node, os.O_CREATE|os.O_WRONLY|os.O_EXCL)
+		file, err := Open(node, os.O_CREATE|os.O_WRONLY|os.O_EXCL)
+		So(err, ShouldBeNil)
 
 		bytesWritten, _ := io.Copy(file, bytes.NewReader([]byte("This is a test")))
 		file.Close()
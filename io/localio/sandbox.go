@@ -0,0 +1,198 @@
+// Package localio contains logic for dealing with local files
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package localio
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pydio "github.com/pydio/pydio-booster/io"
+)
+
+// maxLinks followed while resolving a path, as in the kernel
+const maxLinks = 40
+
+var (
+	// ErrOutsideRoot is returned when a path resolves outside of its repository root
+	ErrOutsideRoot = errors.New("Path is outside of the repository root")
+
+	// ErrTooManyLinks is returned when resolving a path follows too many symlinks
+	ErrTooManyLinks = errors.New("Too many levels of symbolic links")
+)
+
+// NewNode creates the local node for a path relative to the repository root.
+// The path is resolved with Resolve so the node never escapes the root
+func NewNode(root string, elem ...string) (*pydio.Node, error) {
+	return Lookup(root, pydio.Normalization{}, elem...)
+}
+
+// Lookup the local node for a path relative to the repository root. The path
+// is put in the unicode form of the normalization and existing files are
+// matched on their key, so that a differently composed or cased path finds them
+func Lookup(root string, normalization pydio.Normalization, elem ...string) (*pydio.Node, error) {
+	rel := normalization.Path(strings.Join(elem, "/"))
+
+	if !normalization.IsZero() {
+		rel = lookup(root, rel, normalization)
+	}
+
+	name, err := Resolve(root, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	// The name found on disk is kept, whatever the default normalization
+	return pydio.NewRawNode("local", filepath.ToSlash(name)), nil
+}
+
+// Resolve the path relative to the repository root. Symlinks are followed,
+// including dangling ones, and the result must stay under the resolved root
+func Resolve(root string, elem ...string) (string, error) {
+	rel := strings.Join(elem, string(filepath.Separator))
+	if strings.IndexByte(rel, 0) >= 0 || strings.IndexByte(root, 0) >= 0 {
+		return "", ErrOutsideRoot
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	// Lexical check first, so that ".." segments never reach the file system.
+	// Joining to the root keeps a leading "/.." from being cleaned away
+	name := filepath.Join(root, rel)
+	if !within(root, name) {
+		log.Errorln("Path escapes the repository root", rel)
+		return "", ErrOutsideRoot
+	}
+
+	links := 0
+	name, err = resolve(name, &links)
+	if err != nil {
+		return "", err
+	}
+
+	if !within(root, name) {
+		log.Errorln("Path resolves outside of the repository root", rel)
+		return "", ErrOutsideRoot
+	}
+
+	return name, nil
+}
+
+// resolve the symlinks of the deepest existing part of an absolute path.
+// The parts that do not exist yet are appended as is
+func resolve(name string, links *int) (string, error) {
+	var rest []string
+
+	for {
+		real, err := filepath.EvalSymlinks(name)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		// A dangling symlink would be followed when creating the file
+		if info, lerr := os.Lstat(name); lerr == nil && info.Mode()&os.ModeSymlink != 0 {
+			if *links++; *links > maxLinks {
+				return "", ErrTooManyLinks
+			}
+
+			target, err := os.Readlink(name)
+			if err != nil {
+				return "", err
+			}
+
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(name), target)
+			}
+
+			name = filepath.Clean(target)
+			continue
+		}
+
+		parent := filepath.Dir(name)
+		if parent == name {
+			return "", err
+		}
+
+		rest = append([]string{filepath.Base(name)}, rest...)
+		name = parent
+	}
+}
+
+// lookup the existing names of the segments of the path under root.
+// Segments that are not found are kept as is. Nothing is looked up after
+// a ".." segment, the path being left to Resolve
+func lookup(root string, rel string, normalization pydio.Normalization) string {
+	segments := strings.Split(rel, "/")
+
+	current := root
+	for i, segment := range segments {
+		if segment == ".." {
+			break
+		}
+
+		if segment == "" || segment == "." {
+			continue
+		}
+
+		if _, err := os.Lstat(filepath.Join(current, segment)); err != nil {
+			entries, err := ioutil.ReadDir(current)
+			if err != nil {
+				break
+			}
+
+			key := normalization.Key(segment)
+			for _, entry := range entries {
+				if normalization.Key(entry.Name()) == key {
+					segments[i] = entry.Name()
+					break
+				}
+			}
+		}
+
+		current = filepath.Join(current, segments[i])
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// within checks that name is root or one of its descendants
+func within(root string, name string) bool {
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,168 @@
+// Package localio contains logic for dealing with local files
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package localio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pydio "github.com/pydio/pydio-booster/io"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSandbox(t *testing.T) {
+
+	base, _ := ioutil.TempDir("", "localio")
+	defer os.RemoveAll(base)
+
+	base, _ = filepath.EvalSymlinks(base)
+
+	root := filepath.Join(base, "repo")
+	outside := filepath.Join(base, "outside")
+
+	os.MkdirAll(filepath.Join(root, "folder"), 0755)
+	os.MkdirAll(outside, 0755)
+	ioutil.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+
+	os.Symlink(outside, filepath.Join(root, "escape"))
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt"))
+	os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(root, "dangling.txt"))
+	os.Symlink("../../outside", filepath.Join(root, "folder", "relative"))
+	os.Symlink(filepath.Join(root, "folder"), filepath.Join(root, "inside"))
+	os.Symlink("loop", filepath.Join(root, "loop"))
+
+	Convey("Resolving paths under the root", t, func() {
+		name, err := Resolve(root, "/folder", "file.txt")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, filepath.Join(root, "folder", "file.txt"))
+
+		name, err = Resolve(root, "/folder/../file.txt")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, filepath.Join(root, "file.txt"))
+
+		name, err = Resolve(root, "/missing/sub", "file.txt")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, filepath.Join(root, "missing", "sub", "file.txt"))
+
+		name, err = Resolve(root, "/inside", "file.txt")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, filepath.Join(root, "folder", "file.txt"))
+
+		name, err = Resolve(root, "/folder/%2e%2e/file.txt")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, filepath.Join(root, "folder", "%2e%2e", "file.txt"))
+	})
+
+	Convey("Resolving hostile paths", t, func() {
+		hostile := [][]string{
+			{"/..", "secret.txt"},
+			{"..", "outside", "secret.txt"},
+			{"/folder/../../outside/secret.txt"},
+			{"/folder/../../../../../../etc/passwd"},
+			{"/escape", "secret.txt"},
+			{"/escape/new.txt"},
+			{"/secret.txt"},
+			{"/dangling.txt"},
+			{"/folder/relative", "secret.txt"},
+			{"/folder", "file.txt\x00.jpg"},
+		}
+
+		for _, elem := range hostile {
+			_, err := Resolve(root, elem...)
+			So(err, ShouldEqual, ErrOutsideRoot)
+		}
+
+		_, err := Resolve(root, "/loop", "file.txt")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Resolving from a symlinked root", t, func() {
+		link := filepath.Join(base, "link")
+		os.Symlink(root, link)
+
+		name, err := Resolve(link, "/folder", "file.txt")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, filepath.Join(root, "folder", "file.txt"))
+
+		_, err = Resolve(link, "/..", "outside", "secret.txt")
+		So(err, ShouldEqual, ErrOutsideRoot)
+	})
+
+	Convey("Opening hostile paths never reaches the file", t, func() {
+		node, err := NewNode(root, "/escape", "new.txt")
+		So(node, ShouldBeNil)
+		So(err, ShouldEqual, ErrOutsideRoot)
+
+		node, err = NewNode(root, "/dangling.txt")
+		So(node, ShouldBeNil)
+		So(err, ShouldEqual, ErrOutsideRoot)
+
+		_, err = os.Stat(filepath.Join(outside, "new.txt"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Opening a path under the root", t, func() {
+		node, err := NewNode(root, "/folder", "file.txt")
+		So(err, ShouldBeNil)
+
+		file, err := Open(node, os.O_CREATE|os.O_WRONLY)
+		So(err, ShouldBeNil)
+
+		file.Write([]byte("inside"))
+		file.Close()
+
+		content, _ := ioutil.ReadFile(filepath.Join(root, "folder", "file.txt"))
+		So(string(content), ShouldEqual, "inside")
+	})
+
+	Convey("Looking up existing entries with the repository normalization", t, func() {
+		os.MkdirAll(filepath.Join(root, "Photos"), 0755)
+		ioutil.WriteFile(filepath.Join(root, "Photos", "e\u0301te\u0301.jpg"), []byte("nfd"), 0644)
+
+		n := pydio.Normalization{Form: pydio.FormNFC, FoldCase: true}
+
+		node, err := Lookup(root, n, "/photos", "\u00c9T\u00c9.JPG")
+		So(err, ShouldBeNil)
+		So(node.String(), ShouldEqual, "pydio://local"+filepath.ToSlash(filepath.Join(root, "Photos", "e\u0301te\u0301.jpg")))
+
+		node, err = Lookup(root, n, "/photos", "new.jpg")
+		So(err, ShouldBeNil)
+		So(node.String(), ShouldEqual, "pydio://local"+filepath.ToSlash(filepath.Join(root, "Photos", "new.jpg")))
+
+		_, err = Lookup(root, n, "/PHOTOS/../..", "outside", "secret.txt")
+		So(err, ShouldEqual, ErrOutsideRoot)
+
+		Convey("whatever the default normalization", func() {
+			pydio.SetNormalization(pydio.DefaultNormalization, pydio.Normalization{Form: pydio.FormNFC})
+			defer pydio.SetNormalization(pydio.DefaultNormalization, pydio.Normalization{})
+
+			node, err := Lookup(root, n, "/photos", "\u00e9t\u00e9.jpg")
+			So(err, ShouldBeNil)
+			So(node.Basename, ShouldEqual, "e\u0301te\u0301.jpg")
+
+			stat, err := Stat(node)
+			So(err, ShouldBeNil)
+			So(stat.Size, ShouldEqual, 3)
+		})
+	})
+}
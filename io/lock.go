@@ -0,0 +1,381 @@
+// Package pydio contains all objects needed by the Pydio system
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydio
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+)
+
+// Timeouts of the node locks
+const (
+	DefaultLockTimeout = 5 * time.Minute
+	MaxLockTimeout     = time.Hour
+)
+
+var (
+	// ErrLocked is returned when the node is locked by another token
+	ErrLocked = errors.New("Node is locked")
+
+	// ErrLockNotFound is returned when a lock token does not exist or has expired
+	ErrLockNotFound = errors.New("Lock token not found")
+
+	defaultLockManager     LockManager = NewMemoryLockManager()
+	defaultLockManagerLock sync.RWMutex
+)
+
+// Lock held on a node. The token is a WebDAV opaque lock token
+type Lock struct {
+	Token     string    `json:"token"`
+	Node      string    `json:"node"`
+	Owner     string    `json:"owner,omitempty"`
+	Transient bool      `json:"transient,omitempty"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Expired lock
+func (l *Lock) Expired() bool {
+	return !time.Now().Before(l.Expires)
+}
+
+// LockManager serializes the writers of a node
+type LockManager interface {
+	// Lock the node for the owner, failing with ErrLocked if it is already locked
+	Lock(node *Node, owner string, timeout time.Duration) (*Lock, error)
+
+	// Acquire a transient lock for a single write. It waits for the other
+	// transient locks to be released but fails with ErrLocked on a lock held by a client
+	Acquire(ctx context.Context, node *Node, owner string) (*Lock, error)
+
+	// Refresh the expiry of a lock
+	Refresh(token string, timeout time.Duration) (*Lock, error)
+
+	// Unlock the node held by the token
+	Unlock(token string) error
+
+	// Get the current lock on the node, nil if unlocked
+	Get(node *Node) *Lock
+
+	// Check that the token allows writing to the node
+	Check(node *Node, token string) error
+}
+
+// SetLockManager used by the io layer
+func SetLockManager(m LockManager) {
+	defaultLockManagerLock.Lock()
+	defaultLockManager = m
+	defaultLockManagerLock.Unlock()
+}
+
+// DefaultLockManager used by the io layer
+func DefaultLockManager() LockManager {
+	defaultLockManagerLock.RLock()
+	defer defaultLockManagerLock.RUnlock()
+
+	return defaultLockManager
+}
+
+// MemoryLockManager keeps the locks of the current process
+type MemoryLockManager struct {
+	mu       sync.Mutex
+	nodes    map[string]*Lock
+	tokens   map[string]*Lock
+	released chan struct{}
+}
+
+// NewMemoryLockManager with no lock
+func NewMemoryLockManager() *MemoryLockManager {
+	return &MemoryLockManager{
+		nodes:    make(map[string]*Lock),
+		tokens:   make(map[string]*Lock),
+		released: make(chan struct{}),
+	}
+}
+
+// Lock the node for the owner
+func (m *MemoryLockManager) Lock(node *Node, owner string, timeout time.Duration) (*Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lock(node.Key(), owner, timeout, false)
+}
+
+// Acquire a transient lock for a single write
+func (m *MemoryLockManager) Acquire(ctx context.Context, node *Node, owner string) (*Lock, error) {
+	key := node.Key()
+
+	for {
+		m.mu.Lock()
+
+		l, err := m.lock(key, owner, MaxLockTimeout, true)
+		if err == nil {
+			m.mu.Unlock()
+			return l, nil
+		}
+
+		current := m.nodes[key]
+		if !current.Transient {
+			m.mu.Unlock()
+			return nil, ErrLocked
+		}
+
+		released := m.released
+		m.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(current.Expires))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-released:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// Refresh the expiry of a lock
+func (m *MemoryLockManager) Refresh(token string, timeout time.Duration) (*Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.get(token)
+	if l == nil {
+		return nil, ErrLockNotFound
+	}
+
+	l.Expires = time.Now().Add(lockTimeout(timeout))
+
+	c := *l
+	return &c, nil
+}
+
+// Unlock the node held by the token
+func (m *MemoryLockManager) Unlock(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.get(token) == nil {
+		return ErrLockNotFound
+	}
+
+	m.remove(token)
+
+	return nil
+}
+
+// Get the current lock on the node
+func (m *MemoryLockManager) Get(node *Node) *Lock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.current(node.Key())
+	if l == nil {
+		return nil
+	}
+
+	c := *l
+	return &c
+}
+
+// Check that the token allows writing to the node. The token must be the
+// lock of this node, a lock held on another one does not allow anything
+func (m *MemoryLockManager) Check(node *Node, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := node.Key()
+	l := m.current(key)
+
+	if token != "" {
+		if t := m.get(token); t == nil || t.Node != key {
+			return ErrLockNotFound
+		}
+	}
+
+	if l != nil && l.Token != token {
+		return ErrLocked
+	}
+
+	return nil
+}
+
+// Put a lock created by another instance. When two locks are held on the
+// same node, the oldest one wins and the other one is dropped
+func (m *MemoryLockManager) Put(l *Lock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l.Expired() {
+		return
+	}
+
+	if current := m.current(l.Node); current != nil && current.Token != l.Token {
+		if current.Created.Before(l.Created) || (current.Created.Equal(l.Created) && current.Token < l.Token) {
+			return
+		}
+
+		m.remove(current.Token)
+	}
+
+	c := *l
+	m.nodes[l.Node] = &c
+	m.tokens[l.Token] = &c
+}
+
+// Claim the node for a lock announced by another instance after the
+// current lock of the node. The current lock wins, unless yield gives it up
+func (m *MemoryLockManager) Claim(l *Lock, yield func(current *Lock) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l.Expired() {
+		return
+	}
+
+	if current := m.current(l.Node); current != nil && current.Token != l.Token {
+		if !yield(current) {
+			return
+		}
+
+		m.remove(current.Token)
+	}
+
+	c := *l
+	m.nodes[l.Node] = &c
+	m.tokens[l.Token] = &c
+}
+
+// Locks currently held
+func (m *MemoryLockManager) Locks() []*Lock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var locks []*Lock
+	for token := range m.tokens {
+		if l := m.get(token); l != nil {
+			c := *l
+			locks = append(locks, &c)
+		}
+	}
+
+	return locks
+}
+
+// Remove a lock released by another instance
+func (m *MemoryLockManager) Remove(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tokens[token]; ok {
+		m.remove(token)
+	}
+}
+
+func (m *MemoryLockManager) lock(key string, owner string, timeout time.Duration, transient bool) (*Lock, error) {
+	if m.current(key) != nil {
+		return nil, ErrLocked
+	}
+
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	l := &Lock{
+		Token:     "opaquelocktoken:" + u4.String(),
+		Node:      key,
+		Owner:     owner,
+		Transient: transient,
+		Created:   now,
+		Expires:   now.Add(lockTimeout(timeout)),
+	}
+
+	m.nodes[key] = l
+	m.tokens[l.Token] = l
+
+	c := *l
+	return &c, nil
+}
+
+// current live lock of the node
+func (m *MemoryLockManager) current(key string) *Lock {
+	l, ok := m.nodes[key]
+	if !ok {
+		return nil
+	}
+
+	if l.Expired() {
+		m.remove(l.Token)
+		return nil
+	}
+
+	return l
+}
+
+// get the live lock of the token
+func (m *MemoryLockManager) get(token string) *Lock {
+	l, ok := m.tokens[token]
+	if !ok {
+		return nil
+	}
+
+	if l.Expired() {
+		m.remove(token)
+		return nil
+	}
+
+	return l
+}
+
+func (m *MemoryLockManager) remove(token string) {
+	l := m.tokens[token]
+	delete(m.tokens, token)
+
+	if current, ok := m.nodes[l.Node]; ok && current.Token == token {
+		delete(m.nodes, l.Node)
+	}
+
+	// Waking up the writers waiting for a release
+	close(m.released)
+	m.released = make(chan struct{})
+}
+
+func lockTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultLockTimeout
+	}
+
+	if timeout > MaxLockTimeout {
+		return MaxLockTimeout
+	}
+
+	return timeout
+}
@@ -0,0 +1,173 @@
+// Package pydio contains all objects needed by the Pydio system
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydio
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLock(t *testing.T) {
+
+	node := NewNode("my-files", "folder", "file.txt")
+	other := NewNode("my-files", "folder", "other.txt")
+
+	Convey("A locked node refuses the other writers", t, func() {
+		m := NewMemoryLockManager()
+
+		lock, err := m.Lock(node, "admin", 0)
+		So(err, ShouldBeNil)
+		So(strings.HasPrefix(lock.Token, "opaquelocktoken:"), ShouldBeTrue)
+		So(lock.Expires, ShouldHappenWithin, time.Second, time.Now().Add(DefaultLockTimeout))
+
+		_, err = m.Lock(node, "other", 0)
+		So(err, ShouldEqual, ErrLocked)
+
+		So(m.Check(node, lock.Token), ShouldBeNil)
+		So(m.Check(node, ""), ShouldEqual, ErrLocked)
+		So(m.Check(node, "opaquelocktoken:unknown"), ShouldEqual, ErrLockNotFound)
+		So(m.Check(other, ""), ShouldBeNil)
+
+		// The token of a lock only allows writing to its node
+		So(m.Check(other, lock.Token), ShouldEqual, ErrLockNotFound)
+
+		_, err = m.Acquire(context.Background(), node, "other")
+		So(err, ShouldEqual, ErrLocked)
+
+		So(m.Unlock(lock.Token), ShouldBeNil)
+		So(m.Unlock(lock.Token), ShouldEqual, ErrLockNotFound)
+		So(m.Get(node), ShouldBeNil)
+		So(m.Check(node, ""), ShouldBeNil)
+	})
+
+	Convey("Locks expire and can be refreshed", t, func() {
+		m := NewMemoryLockManager()
+
+		lock, err := m.Lock(node, "admin", 20*time.Millisecond)
+		So(err, ShouldBeNil)
+
+		refreshed, err := m.Refresh(lock.Token, 2*time.Hour)
+		So(err, ShouldBeNil)
+		So(refreshed.Expires, ShouldHappenWithin, time.Second, time.Now().Add(MaxLockTimeout))
+
+		lock, err = m.Lock(other, "admin", 20*time.Millisecond)
+		So(err, ShouldBeNil)
+
+		time.Sleep(30 * time.Millisecond)
+
+		So(m.Get(other), ShouldBeNil)
+
+		_, err = m.Refresh(lock.Token, 0)
+		So(err, ShouldEqual, ErrLockNotFound)
+	})
+
+	Convey("Transient locks serialize the writers", t, func() {
+		m := NewMemoryLockManager()
+
+		first, err := m.Acquire(context.Background(), node, "admin")
+		So(err, ShouldBeNil)
+		So(first.Transient, ShouldBeTrue)
+
+		acquired := make(chan *Lock)
+		go func() {
+			second, _ := m.Acquire(context.Background(), node, "admin")
+			acquired <- second
+		}()
+
+		select {
+		case <-acquired:
+			So("second writer did not wait", ShouldBeEmpty)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		So(m.Unlock(first.Token), ShouldBeNil)
+
+		var second *Lock
+		select {
+		case second = <-acquired:
+		case <-time.After(time.Second):
+		}
+		So(second, ShouldNotBeNil)
+		So(second.Token, ShouldNotEqual, first.Token)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err = m.Acquire(ctx, node, "admin")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, context.DeadlineExceeded.Error())
+	})
+
+	Convey("The oldest lock wins when two instances lock the same node", t, func() {
+		m := NewMemoryLockManager()
+
+		local, err := m.Lock(node, "admin", 0)
+		So(err, ShouldBeNil)
+
+		m.Put(&Lock{
+			Token:   "opaquelocktoken:newer",
+			Node:    node.Key(),
+			Created: local.Created.Add(time.Millisecond),
+			Expires: time.Now().Add(time.Minute),
+		})
+		So(m.Get(node).Token, ShouldEqual, local.Token)
+
+		m.Put(&Lock{
+			Token:   "opaquelocktoken:older",
+			Node:    node.Key(),
+			Created: local.Created.Add(-time.Millisecond),
+			Expires: time.Now().Add(time.Minute),
+		})
+		So(m.Get(node).Token, ShouldEqual, "opaquelocktoken:older")
+		So(m.Check(node, local.Token), ShouldEqual, ErrLockNotFound)
+
+		m.Remove("opaquelocktoken:older")
+		So(m.Get(node), ShouldBeNil)
+	})
+	Convey("A claim of another instance only takes the node from a lock that gives way", t, func() {
+		m := NewMemoryLockManager()
+
+		local, err := m.Lock(node, "admin", 0)
+		So(err, ShouldBeNil)
+
+		claim := &Lock{
+			Token:   "opaquelocktoken:claim",
+			Node:    node.Key(),
+			Created: time.Now(),
+			Expires: time.Now().Add(time.Minute),
+		}
+
+		m.Claim(claim, func(current *Lock) bool { return false })
+		So(m.Get(node).Token, ShouldEqual, local.Token)
+
+		m.Claim(claim, func(current *Lock) bool { return current.Token == local.Token })
+		So(m.Get(node).Token, ShouldEqual, claim.Token)
+		So(m.Check(node, local.Token), ShouldEqual, ErrLockNotFound)
+
+		locks := m.Locks()
+		So(locks, ShouldHaveLength, 1)
+		So(locks[0].Token, ShouldEqual, claim.Token)
+	})
+}
@@ -38,6 +38,7 @@ type Node struct {
 	Dir      *Dir   `path:",1:last-1" query:"dir"`
 	Basename string `path:",last-1:last" query:"file"`
 
+	Stat    *Stat   `path:"-" query:"-" json:",omitempty"`
 	Options Options `json:"-"`
 }
 
@@ -54,12 +55,32 @@ func NewNode(items ...string) *Node {
 	b := []byte(str)
 
 	if err := pydiopath.Unmarshal(b, &new); err == nil {
+		new.normalize()
 		return &new
 	}
 
 	return nil
 }
 
+// NewRawNode keeps the path as it is, without the normalization of the
+// repository, as for the names found on a local file system
+func NewRawNode(items ...string) *Node {
+	var str string
+
+	for _, item := range items {
+		str = path.Join(str, item)
+	}
+
+	new := node{}
+
+	if err := pydiopath.Unmarshal([]byte(str), &new); err == nil {
+		n := Node(new)
+		return &n
+	}
+
+	return nil
+}
+
 // NewTmpNode with random name
 func NewTmpNode() (*Node, error) {
 	// Creating a Unique ID for the connection
@@ -87,6 +108,29 @@ func (n *Node) String() string {
 	return fmt.Sprintf("pydio://%s/%s", n.Repo, n.Basename)
 }
 
+// Key of the node, shared by all the paths the repository considers equal
+func (n *Node) Key() string {
+	if n.Dir != nil {
+		return fmt.Sprintf("pydio://%s%s", n.Repo, GetNormalization(n.Repo.String()).Key(n.Dir.String()+"/"+n.Basename))
+	}
+
+	return fmt.Sprintf("pydio://%s/%s", n.Repo, GetNormalization(n.Repo.String()).Key(n.Basename))
+}
+
+// normalize the path of the node in the unicode form of its repository
+func (n *Node) normalize() {
+	normalization := GetNormalization(n.Repo.String())
+	if normalization.Form == FormNone {
+		return
+	}
+
+	n.Basename = normalization.Path(n.Basename)
+
+	if n.Dir != nil {
+		n.Dir = NewDir(normalization.Path(n.Dir.String()))
+	}
+}
+
 // Read the node by encoding to its json representation
 func (n *Node) Write(p []byte) (int, error) {
 	log.Printf("HELLO %s\n", p)
@@ -113,6 +157,7 @@ func (n *Node) UnmarshalPath(b []byte) (err error) {
 
 	if err = pydiopath.Unmarshal(b, &new); err == nil {
 		*n = Node(new)
+		n.normalize()
 		return
 	}
 
@@ -126,6 +171,7 @@ func (n *Node) UnmarshalQuery(b []byte) (err error) {
 
 	if err = query.Unmarshal(b, &new); err == nil {
 		*n = Node(new)
+		n.normalize()
 		return
 	}
 
@@ -0,0 +1,129 @@
+// Package pydio contains all objects needed by the Pydio system
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydio
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode forms of the node paths
+const (
+	FormNone = ""
+	FormNFC  = "nfc"
+	FormNFD  = "nfd"
+)
+
+// DefaultNormalization key, used by the repositories with no normalization of their own
+const DefaultNormalization = "*"
+
+var (
+	normalizations     = make(map[string]Normalization)
+	normalizationsLock sync.RWMutex
+
+	folder = cases.Fold()
+)
+
+// Normalization of the paths of a repository. The form is applied to the
+// stored paths while the case folding only applies to the lookups
+type Normalization struct {
+	Form     string
+	FoldCase bool
+}
+
+// NewNormalization with the unicode form (nfc, nfd or empty) and the case folding
+func NewNormalization(form string, foldCase bool) (Normalization, error) {
+	form = strings.ToLower(form)
+
+	switch form {
+	case FormNone, FormNFC, FormNFD:
+	default:
+		return Normalization{}, fmt.Errorf("Unknown unicode form %s", form)
+	}
+
+	return Normalization{
+		Form:     form,
+		FoldCase: foldCase,
+	}, nil
+}
+
+// Path in the unicode form of the repository
+func (n Normalization) Path(p string) string {
+	switch n.Form {
+	case FormNFC:
+		return norm.NFC.String(p)
+	case FormNFD:
+		return norm.NFD.String(p)
+	}
+
+	return p
+}
+
+// Key of the path used to find a node, two paths with the same key being the same node
+func (n Normalization) Key(p string) string {
+	p = n.Path(p)
+
+	if n.FoldCase {
+		// Folding may decompose some characters
+		return n.Path(folder.String(p))
+	}
+
+	return p
+}
+
+// Equal paths for the repository
+func (n Normalization) Equal(a string, b string) bool {
+	return n.Key(a) == n.Key(b)
+}
+
+// IsZero normalization, leaving the paths untouched
+func (n Normalization) IsZero() bool {
+	return n.Form == FormNone && !n.FoldCase
+}
+
+// SetNormalization of the paths of a repository, DefaultNormalization for all of them
+func SetNormalization(repo string, n Normalization) {
+	normalizationsLock.Lock()
+	defer normalizationsLock.Unlock()
+
+	if n.IsZero() {
+		delete(normalizations, repo)
+		return
+	}
+
+	normalizations[repo] = n
+}
+
+// GetNormalization of the paths of a repository
+func GetNormalization(repo string) Normalization {
+	normalizationsLock.RLock()
+	defer normalizationsLock.RUnlock()
+
+	if n, ok := normalizations[repo]; ok {
+		return n
+	}
+
+	return normalizations[DefaultNormalization]
+}
@@ -0,0 +1,88 @@
+// Package pydio contains all objects needed by the Pydio system
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydio
+
+import (
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	nfc = "/Photos/\u00e9t\u00e9.jpg"
+	nfd = "/Photos/e\u0301te\u0301.jpg"
+)
+
+func TestNormalization(t *testing.T) {
+
+	Convey("Unknown forms are refused", t, func() {
+		_, err := NewNormalization("nfkc", false)
+		So(err, ShouldNotBeNil)
+
+		n, err := NewNormalization("NFC", true)
+		So(err, ShouldBeNil)
+		So(n, ShouldResemble, Normalization{Form: FormNFC, FoldCase: true})
+	})
+
+	Convey("Paths are put in the unicode form", t, func() {
+		So(Normalization{Form: FormNFC}.Path(nfd), ShouldEqual, nfc)
+		So(Normalization{Form: FormNFD}.Path(nfc), ShouldEqual, nfd)
+		So(Normalization{}.Path(nfd), ShouldEqual, nfd)
+	})
+
+	Convey("Keys ignore the case only when folding", t, func() {
+		n := Normalization{Form: FormNFC, FoldCase: true}
+
+		So(n.Equal(nfd, "/photos/\u00c9T\u00c9.JPG"), ShouldBeTrue)
+		So(n.Path("/Photos"), ShouldEqual, "/Photos")
+
+		So(Normalization{Form: FormNFC}.Equal(nfd, "/photos/\u00e9t\u00e9.jpg"), ShouldBeFalse)
+		So(Normalization{}.Equal(nfd, nfc), ShouldBeFalse)
+	})
+
+	Convey("Nodes are normalized with the settings of their repository", t, func() {
+		SetNormalization("mac", Normalization{Form: FormNFC, FoldCase: true})
+		defer SetNormalization("mac", Normalization{})
+
+		node := NewNode("mac", nfd)
+		So(node.Dir.String(), ShouldEqual, "/Photos")
+		So(node.Basename, ShouldEqual, path.Base(nfc))
+
+		So(node.Key(), ShouldEqual, NewNode("mac", "/PHOTOS/\u00c9t\u00e9.jpg").Key())
+
+		var decoded Node
+		So(decoded.UnmarshalPath([]byte("mac"+nfd)), ShouldBeNil)
+		So(decoded.Basename, ShouldEqual, path.Base(nfc))
+
+		other := NewNode("other", nfd)
+		So(other.Basename, ShouldEqual, path.Base(nfd))
+		So(other.Key(), ShouldNotEqual, NewNode("other", nfc).Key())
+	})
+
+	Convey("The default normalization applies to the other repositories", t, func() {
+		SetNormalization(DefaultNormalization, Normalization{Form: FormNFD})
+		defer SetNormalization(DefaultNormalization, Normalization{})
+
+		So(GetNormalization("any").Form, ShouldEqual, FormNFD)
+		So(NewNode("any", nfc).Basename, ShouldEqual, path.Base(nfd))
+	})
+}
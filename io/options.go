@@ -64,7 +64,7 @@ type S3Options struct {
 	StorageURL        string `json:"STORAGE_URL"`
 }
 
-// NewOptionsFromJWT creates a user based on a JWT token string
+// NewOptionsFromJWT creates options based on a signed only JWT token string
 func NewOptionsFromJWT(token string, signatureSecret string) (*Options, error) {
 	decryptedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		return []byte(signatureSecret), nil
@@ -78,10 +78,6 @@ func NewOptionsFromJWT(token string, signatureSecret string) (*Options, error) {
 		return nil, errors.New("Token has been tampered with")
 	}
 
-	if err != nil {
-		return nil, err
-	}
-
 	var options *Options
 
 	claim := decryptedToken.Claims["options"]
@@ -92,9 +88,14 @@ func NewOptionsFromJWT(token string, signatureSecret string) (*Options, error) {
 	}
 
 	b, err := jwt.DecodeSegment(claimStr)
+	if err != nil {
+		return nil, err
+	}
 
 	// Unmarshalling to an Options Structure
-	err = json.Unmarshal(b, &options)
+	if err = json.Unmarshal(b, &options); err != nil {
+		return nil, err
+	}
 
 	return options, nil
 }
@@ -20,6 +20,7 @@
 package remoteio
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -39,8 +40,8 @@ type Arg struct {
 }
 
 // Write for the moment is handled by the PHP
-// so sending a request there
-func Write(api *pydhttp.API) func(io.Reader, pydio.Node) (int64, error) {
+// so sending a request there, with the request id of the context
+func Write(ctx context.Context, api *pydhttp.API) func(io.Reader, pydio.Node) (int64, error) {
 	return func(r io.Reader, n pydio.Node) (int64, error) {
 		uri := fmt.Sprintf("/api/%s/upload/put", n.Repo.ID)
 		auth := api.GetQueryArgs(uri)
@@ -77,15 +78,11 @@ func Write(api *pydhttp.API) func(io.Reader, pydio.Node) (int64, error) {
 		apiURL, _ := api.GetBaseURL()
 		req, _ := http.NewRequest("POST", strings.TrimRight(apiURL.String(), "/")+uri, pr)
 		req.Header.Add("Content-Type", w.FormDataContentType())
+		pydhttp.SetRequestID(ctx, req)
 		req.Body = ioutil.NopCloser(pr)
 
 		// Execute the HTTP request.
-		client := &http.Client{
-			CheckRedirect: func(r *http.Request, via []*http.Request) error {
-				return nil
-			},
-		}
-		resp, err := client.Do(req)
+		resp, err := pydhttp.DefaultClient().Do(req)
 		if err != nil {
 			return 0, err
 		}
@@ -21,6 +21,7 @@ package remoteio
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/url"
 	"os"
@@ -64,7 +65,7 @@ func TestAPI(t *testing.T) {
 
 		So(err, ShouldBeNil)
 
-		writeHandler := Write(api)
+		writeHandler := Write(context.Background(), api)
 		resp, _ := writeHandler(bytes.NewReader([]byte("This is a test")), node)
 
 		So(resp.StatusCode, ShouldEqual, http.StatusOK)
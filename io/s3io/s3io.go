@@ -65,17 +65,8 @@ func Open(node *pydio.Node, flag int) (*pydio.File, error) {
 
 	name := filepath.Join(node.Dir.String(), node.Basename)
 
-	// Creating the aws credentials
-	creds := credentials.NewStaticCredentials(node.Options.S3Options.APIKey, node.Options.S3Options.SecretKey, "")
-
-	config := aws.NewConfig()
-	config = config.WithCredentials(creds)
-	config = config.WithRegion(node.Options.S3Options.Region)
-
-	// Creating the aws session
-	sess, err := session.NewSession(config)
+	sess, err := newSession(node)
 	if err != nil {
-		fmt.Println("failed to create session,", err)
 		return nil, err
 	}
 
@@ -102,6 +93,276 @@ func Open(node *pydio.Node, flag int) (*pydio.File, error) {
 	), nil
 }
 
+// ETag of the current version of the S3 node
+func ETag(node *pydio.Node) (string, error) {
+	sess, err := newSession(node)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := s3.New(sess).HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(node.Options.S3Options.Container),
+		Key:    aws.String(filepath.Join(node.Dir.String(), node.Basename)),
+	})
+
+	if err != nil {
+		if isNotFound(err) {
+			return "", os.ErrNotExist
+		}
+
+		return "", err
+	}
+
+	if result.ETag == nil {
+		return "", errors.New("No ETag for the object")
+	}
+
+	return *result.ETag, nil
+}
+
+// Stat of the S3 node. Folders only exist as the prefix of their objects,
+// so a missing object with children is a folder
+func Stat(node *pydio.Node) (*pydio.Stat, error) {
+	sess, err := newSession(node)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := s3.New(sess)
+	bucket := node.Options.S3Options.Container
+	name := strings.TrimLeft(filepath.Join(node.Dir.String(), node.Basename), "/")
+
+	if name != "" {
+		result, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(name),
+		})
+
+		if err == nil {
+			return &pydio.Stat{
+				Size:     aws.Int64Value(result.ContentLength),
+				MTime:    aws.TimeValue(result.LastModified),
+				MIME:     mimeType(name, aws.StringValue(result.ContentType)),
+				ETag:     aws.StringValue(result.ETag),
+				Checksum: checksum(aws.StringValue(result.ETag)),
+			}, nil
+		}
+
+		if !isNotFound(err) {
+			return nil, err
+		}
+
+		name = name + "/"
+	}
+
+	result, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(name),
+		MaxKeys: aws.Int64(1),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" && len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	return &pydio.Stat{IsDir: true}, nil
+}
+
+// List the objects and folders directly under the S3 node with their stat
+func List(node *pydio.Node) ([]*pydio.Node, error) {
+	sess, err := newSession(node)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimLeft(filepath.Join(node.Dir.String(), node.Basename), "/")
+	if prefix != "" {
+		prefix = prefix + "/"
+	}
+
+	var children []*pydio.Node
+
+	newChild := func(name string, stat *pydio.Stat) {
+		child := pydio.NewNode(node.Repo.String(), node.Dir.String(), node.Basename, name)
+		child.Stat = stat
+		child.Options = node.Options
+
+		children = append(children, child)
+	}
+
+	err = s3.New(sess).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(node.Options.S3Options.Container),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, folder := range page.CommonPrefixes {
+			newChild(strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(folder.Prefix), prefix), "/"), &pydio.Stat{IsDir: true})
+		}
+
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(object.Key), prefix)
+
+			// Skipping the marker of the folder itself
+			if name == "" {
+				continue
+			}
+
+			newChild(name, &pydio.Stat{
+				Size:     aws.Int64Value(object.Size),
+				MTime:    aws.TimeValue(object.LastModified),
+				MIME:     pydio.MIMEType(name),
+				ETag:     aws.StringValue(object.ETag),
+				Checksum: checksum(aws.StringValue(object.ETag)),
+			})
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return children, nil
+}
+
+// Walk the objects stored under the S3 node, the object of the node itself
+// having an empty relative path
+func Walk(node *pydio.Node, fn func(rel string, size int64) error) error {
+	sess, err := newSession(node)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimLeft(filepath.Join(node.Dir.String(), node.Basename), "/")
+
+	var walkErr error
+
+	err = s3.New(sess).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(node.Options.S3Options.Container),
+		Prefix: aws.String(name),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+
+			// Skipping the siblings sharing the prefix
+			rel := strings.TrimPrefix(key, name)
+			if rel != "" && !strings.HasPrefix(rel, "/") {
+				continue
+			}
+
+			if walkErr = fn(strings.TrimPrefix(rel, "/"), aws.Int64Value(object.Size)); walkErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	if walkErr != nil {
+		return walkErr
+	}
+
+	return err
+}
+
+// Copy the S3 node to the target S3 node without transferring its content.
+// Both nodes must be reachable with the credentials of the target
+func Copy(node *pydio.Node, target *pydio.Node) error {
+	sess, err := newSession(target)
+	if err != nil {
+		return err
+	}
+
+	bucket := node.Options.S3Options.Container
+	name := strings.TrimLeft(filepath.Join(node.Dir.String(), node.Basename), "/")
+
+	_, err = s3.New(sess).CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(target.Options.S3Options.Container),
+		Key:        aws.String(filepath.Join(target.Dir.String(), target.Basename)),
+		CopySource: aws.String(copySource(bucket, name)),
+	})
+
+	return err
+}
+
+// Remove the object of the S3 node
+func Remove(node *pydio.Node) error {
+	sess, err := newSession(node)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.New(sess).DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(node.Options.S3Options.Container),
+		Key:    aws.String(filepath.Join(node.Dir.String(), node.Basename)),
+	})
+
+	return err
+}
+
+// copySource of the object, each segment of its key being escaped so that
+// S3 resolves the same key
+func copySource(bucket string, name string) string {
+	segments := strings.Split(strings.TrimLeft(name, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return "/" + bucket + "/" + strings.Join(segments, "/")
+}
+
+// isNotFound when the object does not exist in the bucket
+func isNotFound(err error) bool {
+	awsErr, ok := err.(interface {
+		Code() string
+	})
+
+	return ok && (awsErr.Code() == "NotFound" || awsErr.Code() == "NoSuchKey")
+}
+
+// mimeType stored with the object, unless it is the S3 default
+func mimeType(name string, contentType string) string {
+	if contentType == "" || contentType == "binary/octet-stream" {
+		return pydio.MIMEType(name)
+	}
+
+	return contentType
+}
+
+// checksum of an object is its ETag, unless it was uploaded in multiple parts
+func checksum(etag string) string {
+	etag = strings.Trim(etag, `"`)
+	if strings.Contains(etag, "-") {
+		return ""
+	}
+
+	return etag
+}
+
+func newSession(node *pydio.Node) (*session.Session, error) {
+
+	// Creating the aws credentials
+	creds := credentials.NewStaticCredentials(node.Options.S3Options.APIKey, node.Options.S3Options.SecretKey, "")
+
+	config := aws.NewConfig()
+	config = config.WithCredentials(creds)
+	config = config.WithRegion(node.Options.S3Options.Region)
+
+	// Creating the aws session
+	sess, err := session.NewSession(config)
+	if err != nil {
+		fmt.Println("failed to create session,", err)
+		return nil, err
+	}
+
+	return sess, nil
+}
+
 func readHandler(sess *session.Session, name string, bucket string) *pydio.Reader {
 
 	reader, writer := io.Pipe()
@@ -198,7 +459,7 @@ func appendHandler(sess *session.Session, name string, bucket string, lock chan
 
 		uploadPart1CopyInput := &s3.UploadPartCopyInput{
 			Bucket:     aws.String(bucket),
-			CopySource: aws.String("/" + bucket + "/" + url.QueryEscape(strings.TrimLeft(name, "/"))),
+			CopySource: aws.String(copySource(bucket, name)),
 			Key:        aws.String(name),
 			PartNumber: aws.Int64(1),
 			UploadId:   createOutput.UploadId,
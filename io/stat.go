@@ -0,0 +1,46 @@
+// Package pydio contains all objects needed by the Pydio system
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydio
+
+import (
+	"mime"
+	"path"
+	"time"
+)
+
+// Stat of a node, as reported by its storage
+type Stat struct {
+	Size     int64     `json:"size"`
+	MTime    time.Time `json:"mtime"`
+	IsDir    bool      `json:"is_dir"`
+	MIME     string    `json:"mime,omitempty"`
+	ETag     string    `json:"etag,omitempty"`
+	Checksum string    `json:"checksum,omitempty"`
+}
+
+// MIMEType of a file, guessed from the extension of its name
+func MIMEType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+
+	return "application/octet-stream"
+}
@@ -0,0 +1,585 @@
+// Package transfer copies and moves nodes within and across the storages
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+	pydio "github.com/pydio/pydio-booster/io"
+	"github.com/pydio/pydio-booster/io/localio"
+	"github.com/pydio/pydio-booster/io/s3io"
+	pydiolog "github.com/pydio/pydio-booster/log"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+)
+
+// Operations of a transfer
+const (
+	OpCopy = "copy"
+	OpMove = "move"
+)
+
+// chunkSize of the streamed transfers, as for the uploads
+const chunkSize = 1 * 1024 * 1024
+
+var (
+	// ErrUnknownOp is returned for an operation other than copy or move
+	ErrUnknownOp = errors.New("Unknown transfer operation")
+
+	// ErrSameNode is returned when the source and the target are the same node
+	ErrSameNode = errors.New("Source and target are the same node")
+
+	// ErrTargetInsideSource is returned when a folder is transferred inside itself
+	ErrTargetInsideSource = errors.New("Target is inside the source")
+
+	log *pydiolog.Logger
+)
+
+func init() {
+	log = pydiolog.New(pydiolog.GetLevel(), "[transfer] ", pydiolog.Ldate|pydiolog.Ltime|pydiolog.Lmicroseconds)
+}
+
+// Progress of a transfer, reported after each file
+type Progress struct {
+	ID         string `json:"id"`
+	Op         string `json:"op"`
+	Source     string `json:"source"`
+	Target     string `json:"target"`
+	Files      int    `json:"files"`
+	TotalFiles int    `json:"total_files"`
+	Bytes      int64  `json:"bytes"`
+	TotalBytes int64  `json:"total_bytes"`
+	Done       bool   `json:"done"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Transfer of a file or a folder to a node of the same or of another storage
+type Transfer struct {
+	ID     string
+	Op     string
+	Source *pydio.Node
+	Target *pydio.Node
+
+	// Owner of the locks taken on the target nodes
+	Owner string
+
+	// Dispatcher writing the chunks of the streamed files. They are written
+	// by the transfer itself if nil
+	Dispatcher *pydioworker.Dispatcher
+
+	// OnProgress is called after each file and once the transfer is done
+	OnProgress func(Progress)
+
+	mu       sync.Mutex
+	progress Progress
+}
+
+// entry to transfer, relative to the source node
+type entry struct {
+	Path string
+	Size int64
+	Dir  bool
+}
+
+// New transfer of the source node to the target node. Both nodes carry
+// the options of their storage
+func New(op string, source *pydio.Node, target *pydio.Node) (*Transfer, error) {
+	if op != OpCopy && op != OpMove {
+		return nil, ErrUnknownOp
+	}
+
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transfer{
+		ID:     u4.String(),
+		Op:     op,
+		Source: source,
+		Target: target,
+	}
+
+	t.progress = Progress{
+		ID:     t.ID,
+		Op:     op,
+		Source: source.String(),
+		Target: target.String(),
+	}
+
+	return t, nil
+}
+
+// Progress of the transfer so far
+func (t *Transfer) Progress() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.progress
+}
+
+// Do the transfer as a background job
+func (t *Transfer) Do() error {
+	return t.Run(context.Background())
+}
+
+// Run the transfer until it is done or the context is cancelled
+func (t *Transfer) Run(ctx context.Context) (err error) {
+
+	defer func() {
+		t.update(func(p *Progress) {
+			p.Done = true
+			if err != nil {
+				p.Error = err.Error()
+			}
+		})
+	}()
+
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	entries, err := list(t.Source)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return os.ErrNotExist
+	}
+
+	t.update(func(p *Progress) {
+		for _, e := range entries {
+			if !e.Dir {
+				p.TotalFiles++
+				p.TotalBytes += e.Size
+			}
+		}
+	})
+
+	// Moving within the local storage is a simple rename
+	if t.Op == OpMove && storage(t.Source) == "fs" && storage(t.Target) == "fs" {
+		if err := t.rename(ctx); err == nil {
+			t.update(func(p *Progress) {
+				p.Files = p.TotalFiles
+				p.Bytes = p.TotalBytes
+			})
+			return nil
+		}
+
+		log.Infoln("Could not rename, copying instead", err)
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		source := child(t.Source, e.Path)
+		target := child(t.Target, e.Path)
+
+		if e.Dir {
+			if err := mkdir(target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := t.transfer(ctx, source, target, e.Size); err != nil {
+			return err
+		}
+
+		t.update(func(p *Progress) {
+			p.Files++
+			p.Bytes += e.Size
+		})
+	}
+
+	if t.Op == OpMove {
+		return remove(t.Source, entries)
+	}
+
+	return nil
+}
+
+// check the source and the target before starting
+func (t *Transfer) check() error {
+	if storage(t.Source) != storage(t.Target) || !sameRoot(t.Source, t.Target) {
+		return nil
+	}
+
+	source := nodeKey(t.Source)
+	target := nodeKey(t.Target)
+
+	if source == target {
+		return ErrSameNode
+	}
+
+	if strings.HasPrefix(target, strings.TrimRight(source, "/")+"/") {
+		return ErrTargetInsideSource
+	}
+
+	return nil
+}
+
+// rename the local source to the local target
+func (t *Transfer) rename(ctx context.Context) error {
+	source, err := local(t.Source)
+	if err != nil {
+		return err
+	}
+
+	target, err := local(t.Target)
+	if err != nil {
+		return err
+	}
+
+	lock, err := pydio.DefaultLockManager().Acquire(ctx, t.Target, t.Owner)
+	if err != nil {
+		return err
+	}
+	defer pydio.DefaultLockManager().Unlock(lock.Token)
+
+	return localio.Rename(source, target)
+}
+
+// transfer a single file, locking the target for the time of the write
+func (t *Transfer) transfer(ctx context.Context, source *pydio.Node, target *pydio.Node, size int64) error {
+
+	lock, err := pydio.DefaultLockManager().Acquire(ctx, target, t.Owner)
+	if err != nil {
+		return err
+	}
+	defer pydio.DefaultLockManager().Unlock(lock.Token)
+
+	// Copying within S3 without reading the content
+	if storage(source) == "s3" && storage(target) == "s3" && sameCredentials(source, target) {
+		return s3io.Copy(source, target)
+	}
+
+	return t.stream(ctx, source, target)
+}
+
+// stream the content of the source file to the target file through the
+// dispatcher. The content is written next to the target and replaces it
+// once complete, so that a failed transfer never leaves a partial target
+func (t *Transfer) stream(ctx context.Context, source *pydio.Node, target *pydio.Node) (err error) {
+
+	in, err := open(source, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+
+	// Stops the read handler if we leave before the end of the file
+	defer in.PipeReader.Close()
+
+	temp := sibling(target, fmt.Sprintf(".%s.%d.transfer", target.Basename, time.Now().UnixNano()))
+
+	out, err := open(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			discard(temp)
+		}
+	}()
+
+	errs := make(chan error, 1)
+	offset := int64(0)
+
+	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		buf := make([]byte, chunkSize)
+
+		var n int
+		n, err = io.ReadFull(in, buf)
+		if n > 0 {
+			job := &chunkJob{
+				File:   out,
+				Buf:    buf[:n],
+				Offset: offset,
+				Errs:   errs,
+			}
+
+			out.Add(1)
+			if t.Dispatcher != nil {
+				t.Dispatcher.Add(job)
+			} else {
+				job.Do()
+			}
+
+			offset += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+			break
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	// Waiting for all the chunks to be written
+	out.Close()
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+
+	return commit(temp, target)
+}
+
+func (t *Transfer) update(fn func(p *Progress)) {
+	t.mu.Lock()
+	fn(&t.progress)
+	progress := t.progress
+	t.mu.Unlock()
+
+	if t.OnProgress != nil {
+		t.OnProgress(progress)
+	}
+}
+
+// chunkJob writing a chunk of a streamed file
+type chunkJob struct {
+	File   *pydio.File
+	Buf    []byte
+	Offset int64
+	Errs   chan error
+}
+
+// Do the job
+func (j *chunkJob) Do() error {
+	defer j.File.Done()
+
+	_, err := j.File.WriteAt(j.Buf, j.Offset)
+	if err != nil {
+		// Keeping the first error only
+		select {
+		case j.Errs <- err:
+		default:
+		}
+	}
+
+	return err
+}
+
+// storage type of the node, local storages being reported as "fs"
+func storage(node *pydio.Node) string {
+	switch node.Options.FileOptions.Type {
+	case "fs", "local":
+		return "fs"
+	}
+
+	return node.Options.FileOptions.Type
+}
+
+// sameRoot checks that the nodes live in the same local folder or bucket
+func sameRoot(a *pydio.Node, b *pydio.Node) bool {
+	if storage(a) == "s3" {
+		return a.Options.S3Options.Container == b.Options.S3Options.Container && a.Options.S3Options.StorageURL == b.Options.S3Options.StorageURL
+	}
+
+	return path.Clean(a.Options.FileOptions.Path) == path.Clean(b.Options.FileOptions.Path)
+}
+
+// sameCredentials checks that a single S3 client can reach both nodes
+func sameCredentials(a *pydio.Node, b *pydio.Node) bool {
+	return a.Options.S3Options.APIKey == b.Options.S3Options.APIKey &&
+		a.Options.S3Options.Region == b.Options.S3Options.Region &&
+		a.Options.S3Options.StorageURL == b.Options.S3Options.StorageURL
+}
+
+// nodeKey of the path relative to the root of its storage, equal paths for the repository sharing the same key
+func nodeKey(node *pydio.Node) string {
+	return pydio.GetNormalization(node.Repo.String()).Key(path.Join("/", node.Dir.String(), node.Basename))
+}
+
+// child of the node at the relative path, with the same options
+func child(node *pydio.Node, rel string) *pydio.Node {
+	if rel == "" {
+		return node
+	}
+
+	c := pydio.NewNode(node.Repo.String(), node.Dir.String(), node.Basename, rel)
+	c.Options = node.Options
+
+	return c
+}
+
+// sibling of the node with another name, with the same options
+func sibling(node *pydio.Node, name string) *pydio.Node {
+	s := pydio.NewNode(node.Repo.String(), node.Dir.String(), name)
+	s.Options = node.Options
+
+	return s
+}
+
+// local node of a node stored in the local storage, sandboxed in its root
+func local(node *pydio.Node) (*pydio.Node, error) {
+	return localio.Lookup(node.Options.FileOptions.Path, pydio.GetNormalization(node.Repo.String()), node.Dir.String(), node.Basename)
+}
+
+// list the folders and files of the node, parents first
+func list(node *pydio.Node) (entries []entry, err error) {
+	switch storage(node) {
+	case "fs":
+		l, err := local(node)
+		if err != nil {
+			return nil, err
+		}
+
+		err = localio.Walk(l, func(rel string, info os.FileInfo) error {
+			entries = append(entries, entry{Path: rel, Size: info.Size(), Dir: info.IsDir()})
+			return nil
+		})
+
+		return entries, err
+	case "s3":
+		err = s3io.Walk(node, func(rel string, size int64) error {
+			entries = append(entries, entry{Path: rel, Size: size})
+			return nil
+		})
+
+		return entries, err
+	}
+
+	return nil, fmt.Errorf("Unknown storage type %s", node.Options.FileOptions.Type)
+}
+
+// open the file of the node in its storage
+func open(node *pydio.Node, flag int) (*pydio.File, error) {
+	switch storage(node) {
+	case "fs":
+		l, err := local(node)
+		if err != nil {
+			return nil, err
+		}
+
+		if flag&os.O_CREATE != 0 {
+			if err := localio.Mkdir(pydio.NewRawNode("local", l.Dir.String())); err != nil {
+				return nil, err
+			}
+		}
+
+		return localio.Open(l, flag)
+	case "s3":
+		return s3io.Open(node, flag)
+	}
+
+	return nil, fmt.Errorf("Unknown storage type %s", node.Options.FileOptions.Type)
+}
+
+// commit the temporary file over the target file
+func commit(temp *pydio.Node, target *pydio.Node) error {
+	switch storage(target) {
+	case "fs":
+		l, err := local(temp)
+		if err != nil {
+			return err
+		}
+
+		t, err := local(target)
+		if err != nil {
+			return err
+		}
+
+		return localio.Rename(l, t)
+	case "s3":
+		if err := s3io.Copy(temp, target); err != nil {
+			return err
+		}
+
+		return s3io.Remove(temp)
+	}
+
+	return fmt.Errorf("Unknown storage type %s", target.Options.FileOptions.Type)
+}
+
+// discard the temporary file of a failed transfer
+func discard(temp *pydio.Node) {
+	switch storage(temp) {
+	case "fs":
+		if l, err := local(temp); err == nil {
+			localio.Remove(l)
+		}
+	case "s3":
+		s3io.Remove(temp)
+	}
+}
+
+// mkdir creates the folder of the node. S3 has no folder
+func mkdir(node *pydio.Node) error {
+	if storage(node) != "fs" {
+		return nil
+	}
+
+	l, err := local(node)
+	if err != nil {
+		return err
+	}
+
+	return localio.Mkdir(l)
+}
+
+// remove the source of a move once all its entries are transferred
+func remove(node *pydio.Node, entries []entry) error {
+	switch storage(node) {
+	case "fs":
+		l, err := local(node)
+		if err != nil {
+			return err
+		}
+
+		return localio.Remove(l)
+	case "s3":
+		for _, e := range entries {
+			if err := s3io.Remove(child(node, e.Path)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Unknown storage type %s", node.Options.FileOptions.Type)
+}
@@ -0,0 +1,185 @@
+// Package transfer copies and moves nodes within and across the storages
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package transfer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	pydio "github.com/pydio/pydio-booster/io"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newLocalNode(root string, p string) *pydio.Node {
+	node := pydio.NewNode("my-files", p)
+	node.Options.FileOptions = pydio.FileOptions{Type: "fs", Path: root}
+
+	return node
+}
+
+// cancelledContext is cancelled once it has been checked a number of times,
+// that is in the middle of a transfer
+type cancelledContext struct {
+	context.Context
+	checks int32
+	after  int32
+}
+
+func (c *cancelledContext) Err() error {
+	if atomic.AddInt32(&c.checks, 1) > c.after {
+		return context.Canceled
+	}
+
+	return nil
+}
+
+func TestTransfer(t *testing.T) {
+
+	base, _ := ioutil.TempDir("", "transfer")
+	defer os.RemoveAll(base)
+
+	source := filepath.Join(base, "source")
+	target := filepath.Join(base, "target")
+
+	os.MkdirAll(filepath.Join(source, "folder", "sub"), 0755)
+	os.MkdirAll(target, 0755)
+	ioutil.WriteFile(filepath.Join(source, "folder", "a.txt"), []byte("first file"), 0644)
+	ioutil.WriteFile(filepath.Join(source, "folder", "sub", "b.txt"), []byte(strings.Repeat("b", chunkSize+10)), 0644)
+
+	d := pydioworker.NewDispatcher(10)
+	d.Run()
+
+	Convey("Copying a folder to another storage root", t, func() {
+		tr, err := New(OpCopy, newLocalNode(source, "/folder"), newLocalNode(target, "/copy"))
+		So(err, ShouldBeNil)
+
+		tr.Dispatcher = d
+
+		var updates []Progress
+		tr.OnProgress = func(p Progress) { updates = append(updates, p) }
+
+		So(tr.Run(context.Background()), ShouldBeNil)
+
+		content, _ := ioutil.ReadFile(filepath.Join(target, "copy", "a.txt"))
+		So(string(content), ShouldEqual, "first file")
+
+		content, _ = ioutil.ReadFile(filepath.Join(target, "copy", "sub", "b.txt"))
+		So(len(content), ShouldEqual, chunkSize+10)
+
+		_, err = os.Stat(filepath.Join(source, "folder", "a.txt"))
+		So(err, ShouldBeNil)
+
+		last := updates[len(updates)-1]
+		So(last.Done, ShouldBeTrue)
+		So(last.Error, ShouldBeEmpty)
+		So(last.Files, ShouldEqual, 2)
+		So(last.TotalFiles, ShouldEqual, 2)
+		So(last.Bytes, ShouldEqual, chunkSize+20)
+	})
+
+	Convey("Copying a single file without a dispatcher", t, func() {
+		tr, err := New(OpCopy, newLocalNode(source, "/folder/a.txt"), newLocalNode(source, "/other/a-copy.txt"))
+		So(err, ShouldBeNil)
+
+		So(tr.Run(context.Background()), ShouldBeNil)
+
+		content, _ := ioutil.ReadFile(filepath.Join(source, "other", "a-copy.txt"))
+		So(string(content), ShouldEqual, "first file")
+	})
+
+	Convey("Moving a folder within the storage", t, func() {
+		tr, err := New(OpMove, newLocalNode(target, "/copy"), newLocalNode(target, "/moved"))
+		So(err, ShouldBeNil)
+
+		So(tr.Run(context.Background()), ShouldBeNil)
+		So(tr.Progress().Files, ShouldEqual, 2)
+
+		_, err = os.Stat(filepath.Join(target, "copy"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		content, _ := ioutil.ReadFile(filepath.Join(target, "moved", "a.txt"))
+		So(string(content), ShouldEqual, "first file")
+	})
+
+	Convey("Moving a folder to another storage root", t, func() {
+		tr, err := New(OpMove, newLocalNode(target, "/moved"), newLocalNode(source, "/back"))
+		So(err, ShouldBeNil)
+
+		tr.Dispatcher = d
+
+		So(tr.Run(context.Background()), ShouldBeNil)
+
+		_, err = os.Stat(filepath.Join(target, "moved"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		content, _ := ioutil.ReadFile(filepath.Join(source, "back", "sub", "b.txt"))
+		So(len(content), ShouldEqual, chunkSize+10)
+	})
+
+	Convey("Invalid transfers are refused", t, func() {
+		_, err := New("rename", newLocalNode(source, "/folder"), newLocalNode(source, "/other"))
+		So(err, ShouldEqual, ErrUnknownOp)
+
+		tr, _ := New(OpCopy, newLocalNode(source, "/folder"), newLocalNode(source, "/folder"))
+		So(tr.Run(context.Background()), ShouldEqual, ErrSameNode)
+
+		tr, _ = New(OpMove, newLocalNode(source, "/folder"), newLocalNode(source, "/folder/sub/folder"))
+		So(tr.Run(context.Background()), ShouldEqual, ErrTargetInsideSource)
+		So(tr.Progress().Error, ShouldEqual, ErrTargetInsideSource.Error())
+
+		tr, _ = New(OpCopy, newLocalNode(source, "/missing"), newLocalNode(source, "/other"))
+		So(os.IsNotExist(tr.Run(context.Background())), ShouldBeTrue)
+	})
+
+	Convey("Writing to a locked target fails", t, func() {
+		targetNode := newLocalNode(source, "/locked.txt")
+
+		lock, err := pydio.DefaultLockManager().Lock(targetNode, "admin", 0)
+		So(err, ShouldBeNil)
+		defer pydio.DefaultLockManager().Unlock(lock.Token)
+
+		tr, _ := New(OpCopy, newLocalNode(source, "/folder/a.txt"), targetNode)
+		So(tr.Run(context.Background()), ShouldEqual, pydio.ErrLocked)
+	})
+	Convey("A cancelled copy keeps the original target", t, func() {
+		ioutil.WriteFile(filepath.Join(source, "kept.txt"), []byte("original"), 0644)
+
+		tr, _ := New(OpCopy, newLocalNode(source, "/folder/sub/b.txt"), newLocalNode(source, "/kept.txt"))
+
+		// Checked before the file, then before each of its two chunks
+		ctx := &cancelledContext{Context: context.Background(), after: 2}
+
+		So(tr.Run(ctx), ShouldEqual, context.Canceled)
+
+		content, _ := ioutil.ReadFile(filepath.Join(source, "kept.txt"))
+		So(string(content), ShouldEqual, "original")
+
+		files, _ := filepath.Glob(filepath.Join(source, ".kept.txt.*"))
+		So(files, ShouldBeEmpty)
+	})
+}
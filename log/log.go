@@ -215,6 +215,14 @@ func (l *Logger) SetPrefix(p string) {
 	l.prefix = p
 }
 
+// WithPrefix returns a copy of the logger appending p to its prefix
+func (l *Logger) WithPrefix(p string) *Logger {
+	logger := *l
+	logger.prefix = l.prefix + p
+
+	return &logger
+}
+
 // Output print function
 func (l *Logger) Output(loglevel int, s string) error {
 	if loglevel >= DEBUG {
@@ -21,6 +21,7 @@
 package scheduler
 
 import (
+	"context"
 	"net/http"
 
 	"io"
@@ -44,6 +45,10 @@ func init() {
 
 func pydioMasterScheduler() {
 
+	// Each run gets its own request id so it can be found in the Pydio logs
+	ctx := pydhttp.NewRequestIDContext(context.Background(), pydhttp.NewRequestID())
+	log := pydhttp.RequestLogger(ctx, log)
+
 	log.Infoln("Triggering pydio scheduler master command")
 
 	host := schedulerConf.Host
@@ -67,10 +72,11 @@ func pydioMasterScheduler() {
 	log.Debugf("URL is -%s- -%s- ", url.Path, url.String())
 
 	request, _ := http.NewRequest("GET", url.String(), nil)
+	pydhttp.SetRequestID(ctx, request)
 
 	log.Debugln("Sending request ", request)
 
-	client := pydhttp.NewClient()
+	client := pydhttp.DefaultClient()
 	response, err := client.Do(request)
 
 	if err != nil {
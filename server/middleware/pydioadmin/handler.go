@@ -21,13 +21,26 @@
 package pydioadmin
 
 import (
+	"crypto/subtle"
+	"errors"
 	"net/http"
+	"path"
+	"strings"
 
 	"github.com/mholt/caddy/caddyhttp/httpserver"
 	"github.com/pydio/pydio-booster/conf"
+	pydiows "github.com/pydio/pydio-booster/websocket"
 	"gopkg.in/square/go-jose.v1/json"
 )
 
+var (
+	// ErrNoToken is returned when the presence or the metrics are queried without an admin token configured
+	ErrNoToken = errors.New("Presence and metrics need an admin token")
+
+	// ErrInvalidToken is returned when the request has not the admin token
+	ErrInvalidToken = errors.New("Invalid admin token")
+)
+
 // Handler for the pydio middleware
 type Handler struct {
 	Next  httpserver.Handler
@@ -40,9 +53,18 @@ type VersionResponse struct {
 	VersionDate   string
 }
 
+// PresenceResponse structure
+type PresenceResponse struct {
+	Connections int                           `json:"connections"`
+	Repos       map[string][]pydiows.Presence `json:"repos"`
+}
+
 // Rule for the Handler
 type Rule struct {
 	Path string
+
+	// Bearer token of the requests listing the connected users or their queues
+	Token string
 }
 
 // ServerHTTP Requests for uploading files to the server
@@ -52,6 +74,22 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error)
 	case http.MethodGet, http.MethodPost:
 		for _, rule := range h.Rules {
 			if httpserver.Path(r.URL.Path).Matches(rule.Path) {
+				if path.Clean(r.URL.Path) == path.Join(rule.Path, "presence") {
+					if status, err := authorize(r, rule); err != nil {
+						return status, err
+					}
+
+					return handlePresence(w, r)
+				}
+
+				if path.Clean(r.URL.Path) == path.Join(rule.Path, "metrics") {
+					if status, err := authorize(r, rule); err != nil {
+						return status, err
+					}
+
+					return handleMetrics(w, r)
+				}
+
 				return handle(w, r)
 			}
 		}
@@ -74,3 +112,59 @@ func handle(w http.ResponseWriter, r *http.Request) (int, error) {
 	}
 	return http.StatusOK, nil
 }
+
+// authorize the request with the admin token of the rule
+func authorize(r *http.Request, rule Rule) (int, error) {
+
+	if rule.Token == "" {
+		return http.StatusForbidden, ErrNoToken
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(rule.Token)) != 1 {
+		return http.StatusUnauthorized, ErrInvalidToken
+	}
+
+	return http.StatusOK, nil
+}
+
+// handlePresence lists the users connected to each repository, or to the
+// repository of the query
+func handlePresence(w http.ResponseWriter, r *http.Request) (int, error) {
+
+	w.Header().Add("Content-Type", "application/json")
+
+	registry := pydiows.DefaultRegistry()
+
+	response := &PresenceResponse{
+		Connections: registry.Len(),
+	}
+
+	if repo := r.URL.Query().Get("repo"); repo != "" {
+		response.Repos = map[string][]pydiows.Presence{repo: registry.Presence(repo)}
+	} else {
+		response.Repos = registry.All()
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// handleMetrics of the outbound queues of the websocket connections. The
+// queue of each connection is listed with detail=true
+func handleMetrics(w http.ResponseWriter, r *http.Request) (int, error) {
+
+	w.Header().Add("Content-Type", "application/json")
+
+	detail := r.URL.Query().Get("detail") == "true"
+
+	if err := json.NewEncoder(w).Encode(pydiows.DefaultRegistry().Metrics(detail)); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
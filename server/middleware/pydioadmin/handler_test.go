@@ -0,0 +1,123 @@
+// Package pydioadmin contains all logic for the pydioadmin directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydioadmin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestHandler(input string) (*Handler, error) {
+	rules, err := parse(caddy.NewTestController("http", input))
+	if err != nil {
+		return nil, err
+	}
+
+	next := httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+		return http.StatusNotFound, nil
+	})
+
+	return &Handler{Next: next, Rules: rules}, nil
+}
+
+func TestPresence(t *testing.T) {
+
+	Convey("Parsing the directive", t, func() {
+		h, err := newTestHandler(`pydioadmin /admin {
+			token secret
+		}`)
+		So(err, ShouldBeNil)
+		So(h.Rules, ShouldResemble, []Rule{{Path: "/admin", Token: "secret"}})
+
+		_, err = newTestHandler(`pydioadmin /admin {
+			token
+		}`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Listing the connected users needs the admin token", t, func() {
+		h, err := newTestHandler(`pydioadmin /admin {
+			token secret
+		}`)
+		So(err, ShouldBeNil)
+
+		r := httptest.NewRequest("GET", "/admin/presence", nil)
+
+		status, err := h.ServeHTTP(httptest.NewRecorder(), r)
+		So(status, ShouldEqual, http.StatusUnauthorized)
+		So(err, ShouldEqual, ErrInvalidToken)
+
+		r.Header.Set("Authorization", "Bearer wrong")
+
+		status, err = h.ServeHTTP(httptest.NewRecorder(), r)
+		So(status, ShouldEqual, http.StatusUnauthorized)
+		So(err, ShouldEqual, ErrInvalidToken)
+
+		r.Header.Set("Authorization", "Bearer secret")
+
+		w := httptest.NewRecorder()
+		status, err = h.ServeHTTP(w, r)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldContainSubstring, `"connections"`)
+	})
+
+	Convey("Listing the queues of the connected users needs the admin token", t, func() {
+		h, err := newTestHandler(`pydioadmin /admin {
+			token secret
+		}`)
+		So(err, ShouldBeNil)
+
+		r := httptest.NewRequest("GET", "/admin/metrics?detail=true", nil)
+
+		status, err := h.ServeHTTP(httptest.NewRecorder(), r)
+		So(status, ShouldEqual, http.StatusUnauthorized)
+		So(err, ShouldEqual, ErrInvalidToken)
+
+		r.Header.Set("Authorization", "Bearer secret")
+
+		status, err = h.ServeHTTP(httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, http.StatusOK)
+	})
+
+	Convey("Listing the connected users is refused without an admin token", t, func() {
+		h, err := newTestHandler(`pydioadmin /admin`)
+		So(err, ShouldBeNil)
+
+		r := httptest.NewRequest("GET", "/admin/presence", nil)
+		r.Header.Set("Authorization", "Bearer ")
+
+		status, err := h.ServeHTTP(httptest.NewRecorder(), r)
+		So(status, ShouldEqual, http.StatusForbidden)
+		So(err, ShouldEqual, ErrNoToken)
+
+		// The version is still public
+		status, err = h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin", nil))
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, http.StatusOK)
+	})
+}
@@ -52,6 +52,11 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
+// parses the config from the caddy file
+//
+//	pydioadmin /admin {
+//		token secret
+//	}
 func parse(c *caddy.Controller) ([]Rule, error) {
 
 	var rules []Rule
@@ -66,9 +71,26 @@ func parse(c *caddy.Controller) ([]Rule, error) {
 			return rules, c.ArgErr()
 		case 1:
 			rule.Path = args[0]
+		default:
+			return rules, c.ArgErr()
+		}
 
-			rules = append(rules, rule)
+		for c.NextBlock() {
+			switch c.Val() {
+			case "token":
+				if !c.NextArg() {
+					return rules, c.ArgErr()
+				}
+
+				rule.Token = c.Val()
+			case "{":
+				// Opening of the block
+			default:
+				return rules, c.Errf("Unknown property '%s'", c.Val())
+			}
 		}
+
+		rules = append(rules, rule)
 	}
 
 	return rules, nil
@@ -0,0 +1,159 @@
+// Package pydiocors contains the logic for the pydiocors directive (cross origin requests)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiocors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// Handler for the cors directive
+type Handler struct {
+	Next  httpserver.Handler
+	Rules []Rule
+}
+
+// Rule for the Handler
+type Rule struct {
+	Path           string
+	Origins        []string
+	Methods        []string
+	Headers        []string
+	ExposedHeaders []string
+	Credentials    bool
+	MaxAge         int
+}
+
+// ServeHTTP adds the cors headers to the requests coming from an allowed origin
+// and answers the preflight requests
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return h.Next.ServeHTTP(w, r)
+	}
+
+	for _, rule := range h.Rules {
+		if !httpserver.Path(r.URL.Path).Matches(rule.Path) {
+			continue
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// The answer depends on the origin, caches must know about it
+		w.Header().Add("Vary", "Origin")
+
+		if !rule.allowsOrigin(origin) {
+			if preflight {
+				return http.StatusForbidden, nil
+			}
+			break
+		}
+
+		if preflight {
+			return rule.preflight(w, r, origin)
+		}
+
+		rule.setOrigin(w, origin)
+
+		if len(rule.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.ExposedHeaders, ", "))
+		}
+
+		break
+	}
+
+	return h.Next.ServeHTTP(w, r)
+}
+
+func (rule *Rule) preflight(w http.ResponseWriter, r *http.Request, origin string) (int, error) {
+
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !contains(rule.Methods, method) {
+		return http.StatusForbidden, nil
+	}
+
+	var headers []string
+	for _, field := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		header := strings.TrimSpace(field)
+		if header == "" {
+			continue
+		}
+
+		if !contains(rule.Headers, header) {
+			return http.StatusForbidden, nil
+		}
+
+		headers = append(headers, header)
+	}
+
+	rule.setOrigin(w, origin)
+
+	w.Header().Set("Access-Control-Allow-Methods", method)
+
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+
+	if rule.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAge))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return http.StatusNoContent, nil
+}
+
+func (rule *Rule) setOrigin(w http.ResponseWriter, origin string) {
+	// An origin only allowed by the wildcard is never reflected, so never
+	// gets the credentials
+	if !contains(rule.Origins, strings.TrimRight(origin, "/")) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+
+	if rule.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (rule *Rule) allowsOrigin(origin string) bool {
+	return contains(rule.Origins, "*") || contains(rule.Origins, strings.TrimRight(origin, "/"))
+}
+
+// contains checks if the list contains the value, case insensitively
+func contains(list []string, value string) bool {
+	for _, elem := range list {
+		if strings.EqualFold(elem, value) {
+			return true
+		}
+	}
+
+	return false
+}
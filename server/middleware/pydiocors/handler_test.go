@@ -0,0 +1,161 @@
+// Package pydiocors contains the logic for the pydiocors directive (cross origin requests)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiocors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestHandler(input string) (*Handler, error) {
+	rules, err := parse(caddy.NewTestController("http", input))
+	if err != nil {
+		return nil, err
+	}
+
+	next := httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+		w.WriteHeader(http.StatusOK)
+		return http.StatusOK, nil
+	})
+
+	return &Handler{Next: next, Rules: rules}, nil
+}
+
+func TestCORS(t *testing.T) {
+
+	h, err := newTestHandler(`pydiocors /io {
+		origin http://pydio.dev/
+		methods POST PUT
+		headers X-File-Direct-Upload Range
+		expose X-Request-ID
+		credentials
+		max_age 1h
+	}`)
+
+	Convey("Parsing the directive", t, func() {
+		So(err, ShouldBeNil)
+		So(h.Rules, ShouldHaveLength, 1)
+		So(h.Rules[0].Origins, ShouldResemble, []string{"http://pydio.dev"})
+		So(h.Rules[0].MaxAge, ShouldEqual, 3600)
+
+		_, err := newTestHandler(`pydiocors /io`)
+		So(err, ShouldNotBeNil)
+
+		_, err = newTestHandler(`pydiocors /io {
+			origin *
+			credentials
+		}`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A credentialed preflight with custom headers", t, func() {
+		r := httptest.NewRequest("OPTIONS", "/io/my-files/file", nil)
+		r.Header.Set("Origin", "http://pydio.dev")
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		r.Header.Set("Access-Control-Request-Headers", "x-file-direct-upload, range")
+
+		w := httptest.NewRecorder()
+		status, err := h.ServeHTTP(w, r)
+
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, http.StatusNoContent)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "http://pydio.dev")
+		So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+		So(w.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "POST")
+		So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "x-file-direct-upload, range")
+		So(w.Header().Get("Access-Control-Max-Age"), ShouldEqual, "3600")
+	})
+
+	Convey("A preflight from another origin, method or with other headers is refused", t, func() {
+		for _, headers := range []map[string]string{
+			{"Origin": "http://evil.dev", "Access-Control-Request-Method": "POST"},
+			{"Origin": "http://pydio.dev", "Access-Control-Request-Method": "DELETE"},
+			{"Origin": "http://pydio.dev", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Other"},
+		} {
+			r := httptest.NewRequest("OPTIONS", "/io/my-files/file", nil)
+			for k, v := range headers {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			status, _ := h.ServeHTTP(w, r)
+
+			So(status, ShouldEqual, http.StatusForbidden)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		}
+	})
+
+	Convey("An actual request gets the cors headers and goes through", t, func() {
+		r := httptest.NewRequest("POST", "/io/my-files/file", nil)
+		r.Header.Set("Origin", "http://pydio.dev")
+
+		w := httptest.NewRecorder()
+		status, _ := h.ServeHTTP(w, r)
+
+		So(status, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "http://pydio.dev")
+		So(w.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, "X-Request-ID")
+	})
+
+	Convey("Any origin without credentials", t, func() {
+		h, err := newTestHandler(`pydiocors /io {
+			origin * http://pydio.dev
+			headers *
+		}`)
+		So(err, ShouldBeNil)
+
+		r := httptest.NewRequest("POST", "/io/my-files/file", nil)
+		r.Header.Set("Origin", "http://other.dev")
+
+		w := httptest.NewRecorder()
+		status, _ := h.ServeHTTP(w, r)
+
+		So(status, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+		So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldBeEmpty)
+
+		Convey("does not take the wildcard for methods and headers", func() {
+			r := httptest.NewRequest("OPTIONS", "/io/my-files/file", nil)
+			r.Header.Set("Origin", "http://other.dev")
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			r.Header.Set("Access-Control-Request-Headers", "X-Other")
+
+			status, _ := h.ServeHTTP(httptest.NewRecorder(), r)
+			So(status, ShouldEqual, http.StatusForbidden)
+		})
+	})
+
+	Convey("A request from another origin goes through without cors headers", t, func() {
+		r := httptest.NewRequest("POST", "/io/my-files/file", nil)
+		r.Header.Set("Origin", "http://evil.dev")
+
+		w := httptest.NewRecorder()
+		status, _ := h.ServeHTTP(w, r)
+
+		So(status, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+	})
+}
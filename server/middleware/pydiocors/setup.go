@@ -0,0 +1,147 @@
+// Package pydiocors contains the logic for the pydiocors directive (cross origin requests)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiocors
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+func init() {
+	caddy.RegisterPlugin("pydiocors", caddy.Plugin{
+		ServerType: "http",
+		Action:     setup,
+	})
+}
+
+// Setup configures a new cors middleware instance.
+func setup(c *caddy.Controller) error {
+
+	cfg := httpserver.GetConfig(c)
+
+	rules, err := parse(c)
+	if err != nil {
+		return err
+	}
+
+	cfg.AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
+		return &Handler{
+			Next:  next,
+			Rules: rules,
+		}
+	})
+
+	return nil
+}
+
+// parses the config from the caddy file
+//
+//	pydiocors /io {
+//		origin http://pydio.dev
+//		methods POST PUT
+//		headers X-File-Direct-Upload Range
+//		expose X-Request-ID
+//		credentials
+//		max_age 1h
+//	}
+func parse(c *caddy.Controller) ([]Rule, error) {
+
+	var rules []Rule
+
+	for c.Next() {
+		rule := Rule{
+			Methods: []string{http.MethodGet, http.MethodPost, http.MethodPut},
+		}
+
+		args := c.RemainingArgs()
+
+		switch len(args) {
+		case 0:
+			rule.Path = "/"
+		case 1:
+			rule.Path = args[0]
+		default:
+			return rules, c.ArgErr()
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "origin":
+				origins := c.RemainingArgs()
+				if len(origins) == 0 {
+					return rules, c.ArgErr()
+				}
+
+				for _, origin := range origins {
+					rule.Origins = append(rule.Origins, strings.TrimRight(origin, "/"))
+				}
+			case "methods":
+				methods := c.RemainingArgs()
+				if len(methods) == 0 {
+					return rules, c.ArgErr()
+				}
+
+				rule.Methods = nil
+				for _, method := range methods {
+					rule.Methods = append(rule.Methods, strings.ToUpper(method))
+				}
+			case "headers":
+				rule.Headers = append(rule.Headers, c.RemainingArgs()...)
+			case "expose":
+				rule.ExposedHeaders = append(rule.ExposedHeaders, c.RemainingArgs()...)
+			case "credentials":
+				rule.Credentials = true
+			case "max_age":
+				if !c.NextArg() {
+					return rules, c.ArgErr()
+				}
+
+				d, err := time.ParseDuration(c.Val())
+				if err != nil {
+					return rules, c.ArgErr()
+				}
+
+				rule.MaxAge = int(d.Seconds())
+			case "{":
+				// Opening of the block
+			default:
+				return rules, c.Errf("Unknown property '%s'", c.Val())
+			}
+		}
+
+		if len(rule.Origins) == 0 {
+			return rules, c.Err("pydiocors needs at least one origin")
+		}
+
+		// Any site could make credentialed requests
+		if rule.Credentials && contains(rule.Origins, "*") {
+			return rules, c.Err("pydiocors credentials can not be allowed for any origin")
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
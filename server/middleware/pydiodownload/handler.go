@@ -21,16 +21,11 @@
 package pydioupload
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"os"
 	"path"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/mholt/caddy/caddyhttp/httpserver"
@@ -67,10 +62,17 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error)
 		for _, rule := range h.Rules {
 			if httpserver.Path(r.URL.Path).Matches(rule.Path) {
 
+				r = pydhttp.WithRequestID(w, r)
+
 				res := errHandle(r, handle(w, r, h.Dispatcher))
 
 				if res.Err != nil {
-					logger.Errorln("returns error : ", res.Err)
+					pydhttp.RequestLogger(r.Context(), logger).Errorln("returns error : ", res.Err)
+
+					if res.Err == localio.ErrOutsideRoot {
+						return http.StatusForbidden, res.Err
+					}
+
 					return http.StatusUnauthorized, res.Err
 				}
 
@@ -112,6 +114,8 @@ func handle(w http.ResponseWriter, r *http.Request, d *pydioworker.Dispatcher) f
 
 	return func() *pydhttp.Status {
 
+		logger := pydhttp.RequestLogger(r.Context(), logger)
+
 		logger.Infoln("REQ START")
 
 		start := time.Now()
@@ -124,15 +128,15 @@ func handle(w http.ResponseWriter, r *http.Request, d *pydioworker.Dispatcher) f
 		ctx := r.Context()
 
 		// Retrieving the node
-		var node *pydio.Node
-		if err := getValue(ctx, "node", &node); err != nil {
+		node, err := pydhttp.NodeFromContext(ctx)
+		if err != nil {
 			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
 		}
 		logger.Debugf("Context node : %v", node)
 
 		// Retrieving the options
-		var options *pydio.Options
-		if err := getValue(ctx, "options", &options); err != nil {
+		options, err := pydhttp.OptionsFromContext(ctx)
+		if err != nil {
 			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
 		}
 		logger.Debugf("Context Options : %v", options)
@@ -156,10 +160,12 @@ func handle(w http.ResponseWriter, r *http.Request, d *pydioworker.Dispatcher) f
 
 		// Local file system, creating the Node
 		var file *pydio.File
-		var err error
 		if options.FileOptions.Type == "fs" || options.FileOptions.Type == "local" {
-			localNode := pydio.NewNode("local", options.FileOptions.Path, dir, name)
-			file, err = localio.Open(localNode, os.O_RDONLY)
+			var localNode *pydio.Node
+			localNode, err = localio.Lookup(options.FileOptions.Path, pydio.GetNormalization(node.Repo.String()), options.Path)
+			if err == nil {
+				file, err = localio.Open(localNode, os.O_RDONLY)
+			}
 		} else if options.FileOptions.Type == "s3" {
 			file, err = s3io.Open(node, os.O_RDONLY)
 		}
@@ -184,30 +190,6 @@ func handle(w http.ResponseWriter, r *http.Request, d *pydioworker.Dispatcher) f
 	}
 }
 
-// asynchronously retrieve values sitting in the context
-func getValue(ctx context.Context, key string, value interface{}) error {
-
-	// var node *pydio.Node
-	var buf bytes.Buffer
-
-	if err := pydhttp.FromContext(ctx, key, &buf); err != nil {
-		return err
-	}
-
-	data := buf.String()
-	if unquoted, err := strconv.Unquote(strings.Trim(data, "\n")); err == nil {
-		data = unquoted
-	}
-
-	dec := json.NewDecoder(strings.NewReader(data))
-	if err := dec.Decode(&value); err != nil {
-		logger.Errorf("value for %s : %v", key, err)
-		return err
-	}
-
-	return nil
-}
-
 // internalResponseWriter wraps the underlying http.ResponseWriter and ignores
 // calls to Write and WriteHeader if the response should be redirected to an
 // internal location.
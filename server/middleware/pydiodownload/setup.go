@@ -103,7 +103,9 @@ func parse(c *caddy.Controller) (rules []Rule, middlewareRules map[string][]pydi
 		}
 
 		if c.NextBlock() {
-			middlewareRules, _ = pydiomiddleware.Parse(c, rule.Path, "pre", "post")
+			if middlewareRules, err = pydiomiddleware.Parse(c, rule.Path, "pre", "post"); err != nil {
+				return
+			}
 		}
 
 		rules = append(rules, rule)
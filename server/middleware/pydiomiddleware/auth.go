@@ -28,6 +28,14 @@ import (
 	pydioworker "github.com/pydio/pydio-booster/worker"
 )
 
+func init() {
+	RegisterQueryType("auth", QueryType{
+		Action: func(ctx context.Context, args *JobArgs) (pydioworker.Job, error) {
+			return NewAuthJob(ctx, args.URL, args.Encoder, args.Close, args.Cancel)
+		},
+	})
+}
+
 // AuthJob definition for the uploader
 type AuthJob struct {
 	HandleFunc func() error
@@ -48,6 +56,8 @@ func NewAuthJob(
 	cancel func(),
 ) (pydioworker.Job, error) {
 
+	logger := pydhttp.RequestLogger(ctx, logger)
+
 	job := &AuthJob{
 		HandleFunc: func() error {
 			defer close()
@@ -21,15 +21,11 @@
 package pydiomiddleware
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/mholt/caddy/caddyhttp/httpserver"
@@ -50,7 +46,7 @@ type Handler struct {
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
 
 	switch r.Method {
-	case http.MethodGet, http.MethodPost, http.MethodPut:
+	case http.MethodGet, http.MethodPost, http.MethodPut, "LOCK", "UNLOCK":
 		for _, rule := range h.Rules {
 			if !rule.Matcher.Match(r) {
 				logger.Errorln("Not a match")
@@ -59,6 +55,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error)
 
 			if httpserver.Path(r.URL.Path).Matches(rule.Path) {
 
+				r = pydhttp.WithRequestID(w, r)
+
 				parent := r.Context()
 
 				ctx, cancel := context.WithCancel(parent)
@@ -68,6 +66,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error)
 				ctx, statusCode, err := handle(&rule, h.Dispatcher, w, r, cancel)
 
 				if err != nil || statusCode != 0 {
+					logger := pydhttp.RequestLogger(r.Context(), logger)
 					if err != nil {
 						logger.Errorln("got an error returned ", statusCode, err)
 					} else {
@@ -88,28 +87,30 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error)
 
 func handle(rule *Rule, d *pydioworker.Dispatcher, w http.ResponseWriter, r *http.Request, cancel func()) (context.Context, int, error) {
 
+	ctx := r.Context()
+	logger := pydhttp.RequestLogger(ctx, logger)
+
 	logger.Infoln("START")
 
 	start := time.Now()
 
 	defer func() {
 		elapsed := time.Since(start)
-		logger.Infoln("END - took %s", elapsed)
+		logger.Infof("END - took %s", elapsed)
 	}()
 
 	/**********************************************
 	-- Retrieving parameters from context
 	***********************************************/
-	ctx := r.Context()
 	replacer := httpserver.NewReplacer(r, nil, "")
 
 	/**********************************************
 	-- Defining the context variables to be added
 	***********************************************/
-	out := pydhttp.NewContextValue()
-	encoder := rule.EncoderFunc(out)
+	out := pydhttp.NewValue()
+	encoder := Encoder(out)
 
-	ctx = context.WithValue(ctx, rule.Out.Name, out)
+	ctx = pydhttp.NewContext(ctx, rule.Out.Name, out)
 
 	// Fill in cookies
 	values := &url.Values{}
@@ -179,17 +180,24 @@ func handle(rule *Rule, d *pydioworker.Dispatcher, w http.ResponseWriter, r *htt
 		}
 	}
 
-	var job pydioworker.Job
-	var err error
-	switch rule.QueryType {
-	case "node":
-		job, err = NewNodeJob(ctx, url, encoder, out.Close, cancel)
-	case "auth":
-		job, err = NewAuthJob(ctx, url, encoder, out.Close, cancel)
-	case "request":
-		job, err = NewRequestJob(ctx, url, headers, cookies, rule.Out, replacer, encoder, w, out.Close, cancel)
+	queryType, ok := GetQueryType(rule.QueryType)
+	if !ok {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Unknown query type %s", rule.QueryType)
 	}
 
+	job, err := queryType.Action(ctx, &JobArgs{
+		Rule:     rule,
+		Request:  r,
+		URL:      url,
+		Headers:  headers,
+		Cookies:  cookies,
+		Replacer: replacer,
+		Encoder:  encoder,
+		Writer:   w,
+		Close:    out.Close,
+		Cancel:   cancel,
+	})
+
 	if err != nil {
 		return nil, http.StatusUnauthorized, err
 	}
@@ -206,9 +214,9 @@ func handle(rule *Rule, d *pydioworker.Dispatcher, w http.ResponseWriter, r *htt
 
 func getContextNode(ctx context.Context) (*pydio.Node, error) {
 
-	var node *pydio.Node
-	if err := getValue(ctx, "node", &node); err != nil {
-		logger.Errorln("Could not decode to Node ", err)
+	node, err := pydhttp.NodeFromContext(ctx)
+	if err != nil {
+		pydhttp.RequestLogger(ctx, logger).Errorln("Could not decode to Node ", err)
 		return nil, err
 	}
 
@@ -217,11 +225,11 @@ func getContextNode(ctx context.Context) (*pydio.Node, error) {
 
 func getContextAuthParams(ctx context.Context, url string) (*pydhttp.Auth, error) {
 
-	var err error
+	logger := pydhttp.RequestLogger(ctx, logger)
 
 	// Retrieving auth from headers
-	var auth *pydhttp.Auth
-	if err = getValue(ctx, "auth", &auth); err != nil {
+	auth, err := pydhttp.AuthFromContext(ctx)
+	if err != nil {
 		logger.Errorln("Could not decode to auth")
 	}
 
@@ -229,7 +237,7 @@ func getContextAuthParams(ctx context.Context, url string) (*pydhttp.Auth, error
 
 		// Retrieving token from headers
 		var token *pydhttp.Token
-		if err = getValue(ctx, "token", &token); err != nil {
+		if token, err = pydhttp.TokenFromContext(ctx); err != nil {
 			logger.Errorln("Could not decode to token ", err)
 			return nil, err
 		}
@@ -246,30 +254,6 @@ func getContextAuthParams(ctx context.Context, url string) (*pydhttp.Auth, error
 	return auth, err
 }
 
-// asynchronously retrieve values sitting in the context
-func getValue(ctx context.Context, key string, value interface{}) error {
-
-	// var node *pydio.Node
-	var buf bytes.Buffer
-
-	if err := pydhttp.FromContext(ctx, key, &buf); err != nil {
-		return err
-	}
-
-	data := buf.String()
-	if unquoted, err := strconv.Unquote(strings.Trim(data, "\n")); err == nil {
-		data = unquoted
-	}
-
-	dec := json.NewDecoder(strings.NewReader(data))
-	if err := dec.Decode(&value); err != nil {
-		logger.Errorf("value for %s : %v", key, err)
-		return err
-	}
-
-	return nil
-}
-
 type (
 
 	// Rule for the Handler
@@ -284,8 +268,8 @@ type (
 		HeaderMatchers []string
 		Headers        [][2]string
 		QueryType      string
+		Config         interface{}
 		Out            Out
-		EncoderFunc    EncoderFunc
 
 		Matcher httpserver.RequestMatcher
 	}
@@ -25,9 +25,18 @@ import (
 	"net/url"
 
 	"github.com/pydio/pydio-booster/encoding/path"
+	pydhttp "github.com/pydio/pydio-booster/http"
 	pydioworker "github.com/pydio/pydio-booster/worker"
 )
 
+func init() {
+	RegisterQueryType("node", QueryType{
+		Action: func(ctx context.Context, args *JobArgs) (pydioworker.Job, error) {
+			return NewNodeJob(ctx, args.URL, args.Encoder, args.Close, args.Cancel)
+		},
+	})
+}
+
 // NodeJob definition for the uploader
 type NodeJob struct {
 	HandleFunc func() error
@@ -48,6 +57,8 @@ func NewNodeJob(
 	cancel func(),
 ) (pydioworker.Job, error) {
 
+	logger := pydhttp.RequestLogger(ctx, logger)
+
 	job := &NodeJob{
 		HandleFunc: func() error {
 			defer close()
@@ -0,0 +1,212 @@
+// Package pydiomiddleware contains the logic for a middleware directive (repetitive task done for a Pydio request)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiomiddleware
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mholt/caddy/caddyfile"
+
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+)
+
+// OAuthConfig of the oauth query type
+//
+//	type oauth
+//	introspect https://auth.example.com/introspect client_id client_secret
+//	jwks https://auth.example.com/.well-known/jwks.json
+//	issuer https://auth.example.com
+//	audience pydio
+//	cache 5m
+//	claims sub groups repos
+//	out user
+type OAuthConfig struct {
+	OAuth *pydhttp.OAuth
+
+	// Claims giving the user id, group path and repositories
+	IDClaim    string
+	GroupClaim string
+	ReposClaim string
+}
+
+func init() {
+	RegisterQueryType("oauth", QueryType{
+		Action: func(ctx context.Context, args *JobArgs) (pydioworker.Job, error) {
+			config, _ := args.Rule.Config.(*OAuthConfig)
+			return NewOAuthJob(ctx, config, pydhttp.BearerToken(args.Request), args.Encoder, args.Close)
+		},
+		Parse: parseOAuthConfig,
+	})
+}
+
+func parseOAuthConfig(d *caddyfile.Dispenser) (interface{}, error) {
+	config := &OAuthConfig{
+		OAuth:      &pydhttp.OAuth{},
+		IDClaim:    "sub",
+		GroupClaim: "group",
+		ReposClaim: "repos",
+	}
+
+	for d.Next() {
+		switch d.Val() {
+		case "introspect":
+			args := d.RemainingArgs()
+			if len(args) != 1 && len(args) != 3 {
+				return nil, d.ArgErr()
+			}
+
+			config.OAuth.IntrospectionURL = args[0]
+			if len(args) == 3 {
+				config.OAuth.ClientID = args[1]
+				config.OAuth.ClientSecret = args[2]
+			}
+		case "jwks":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			config.OAuth.JWKSURL = d.Val()
+		case "issuer":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			config.OAuth.Issuer = d.Val()
+		case "audience":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			config.OAuth.Audience = d.Val()
+		case "cache":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			ttl, err := time.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.ArgErr()
+			}
+
+			config.OAuth.CacheTTL = ttl
+		case "claims":
+			args := d.RemainingArgs()
+			if len(args) != 3 {
+				return nil, d.ArgErr()
+			}
+
+			config.IDClaim, config.GroupClaim, config.ReposClaim = args[0], args[1], args[2]
+		default:
+			return nil, d.Errf("Unknown property '%s' for query type oauth", d.Val())
+		}
+	}
+
+	if config.OAuth.IntrospectionURL == "" && config.OAuth.JWKSURL == "" {
+		return nil, d.Err("Query type oauth needs an introspect or a jwks url")
+	}
+
+	return config, nil
+}
+
+// NewOAuthJob validates the bearer token and gives the matching user.
+// The request is refused straight away if the token is not valid
+func NewOAuthJob(
+	ctx context.Context,
+	config *OAuthConfig,
+	token string,
+	encoder Encoder,
+	close func() error,
+) (pydioworker.Job, error) {
+
+	logger := pydhttp.RequestLogger(ctx, logger)
+
+	if config == nil {
+		return nil, errors.New("Query type oauth is not configured")
+	}
+
+	claims, err := config.OAuth.Validate(ctx, token)
+	if err != nil {
+		logger.Errorln("Could not validate the bearer token ", err)
+		close()
+		return nil, err
+	}
+
+	user, err := config.User(claims)
+	if err != nil {
+		logger.Errorln("Could not map the token to a user ", err)
+		close()
+		return nil, err
+	}
+
+	job := &AuthJob{
+		HandleFunc: func() error {
+			defer close()
+
+			return encoder.Encode(user)
+		},
+	}
+
+	return job, nil
+}
+
+// User described by the token claims. The repositories claim can either be a
+// list of ids (optionally followed by ":acl"), a list of repos or a map of id to acl
+func (c *OAuthConfig) User(claims pydhttp.Claims) (*pydio.User, error) {
+	user := &pydio.User{
+		ID:        claims.String(c.IDClaim),
+		GroupPath: claims.String(c.GroupClaim),
+	}
+
+	if user.ID == "" {
+		return nil, errors.New("No user id in the token claims")
+	}
+
+	switch repos := claims[c.ReposClaim].(type) {
+	case []interface{}:
+		for _, r := range repos {
+			switch repo := r.(type) {
+			case string:
+				parts := strings.SplitN(repo, ":", 2)
+				if len(parts) == 2 {
+					user.Repos = append(user.Repos, pydio.Repo{ID: parts[0], ACL: parts[1]})
+				} else {
+					user.Repos = append(user.Repos, pydio.Repo{ID: parts[0]})
+				}
+			case map[string]interface{}:
+				id, _ := repo["id"].(string)
+				acl, _ := repo["acl"].(string)
+				user.Repos = append(user.Repos, pydio.Repo{ID: id, ACL: acl})
+			}
+		}
+	case map[string]interface{}:
+		for id, a := range repos {
+			acl, _ := a.(string)
+			user.Repos = append(user.Repos, pydio.Repo{ID: id, ACL: acl})
+		}
+
+		sort.Slice(user.Repos, func(i, j int) bool { return user.Repos[i].ID < user.Repos[j].ID })
+	}
+
+	return user, nil
+}
@@ -0,0 +1,81 @@
+// Package pydiomiddleware contains the logic for a middleware directive (repetitive task done for a Pydio request)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiomiddleware
+
+import (
+	"encoding/json"
+	"testing"
+
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func claims(str string) pydhttp.Claims {
+	var c pydhttp.Claims
+	json.Unmarshal([]byte(str), &c)
+	return c
+}
+
+func TestOAuthUser(t *testing.T) {
+
+	config := &OAuthConfig{IDClaim: "sub", GroupClaim: "group", ReposClaim: "repos"}
+
+	Convey("Mapping a list of repository ids", t, func() {
+		user, err := config.User(claims(`{"sub": "alice", "group": "/staff", "repos": ["my-files:rw", "common"]}`))
+
+		So(err, ShouldBeNil)
+		So(user.ID, ShouldEqual, "alice")
+		So(user.GroupPath, ShouldEqual, "/staff")
+		So(user.Repos, ShouldResemble, []pydio.Repo{{ID: "my-files", ACL: "rw"}, {ID: "common"}})
+	})
+
+	Convey("Mapping a map of repository acls", t, func() {
+		user, err := config.User(claims(`{"sub": "alice", "repos": {"my-files": "rw", "common": "r"}}`))
+
+		So(err, ShouldBeNil)
+		So(user.Repos, ShouldResemble, []pydio.Repo{{ID: "common", ACL: "r"}, {ID: "my-files", ACL: "rw"}})
+	})
+
+	Convey("Claims without user id are refused", t, func() {
+		_, err := config.User(claims(`{"repos": ["my-files"]}`))
+
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Parsing an oauth rule", t, func() {
+		rules, err := parseTestRules(`pydioupload /io {
+			pre {
+				type oauth
+				introspect http://localhost/introspect booster s3cr3t
+				claims preferred_username groups workspaces
+				out user
+			}
+		}`)
+
+		So(err, ShouldBeNil)
+
+		config, ok := rules["pre"][0].Config.(*OAuthConfig)
+		So(ok, ShouldBeTrue)
+		So(config.OAuth.ClientID, ShouldEqual, "booster")
+		So(config.IDClaim, ShouldEqual, "preferred_username")
+	})
+}
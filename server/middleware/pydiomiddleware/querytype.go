@@ -0,0 +1,105 @@
+// Package pydiomiddleware contains the logic for a middleware directive (repetitive task done for a Pydio request)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiomiddleware
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/mholt/caddy/caddyfile"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+
+	pydioworker "github.com/pydio/pydio-booster/worker"
+)
+
+type (
+	// QueryType of a middleware rule (the "type" sub directive)
+	QueryType struct {
+		// Action creates the job run for a request matching the rule
+		Action JobFunc
+
+		// Parse reads the sub directives of the rule that pydiomiddleware
+		// does not know about. The returned value is stored in Rule.Config
+		Parse ParseFunc
+	}
+
+	// JobFunc creates the job of a query type
+	JobFunc func(ctx context.Context, args *JobArgs) (pydioworker.Job, error)
+
+	// ParseFunc reads the query type specific sub directives of a rule
+	ParseFunc func(d *caddyfile.Dispenser) (interface{}, error)
+
+	// JobArgs given to a query type when creating a job
+	JobArgs struct {
+		Rule     *Rule
+		Request  *http.Request
+		URL      url.URL
+		Headers  [][2]string
+		Cookies  []*http.Cookie
+		Replacer httpserver.Replacer
+		Encoder  Encoder
+		Writer   http.ResponseWriter
+
+		// Close must be called once the job has written its value
+		Close func() error
+
+		// Cancel the request if the job fails
+		Cancel func()
+	}
+)
+
+var queryTypes = make(map[string]QueryType)
+
+// RegisterQueryType makes a query type available to the middleware rules.
+// It should be called from the init function of the package defining it.
+func RegisterQueryType(name string, queryType QueryType) {
+	if name == "" {
+		panic("query type must have a name")
+	}
+	if _, dup := queryTypes[name]; dup {
+		panic("query type named " + name + " already registered")
+	}
+	if queryType.Action == nil {
+		panic("query type " + name + " has no action")
+	}
+
+	queryTypes[name] = queryType
+}
+
+// GetQueryType registered under the given name
+func GetQueryType(name string) (QueryType, bool) {
+	queryType, ok := queryTypes[name]
+	return queryType, ok
+}
+
+// ListQueryTypes returns the names of all registered query types
+func ListQueryTypes() []string {
+	var names []string
+	for name := range queryTypes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,137 @@
+// Package pydiomiddleware contains the logic for a middleware directive (repetitive task done for a Pydio request)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiomiddleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyfile"
+
+	pydioworker "github.com/pydio/pydio-booster/worker"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type lookupConfig struct {
+	Attributes []string
+}
+
+type lookupJob struct {
+	args *JobArgs
+}
+
+func (j *lookupJob) Do() error {
+	defer j.args.Close()
+
+	return j.args.Encoder.Encode(j.args.Rule.Config)
+}
+
+func init() {
+	RegisterQueryType("lookup", QueryType{
+		Action: func(ctx context.Context, args *JobArgs) (pydioworker.Job, error) {
+			return &lookupJob{args}, nil
+		},
+		Parse: func(d *caddyfile.Dispenser) (interface{}, error) {
+			config := &lookupConfig{}
+			for d.Next() {
+				switch d.Val() {
+				case "attributes":
+					config.Attributes = d.RemainingArgs()
+				default:
+					return nil, d.ArgErr()
+				}
+			}
+			return config, nil
+		},
+	})
+}
+
+func parseTestRules(input string) (map[string][]Rule, error) {
+	c := caddy.NewTestController("http", input)
+	c.Next()
+	c.RemainingArgs()
+	c.NextBlock()
+
+	return Parse(c, "/io", "pre", "post")
+}
+
+func TestQueryType(t *testing.T) {
+
+	Convey("Built-in query types are registered", t, func() {
+		So(ListQueryTypes(), ShouldContain, "auth")
+		So(ListQueryTypes(), ShouldContain, "node")
+		So(ListQueryTypes(), ShouldContain, "request")
+	})
+
+	Convey("Registering a query type twice panics", t, func() {
+		So(func() {
+			RegisterQueryType("node", QueryType{Action: func(context.Context, *JobArgs) (pydioworker.Job, error) { return nil, nil }})
+		}, ShouldPanic)
+	})
+
+	Convey("Parsing a rule with a custom query type and sub directives", t, func() {
+		rules, err := parseTestRules(`pydioupload /io {
+			pre {
+				type lookup
+				attributes uid cn
+				out user
+			}
+			post {
+				type node
+				out node
+			}
+		}`)
+
+		So(err, ShouldBeNil)
+		So(rules["pre"], ShouldHaveLength, 1)
+		So(rules["pre"][0].QueryType, ShouldEqual, "lookup")
+		So(rules["pre"][0].Out.Name, ShouldEqual, "user")
+		So(rules["pre"][0].Config, ShouldResemble, &lookupConfig{Attributes: []string{"uid", "cn"}})
+
+		So(rules["post"], ShouldHaveLength, 1)
+		So(rules["post"][0].QueryType, ShouldEqual, "node")
+		So(rules["post"][0].Config, ShouldBeNil)
+	})
+
+	Convey("Parsing a rule with an unknown query type fails", t, func() {
+		_, err := parseTestRules(`pydioupload /io {
+			pre {
+				type ldap
+				out user
+			}
+		}`)
+
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Parsing a rule with a sub directive unknown to its query type fails", t, func() {
+		_, err := parseTestRules(`pydioupload /io {
+			pre {
+				type node
+				attributes uid
+				out node
+			}
+		}`)
+
+		So(err, ShouldNotBeNil)
+	})
+}
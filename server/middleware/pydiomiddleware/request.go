@@ -30,11 +30,16 @@ import (
 
 	"github.com/mholt/caddy/caddyhttp/httpserver"
 	pydhttp "github.com/pydio/pydio-booster/http"
-	"github.com/pydio/pydio-booster/log"
 	pydioworker "github.com/pydio/pydio-booster/worker"
 )
 
-var client = pydhttp.NewClient()
+func init() {
+	RegisterQueryType("request", QueryType{
+		Action: func(ctx context.Context, args *JobArgs) (pydioworker.Job, error) {
+			return NewRequestJob(ctx, args.URL, args.Headers, args.Cookies, args.Rule.Out, args.Replacer, args.Encoder, args.Writer, args.Close, args.Cancel)
+		},
+	})
+}
 
 // RequestJob definition for the uploader
 type RequestJob struct {
@@ -46,7 +51,7 @@ type RequestJob struct {
 // Do the job
 func (j *RequestJob) Do() (err error) {
 
-	resp, err := client.Do(&j.Request)
+	resp, err := pydhttp.DefaultClient().Do(&j.Request)
 	if err != nil {
 		j.ErrorFunc()
 		return
@@ -95,6 +100,8 @@ func NewRequestJob(
 	cancel func(),
 ) (pydioworker.Job, error) {
 
+	logger := pydhttp.RequestLogger(ctx, logger)
+
 	queryArgs := u.Query()
 	logger.Infoln("Request Job Start", u, headers, queryArgs, out)
 
@@ -125,7 +132,7 @@ func NewRequestJob(
 	values := url.Values{}
 	for arg, vals := range u.Query() {
 		for _, val := range vals {
-			log.Debugln(replacer.Replace(val))
+			logger.Debugln(replacer.Replace(val))
 			values.Add(arg, replacer.Replace(val))
 		}
 	}
@@ -148,6 +155,9 @@ func NewRequestJob(
 		request.Header.Add(header[0], replacer.Replace(header[1]))
 	}
 
+	// Correlating the backend request with the incoming one
+	pydhttp.SetRequestID(ctx, request)
+
 	logger.Debugln("Doing cookies")
 	for _, cookie := range cookies {
 		request.AddCookie(cookie)
@@ -21,13 +21,12 @@
 package pydiomiddleware
 
 import (
-	"encoding/json"
-	"io"
 	"net/http"
 	"net/url"
 	"regexp"
 
 	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyfile"
 	"github.com/mholt/caddy/caddyhttp/httpserver"
 	"github.com/pydio/pydio-booster/http"
 
@@ -40,16 +39,29 @@ func init() {
 	logger = pydiolog.New(pydiolog.GetLevel(), "[pydiomiddleware] ", pydiolog.Ldate|pydiolog.Ltime|pydiolog.Lmicroseconds)
 }
 
+// DirectiveFunc parses a sub directive of the block that is not a middleware rule.
+// It is called with the controller on the name of the sub directive
+type DirectiveFunc func(c *caddy.Controller) error
+
 // Parse the middleware rules
 func Parse(c *caddy.Controller, path string, middlewares ...string) (rules map[string][]Rule, err error) {
+	return ParseWithDirectives(c, path, nil, middlewares...)
+}
+
+// ParseWithDirectives parses the middleware rules of the block, handing the
+// other sub directives over to fn
+func ParseWithDirectives(c *caddy.Controller, path string, fn DirectiveFunc, middlewares ...string) (rules map[string][]Rule, err error) {
 
 	logger = pydiolog.New(pydiolog.GetLevel(), "[pydiomiddleware] ", pydiolog.Ldate|pydiolog.Ltime|pydiolog.Lmicroseconds)
 
 	rules = make(map[string][]Rule)
 
 	for {
+		isMiddleware := false
+
 		for _, middleware := range middlewares {
 			if c.Val() == middleware {
+				isMiddleware = true
 
 				rule, err := parseRule(c)
 				rule.Path = path
@@ -62,6 +74,19 @@ func Parse(c *caddy.Controller, path string, middlewares ...string) (rules map[s
 			}
 		}
 
+		if !isMiddleware && fn != nil {
+			switch c.Val() {
+			case "{":
+			case "}":
+				// End of the directive block
+				return rules, nil
+			default:
+				if err := fn(c); err != nil {
+					return nil, err
+				}
+			}
+		}
+
 		if !c.Next() {
 			break
 		}
@@ -77,6 +102,9 @@ func parseRule(c *caddy.Controller) (Rule, error) {
 	var matcher httpserver.RequestMatcher
 	var err error
 
+	// Sub directives handled by the query type
+	var tokens []caddyfile.Token
+
 	// Integrate request matcher for 'if' conditions.
 	matcher, err = httpserver.SetupIfMatcher(c)
 	if err != nil {
@@ -158,16 +186,31 @@ func parseRule(c *caddy.Controller) (Rule, error) {
 				}
 			}
 
-			rule.EncoderFunc = func(v interface{}) Encoder {
-				if writer, ok := v.(io.Writer); ok {
-					return json.NewEncoder(writer)
-				}
+		case "{":
+			// Opening of the rule block
 
-				return nil
+		default:
+			tokens = append(tokens, caddyfile.Token{File: c.File(), Line: c.Line(), Text: c.Val()})
+			for c.NextArg() {
+				tokens = append(tokens, caddyfile.Token{File: c.File(), Line: c.Line(), Text: c.Val()})
 			}
 		}
 	}
 
+	queryType, ok := GetQueryType(rule.QueryType)
+	if !ok {
+		return rule, c.Errf("Unknown query type '%s', expecting one of %v", rule.QueryType, ListQueryTypes())
+	}
+
+	d := caddyfile.NewDispenserTokens(c.File(), tokens)
+	if queryType.Parse != nil {
+		if rule.Config, err = queryType.Parse(&d); err != nil {
+			return rule, err
+		}
+	} else if d.Next() {
+		return rule, d.Errf("Unknown property '%s' for query type %s", d.Val(), rule.QueryType)
+	}
+
 	rule.Matcher = matcher
 
 	return rule, nil
@@ -177,6 +220,3 @@ func parseRule(c *caddy.Controller) (Rule, error) {
 type Encoder interface {
 	Encode(v interface{}) error
 }
-
-// EncoderFunc Adapter
-type EncoderFunc func(v interface{}) Encoder
@@ -0,0 +1,150 @@
+// Package pydiomiddleware contains the logic for a middleware directive (repetitive task done for a Pydio request)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiomiddleware
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mholt/caddy/caddyfile"
+
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+)
+
+// VerifyConfig of the verify query type
+//
+//	type verify
+//	tokens /etc/pydio/tokens.json
+//	nonce_ttl 10m
+//	without /io
+type VerifyConfig struct {
+	Verifier *pydhttp.Verifier
+
+	// Without is the prefix removed from the request path before checking the signature
+	Without string
+}
+
+func init() {
+	RegisterQueryType("verify", QueryType{
+		Action: func(ctx context.Context, args *JobArgs) (pydioworker.Job, error) {
+			config, _ := args.Rule.Config.(*VerifyConfig)
+			return NewVerifyJob(ctx, config, args.Request.URL.Path, args.URL, args.Encoder, args.Close)
+		},
+		Parse: parseVerifyConfig,
+	})
+}
+
+func parseVerifyConfig(d *caddyfile.Dispenser) (interface{}, error) {
+	var store pydhttp.TokenStore
+	var ttl time.Duration
+	var without string
+
+	for d.Next() {
+		switch d.Val() {
+		case "tokens":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			s, err := pydhttp.NewTokenStoreFromFile(d.Val())
+			if err != nil {
+				return nil, d.Errf("Could not load tokens : %v", err)
+			}
+
+			store = s
+		case "nonce_ttl":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			t, err := time.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.ArgErr()
+			}
+
+			ttl = t
+		case "without":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+
+			without = d.Val()
+		default:
+			return nil, d.Errf("Unknown property '%s' for query type verify", d.Val())
+		}
+	}
+
+	if store == nil {
+		return nil, d.Err("Query type verify needs a tokens file")
+	}
+
+	return &VerifyConfig{
+		Verifier: pydhttp.NewVerifier(store, ttl),
+		Without:  without,
+	}, nil
+}
+
+// NewVerifyJob checks the auth_token and auth_hash sent by the client.
+// The request is refused straight away if they are not valid
+func NewVerifyJob(
+	ctx context.Context,
+	config *VerifyConfig,
+	path string,
+	url url.URL,
+	encoder Encoder,
+	close func() error,
+) (pydioworker.Job, error) {
+
+	logger := pydhttp.RequestLogger(ctx, logger)
+
+	if config == nil {
+		return nil, errors.New("Query type verify is not configured")
+	}
+
+	query := url.Query()
+
+	a := &pydhttp.Auth{
+		Token: query.Get("auth_token"),
+		Hash:  query.Get("auth_hash"),
+	}
+
+	uri := strings.TrimPrefix(path, config.Without)
+
+	if _, err := config.Verifier.Verify(uri, a); err != nil {
+		logger.Errorln("Could not verify auth hash ", err)
+		close()
+		return nil, err
+	}
+
+	job := &AuthJob{
+		HandleFunc: func() error {
+			defer close()
+
+			return encoder.Encode(a)
+		},
+	}
+
+	return job, nil
+}
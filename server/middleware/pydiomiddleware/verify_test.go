@@ -0,0 +1,123 @@
+// Package pydiomiddleware contains the logic for a middleware directive (repetitive task done for a Pydio request)
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiomiddleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerify(t *testing.T) {
+
+	token := pydhttp.NewToken("vXqzNCtQ5R7odtjIzVqB8OMW", "Ysz4npNH1KoOfRVfPH2J12Ia")
+
+	file, _ := ioutil.TempFile("", "tokens")
+	defer os.Remove(file.Name())
+
+	fmt.Fprintf(file, `[{"t": "%s", "p": "%s"}]`, token.T, token.P)
+	file.Close()
+
+	rules, err := parseTestRules(fmt.Sprintf(`pydioupload /io {
+		pre {
+			type verify
+			tokens %s
+			without /io
+			out auth
+		}
+	}`, file.Name()))
+
+	dispatcher := pydioworker.NewDispatcher(1)
+	dispatcher.Run()
+
+	// The next handler gets the verified auth from the context
+	next := httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+		auth, err := pydhttp.AuthFromContext(r.Context())
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		fmt.Fprint(w, auth.Token)
+
+		return http.StatusOK, nil
+	})
+
+	h := &Handler{Next: next, Rules: rules["pre"], Dispatcher: dispatcher}
+
+	newRequest := func(auth *pydhttp.Auth) *http.Request {
+		values := url.Values{}
+		values.Set("auth_token", auth.Token)
+		values.Set("auth_hash", auth.Hash)
+
+		return httptest.NewRequest("GET", "/io/my-files/dir1?"+values.Encode(), nil)
+	}
+
+	Convey("Parsing a verify rule", t, func() {
+		So(err, ShouldBeNil)
+		So(rules["pre"], ShouldHaveLength, 1)
+
+		_, err := parseTestRules(`pydioupload /io {
+			pre {
+				type verify
+				out auth
+			}
+		}`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A request signed for its path goes through once", t, func() {
+		auth := token.GetQueryArgs("/my-files/dir1")
+
+		w := httptest.NewRecorder()
+		code, err := h.ServeHTTP(w, newRequest(auth))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, token.T)
+
+		code, err = h.ServeHTTP(httptest.NewRecorder(), newRequest(auth))
+		So(err, ShouldEqual, pydhttp.ErrReplayedNonce)
+		So(code, ShouldEqual, http.StatusUnauthorized)
+	})
+
+	Convey("A request signed for another path is refused", t, func() {
+		code, err := h.ServeHTTP(httptest.NewRecorder(), newRequest(token.GetQueryArgs("/my-files/dir2")))
+		So(err, ShouldEqual, pydhttp.ErrHashMismatch)
+		So(code, ShouldEqual, http.StatusUnauthorized)
+	})
+
+	Convey("A request signed by an unknown token is refused", t, func() {
+		auth := pydhttp.NewToken("unknown", "secret").GetQueryArgs("/my-files/dir1")
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), newRequest(auth))
+		So(err, ShouldEqual, pydhttp.ErrUnknownToken)
+		So(code, ShouldEqual, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,218 @@
+// Package pydiosse contains the logic for the pydiosse caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiosse
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydiows "github.com/pydio/pydio-booster/websocket"
+)
+
+var (
+	// ErrMissingRepos is returned when the query selects no repository
+	ErrMissingRepos = errors.New("No repository to follow")
+
+	// ErrForbidden is returned when none of the repositories is readable by the user
+	ErrForbidden = errors.New("Repositories are not readable by the user")
+
+	// ErrStreamingUnsupported is returned when the response cannot be flushed
+	ErrStreamingUnsupported = errors.New("Streaming is not supported")
+
+	// ErrStreamClosed is returned when writing to a closed stream
+	ErrStreamClosed = errors.New("Stream is closed")
+)
+
+type (
+	// Handler structure
+	Handler struct {
+		Next    httpserver.Handler
+		Streams []Config
+	}
+
+	// Config holds the configuration for a single event stream endpoint
+	Config struct {
+		Path      string
+		Heartbeat time.Duration
+	}
+)
+
+// ServeHTTP streams the instant messages of the repositories of the query
+// as server-sent events. The repositories are given as a comma separated
+// list in repos, or as repeated repo values. Repeated path values restrict
+// the stream to the events of the nodes under them. A reconnecting client
+// sends the Last-Event-ID header, or the last_event_id query
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+
+	if r.Method == http.MethodGet {
+		for _, config := range h.Streams {
+			if httpserver.Path(r.URL.Path).Matches(config.Path) {
+
+				r = pydhttp.WithRequestID(w, r)
+
+				res := handle(w, r, &config)
+
+				if res.Err != nil {
+					pydhttp.RequestLogger(r.Context(), logger).Errorln("PydioSSE returns an error : ", res.Err)
+
+					return res.StatusCode, res.Err
+				}
+
+				return http.StatusOK, nil
+			}
+		}
+	}
+
+	return h.Next.ServeHTTP(w, r)
+}
+
+// handle the stream until the client goes away
+func handle(w http.ResponseWriter, r *http.Request, config *Config) *pydhttp.Status {
+
+	logger := pydhttp.RequestLogger(r.Context(), logger)
+
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	user, err := pydhttp.UserFromContext(ctx)
+	if err != nil {
+		return pydhttp.NewStatusErr(http.StatusUnauthorized, err)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return pydhttp.NewStatusErr(http.StatusInternalServerError, ErrStreamingUnsupported)
+	}
+
+	repos := query["repo"]
+	for _, list := range query["repos"] {
+		for _, repo := range strings.Split(list, ",") {
+			if repo != "" {
+				repos = append(repos, repo)
+			}
+		}
+	}
+
+	if len(repos) == 0 {
+		return pydhttp.NewStatusErr(http.StatusBadRequest, ErrMissingRepos)
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+
+	var last uint64
+	if lastEventID != "" {
+		if last, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			return pydhttp.NewStatusErr(http.StatusBadRequest, pydiows.ErrInvalidEventID)
+		}
+	}
+
+	s := &stream{w: w, flusher: flusher}
+	defer s.Close()
+
+	connection, err := pydiows.NewConnectionWithProtocol(user, pydiows.ProtocolSSE, nil, s)
+	if err != nil {
+		return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
+
+	// Stopping its consumer and leaving the registry
+	defer connection.Close()
+
+	if refused := connection.SubscribePaths(query["path"], repos...); len(refused) == len(repos) {
+		return pydhttp.NewStatusErr(http.StatusForbidden, ErrForbidden)
+	}
+
+	logger.Infof("PydioSSE : streaming %v", connection.Repos())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if lastEventID != "" {
+		connection.Resume(last, connection.Repos()...)
+	}
+
+	ticker := time.NewTicker(config.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Infoln("PydioSSE : client gone")
+			return pydhttp.NewStatusOK(r)
+		case err := <-connection.ExitChan:
+			if err != nil {
+				logger.Errorln("PydioSSE : connection stopped ", err)
+			}
+			return pydhttp.NewStatusOK(r)
+		case <-ticker.C:
+			if _, err := io.WriteString(s, ": heartbeat\n\n"); err != nil {
+				return pydhttp.NewStatusOK(r)
+			}
+		}
+	}
+}
+
+// stream of events flushed to the client after each write. The writes
+// stop once the handler is done with the response
+type stream struct {
+	w       io.Writer
+	flusher http.Flusher
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Write the event and flush it
+func (s *stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+
+	n, err := s.w.Write(p)
+	s.flusher.Flush()
+
+	return n, err
+}
+
+// Close the stream
+func (s *stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	return nil
+}
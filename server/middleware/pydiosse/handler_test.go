@@ -0,0 +1,224 @@
+// Package pydiotransfer contains the logic for the pydiotransfer caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiosse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/nsqio/go-nsq"
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+	pydiows "github.com/pydio/pydio-booster/websocket"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var fakeUser = &pydio.User{
+	ID:        "test",
+	GroupPath: "test",
+	Repos: []pydio.Repo{
+		{ID: "test"},
+		{ID: "writeonly", ACL: "w"},
+	},
+}
+
+// fakeConsumer of the connections, delivering the messages of the tests
+type fakeConsumer struct {
+	handler func(*nsq.Message) error
+
+	started  chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (c *fakeConsumer) AddHandler(handler func(*nsq.Message) error) {
+	c.handler = handler
+}
+
+func (c *fakeConsumer) Start() error {
+	close(c.started)
+	return nil
+}
+
+func (c *fakeConsumer) Stop() {
+	c.stopOnce.Do(func() { close(c.stopped) })
+}
+
+// fakeConsumers created by the new connections, sent to the channel
+func fakeConsumers() chan *fakeConsumer {
+	consumers := make(chan *fakeConsumer, 10)
+
+	pydiows.SetConsumerFunc(func(topic string, channel string) (pydiows.Consumer, error) {
+		c := &fakeConsumer{started: make(chan struct{}), stopped: make(chan struct{})}
+		consumers <- c
+		return c, nil
+	})
+
+	return consumers
+}
+
+// recorder of the stream, sending each write to a channel
+type recorder struct {
+	header http.Header
+	writes chan string
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header), writes: make(chan string, 1000)}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.writes <- string(p)
+	return len(p), nil
+}
+
+func (r *recorder) WriteHeader(code int) {}
+
+func (r *recorder) Flush() {}
+
+// next write of the stream that is not a heartbeat, or the first heartbeat
+func (r *recorder) next(heartbeat bool) string {
+	timeout := time.After(time.Second)
+
+	for {
+		select {
+		case write := <-r.writes:
+			if (write == ": heartbeat\n\n") == heartbeat {
+				return write
+			}
+		case <-timeout:
+			return "TIMEOUT"
+		}
+	}
+}
+
+// closed waits for the channel to be closed, for a second at most
+func closed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func newHandler() Handler {
+	return Handler{
+		Next: httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			return http.StatusTeapot, nil
+		}),
+		Streams: []Config{{Path: "/events", Heartbeat: 10 * time.Millisecond}},
+	}
+}
+
+func newStreamRequest(ctx context.Context, query string) *http.Request {
+	req := httptest.NewRequest("GET", "/events?"+query, nil)
+
+	return req.WithContext(pydhttp.NewContext(ctx, "user", fakeUser))
+}
+
+func TestStream(t *testing.T) {
+
+	h := newHandler()
+
+	consumers := fakeConsumers()
+	defer pydiows.SetConsumerFunc(nil)
+
+	Convey("Streaming the events missed by a reconnecting client, then the live ones", t, func() {
+		b := pydiows.NewBacklog(10)
+		pydiows.SetBacklog(b)
+		defer pydiows.SetBacklog(nil)
+
+		b.Add(nsq.MessageID{'1'}, pydiows.PydioInstantMessage{RepoID: "test", XMLContent: "first"})
+		b.Add(nsq.MessageID{'2'}, pydiows.PydioInstantMessage{RepoID: "test", XMLContent: "<second/>"})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		req := newStreamRequest(ctx, "repos=test,writeonly")
+		req.Header.Set("Last-Event-ID", "1")
+
+		w := newRecorder()
+
+		type result struct {
+			code int
+			err  error
+		}
+
+		done := make(chan result, 1)
+		go func() {
+			code, err := h.ServeHTTP(w, req)
+			done <- result{code, err}
+		}()
+
+		So(w.next(false), ShouldEqual, "id: 2\ndata: {\"type\":\"event\",\"id\":\"2\",\"repo\":\"test\",\"content\":\"<second/>\"}\n\n")
+		So(w.next(true), ShouldEqual, ": heartbeat\n\n")
+
+		c := <-consumers
+		So(closed(c.started), ShouldBeTrue)
+
+		c.handler(&nsq.Message{ID: nsq.MessageID{'3'}, Body: []byte(`{"REPO_ID":"test","CONTENT":"live"}`)})
+		So(w.next(false), ShouldEqual, "id: 3\ndata: {\"type\":\"event\",\"id\":\"3\",\"repo\":\"test\",\"content\":\"live\"}\n\n")
+
+		// The client going away closes the connection
+		cancel()
+
+		res := <-done
+		So(res.err, ShouldBeNil)
+		So(res.code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+		So(closed(c.stopped), ShouldBeTrue)
+		So(pydiows.DefaultRegistry().Presence("test"), ShouldBeEmpty)
+	})
+
+	Convey("Invalid streams are refused", t, func() {
+		code, err := h.ServeHTTP(httptest.NewRecorder(), newStreamRequest(context.Background(), ""))
+		So(err, ShouldEqual, ErrMissingRepos)
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		code, err = h.ServeHTTP(httptest.NewRecorder(), newStreamRequest(context.Background(), "repo=writeonly&repo=evil"))
+		So(err, ShouldEqual, ErrForbidden)
+		So(code, ShouldEqual, http.StatusForbidden)
+
+		// The refused stream does not leave its consumer behind
+		c := <-consumers
+		So(closed(c.stopped), ShouldBeTrue)
+
+		code, _ = h.ServeHTTP(httptest.NewRecorder(), newStreamRequest(context.Background(), "repos=test&last_event_id=abc"))
+		So(code, ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("Other requests are passed through", t, func() {
+		code, _ := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/events", nil))
+		So(code, ShouldEqual, http.StatusTeapot)
+	})
+}
@@ -0,0 +1,137 @@
+// Package pydiosse contains the logic for the pydiosse caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiosse
+
+import (
+	"time"
+
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pydio/pydio-booster/server/middleware/pydiomiddleware"
+
+	pydiolog "github.com/pydio/pydio-booster/log"
+	pydiows "github.com/pydio/pydio-booster/websocket"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+)
+
+// PLUGIN name
+const PLUGIN = "pydiosse"
+
+// DefaultHeartbeat between two comments keeping the stream alive
+const DefaultHeartbeat = 30 * time.Second
+
+var logger *pydiolog.Logger
+
+func init() {
+	caddy.RegisterPlugin(PLUGIN, caddy.Plugin{
+		ServerType: "http",
+		Action:     setup,
+	})
+
+	logger = pydiolog.New(pydiolog.GetLevel(), "["+PLUGIN+"] ", pydiolog.Ldate|pydiolog.Ltime|pydiolog.Lmicroseconds)
+}
+
+// Setup the Pydio server-sent events middleware instance.
+func setup(c *caddy.Controller) error {
+
+	logger = pydiolog.New(pydiolog.GetLevel(), "["+PLUGIN+"] ", pydiolog.Ldate|pydiolog.Ltime|pydiolog.Lmicroseconds)
+
+	cfg := httpserver.GetConfig(c)
+
+	streams, middlewareRules, err := parse(c)
+	if err != nil {
+		return err
+	}
+
+	dispatcher := pydioworker.NewDispatcher(900)
+	dispatcher.Run()
+
+	// Telling the clients to reconnect elsewhere when booster stops
+	c.OnFinalShutdown(func() error {
+		pydiows.DefaultRegistry().CloseAll()
+		return nil
+	})
+
+	// Pre Middlewares
+	cfg.AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
+		return &pydiomiddleware.Handler{
+			Next:       next,
+			Rules:      middlewareRules["pre"],
+			Dispatcher: dispatcher,
+		}
+	})
+
+	cfg.AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
+		return &Handler{
+			Next:    next,
+			Streams: streams,
+		}
+	})
+
+	return nil
+}
+
+// parses the config from the caddy file
+func parse(c *caddy.Controller) (streams []Config, middlewareRules map[string][]pydiomiddleware.Rule, err error) {
+
+	for c.Next() {
+		if !c.NextArg() {
+			return streams, nil, c.ArgErr()
+		}
+
+		config := Config{
+			Path:      c.Val(),
+			Heartbeat: DefaultHeartbeat,
+		}
+
+		if c.NextBlock() {
+			if middlewareRules, err = pydiomiddleware.ParseWithDirectives(c, config.Path, config.parseDirective, "pre"); err != nil {
+				return streams, nil, err
+			}
+		}
+
+		streams = append(streams, config)
+	}
+
+	return streams, middlewareRules, nil
+}
+
+// parseDirective of the stream block
+func (config *Config) parseDirective(c *caddy.Controller) error {
+	switch c.Val() {
+	case "heartbeat":
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+
+		heartbeat, err := time.ParseDuration(args[0])
+		if err != nil || heartbeat <= 0 {
+			return c.Errf("Invalid heartbeat '%s'", args[0])
+		}
+
+		config.Heartbeat = heartbeat
+	default:
+		return c.Errf("Unknown property '%s'", c.Val())
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+// Package pydiostat contains the logic for the pydiostat caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiostat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+	"github.com/pydio/pydio-booster/io/localio"
+	"github.com/pydio/pydio-booster/io/s3io"
+)
+
+const (
+	// ActionStat answers the metadata of the node
+	ActionStat = "stat"
+
+	// ActionList answers the metadata of the children of the folder node
+	ActionList = "ls"
+)
+
+var (
+	// ErrForbidden is returned when the user cannot read the repository
+	ErrForbidden = errors.New("Stat is not allowed for the user")
+
+	// ErrNotFolder is returned when listing a file
+	ErrNotFolder = errors.New("Node is not a folder")
+
+	// ErrUnknownAction is returned for an action other than stat or ls
+	ErrUnknownAction = errors.New("Unknown action")
+
+	// ErrUnknownStorage is returned when the options hold no supported storage type
+	ErrUnknownStorage = errors.New("Unknown storage type")
+)
+
+type (
+	// Handler structure
+	Handler struct {
+		Next  httpserver.Handler
+		Rules []Rule
+	}
+
+	// Rule for the stats
+	Rule struct {
+		Path string
+	}
+
+	// Entry answered for a node, its path being relative to the repository
+	Entry struct {
+		Path string `json:"path"`
+		Name string `json:"name"`
+
+		*pydio.Stat
+	}
+)
+
+// ServeHTTP answers the metadata of the node of the request in JSON. The
+// action query selects the node itself (stat, the default) or its children
+// (ls). The checksum query computes the checksum of a local file
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+
+	switch r.Method {
+	case http.MethodGet:
+		for _, rule := range h.Rules {
+			if httpserver.Path(r.URL.Path).Matches(rule.Path) {
+
+				r = pydhttp.WithRequestID(w, r)
+
+				res := errHandle(r, handle(w, r))
+
+				if res.Err != nil {
+					pydhttp.RequestLogger(r.Context(), logger).Errorln("Pydio Stat returns an error : ", res.Err)
+
+					return res.StatusCode, res.Err
+				}
+
+				return http.StatusOK, nil
+			}
+		}
+	}
+
+	return h.Next.ServeHTTP(w, r)
+}
+
+func errHandle(r *http.Request, f func() *pydhttp.Status) *pydhttp.Status {
+
+	ctx := r.Context()
+
+	c := make(chan *pydhttp.Status, 1)
+
+	if err := ctx.Err(); err != nil {
+		return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
+
+	go func() { c <- f() }()
+
+	select {
+	case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		}
+	case res := <-c:
+		return res
+	}
+
+	return pydhttp.NewStatusOK(r)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) func() *pydhttp.Status {
+
+	return func() *pydhttp.Status {
+
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		user, err := pydhttp.UserFromContext(ctx)
+		if err != nil {
+			return pydhttp.NewStatusErr(http.StatusUnauthorized, err)
+		}
+
+		node, err := pydhttp.NodeFromContext(ctx)
+		if err != nil {
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		}
+
+		options, err := pydhttp.OptionsFromContext(ctx)
+		if err != nil {
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		}
+
+		if options.Path == "" {
+			return pydhttp.NewStatusErr(http.StatusFailedDependency, errors.New("Could not retrieve the context node or context options"))
+		}
+
+		if !user.GetRepo(node.Repo.String()).IsReadable() {
+			return pydhttp.NewStatusErr(http.StatusForbidden, ErrForbidden)
+		}
+
+		action := query.Get("action")
+		if action == "" {
+			action = ActionStat
+		}
+
+		if action != ActionStat && action != ActionList {
+			return pydhttp.NewStatusErr(http.StatusBadRequest, ErrUnknownAction)
+		}
+
+		p := path.Clean("/" + options.Path)
+
+		node = pydio.NewNode(node.Repo.String(), path.Dir(p), path.Base(p))
+		node.Options = *options
+
+		var storage storage
+		switch options.FileOptions.Type {
+		case "fs", "local":
+			storage, err = newLocalStorage(node)
+		case "s3":
+			storage = s3Storage{node}
+		default:
+			err = ErrUnknownStorage
+		}
+
+		if err != nil {
+			return pydhttp.NewStatusErr(statusCode(err), err)
+		}
+
+		stat, err := storage.Stat()
+		if err != nil {
+			return pydhttp.NewStatusErr(statusCode(err), err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if action == ActionStat {
+			if withChecksum, _ := strconv.ParseBool(query.Get("checksum")); withChecksum && !stat.IsDir && stat.Checksum == "" {
+				if stat.Checksum, err = storage.Checksum(); err != nil {
+					return pydhttp.NewStatusErr(statusCode(err), err)
+				}
+			}
+
+			json.NewEncoder(w).Encode(Entry{Path: p, Name: path.Base(p), Stat: stat})
+
+			return pydhttp.NewStatusOK(r, ctx)
+		}
+
+		if !stat.IsDir {
+			return pydhttp.NewStatusErr(http.StatusConflict, ErrNotFolder)
+		}
+
+		children, err := storage.List()
+		if err != nil {
+			return pydhttp.NewStatusErr(statusCode(err), err)
+		}
+
+		entries := make([]Entry, 0, len(children))
+		for _, child := range children {
+			entries = append(entries, Entry{
+				Path: path.Join(p, child.Basename),
+				Name: child.Basename,
+				Stat: child.Stat,
+			})
+		}
+
+		json.NewEncoder(w).Encode(entries)
+
+		return pydhttp.NewStatusOK(r, ctx)
+	}
+}
+
+// statusCode answered for an error of the storage
+func statusCode(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case err == localio.ErrOutsideRoot:
+		return http.StatusForbidden
+	case err == ErrUnknownStorage:
+		return http.StatusFailedDependency
+	}
+
+	return http.StatusInternalServerError
+}
+
+// storage driver of the node
+type storage interface {
+	Stat() (*pydio.Stat, error)
+	List() ([]*pydio.Node, error)
+	Checksum() (string, error)
+}
+
+type localStorage struct {
+	node *pydio.Node
+}
+
+// newLocalStorage for the node, sandboxed under the root of the options
+func newLocalStorage(node *pydio.Node) (*localStorage, error) {
+	local, err := localio.Lookup(node.Options.FileOptions.Path, pydio.GetNormalization(node.Repo.String()), node.Options.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localStorage{local}, nil
+}
+
+func (s *localStorage) Stat() (*pydio.Stat, error)   { return localio.Stat(s.node) }
+func (s *localStorage) List() ([]*pydio.Node, error) { return localio.List(s.node) }
+func (s *localStorage) Checksum() (string, error)    { return localio.Checksum(s.node) }
+
+type s3Storage struct {
+	node *pydio.Node
+}
+
+func (s s3Storage) Stat() (*pydio.Stat, error)   { return s3io.Stat(s.node) }
+func (s s3Storage) List() ([]*pydio.Node, error) { return s3io.List(s.node) }
+
+// Checksum of an S3 node is only known from its ETag
+func (s s3Storage) Checksum() (string, error) { return "", nil }
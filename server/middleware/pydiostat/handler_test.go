@@ -0,0 +1,137 @@
+// Package pydiotransfer contains the logic for the pydiotransfer caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiostat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newHandler() *Handler {
+	return &Handler{
+		Next: httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			return http.StatusTeapot, nil
+		}),
+		Rules: []Rule{{Path: "/stat"}},
+	}
+}
+
+func newStatRequest(root string, p string, query string, acl string) *http.Request {
+	req := httptest.NewRequest("GET", "/stat/my-files?"+query, nil)
+
+	options := &pydio.Options{
+		Path:        p,
+		FileOptions: pydio.FileOptions{Type: "fs", Path: root},
+	}
+
+	user := &pydio.User{
+		ID:    "admin",
+		Repos: []pydio.Repo{{ID: "my-files", ACL: acl}},
+	}
+
+	ctx := pydhttp.NewContext(req.Context(), "node", pydio.NewNode("my-files", "file.txt"))
+	ctx = pydhttp.NewContext(ctx, "options", options)
+	ctx = pydhttp.NewContext(ctx, "user", user)
+
+	return req.WithContext(ctx)
+}
+
+func TestStat(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "pydiostat")
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "folder", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "folder", "file.txt"), []byte("content"), 0644)
+
+	h := newHandler()
+
+	Convey("Stat of a file", t, func() {
+		w := httptest.NewRecorder()
+
+		code, err := h.ServeHTTP(w, newStatRequest(dir, "/folder/file.txt", "checksum=true", "r"))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusOK)
+
+		var entry Entry
+		So(json.NewDecoder(w.Body).Decode(&entry), ShouldBeNil)
+		So(entry.Path, ShouldEqual, "/folder/file.txt")
+		So(entry.Name, ShouldEqual, "file.txt")
+		So(entry.Size, ShouldEqual, 7)
+		So(entry.IsDir, ShouldBeFalse)
+		So(entry.MIME, ShouldStartWith, "text/plain")
+		So(entry.ETag, ShouldNotBeEmpty)
+		So(entry.Checksum, ShouldEqual, "9a0364b9e99bb480dd25e1f0284c8555")
+		So(entry.MTime.IsZero(), ShouldBeFalse)
+	})
+
+	Convey("Listing a folder", t, func() {
+		w := httptest.NewRecorder()
+
+		code, err := h.ServeHTTP(w, newStatRequest(dir, "/folder", "action=ls", "r"))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusOK)
+
+		var entries []Entry
+		So(json.NewDecoder(w.Body).Decode(&entries), ShouldBeNil)
+		So(entries, ShouldHaveLength, 2)
+
+		So(entries[0].Path, ShouldEqual, "/folder/file.txt")
+		So(entries[0].Size, ShouldEqual, 7)
+		So(entries[1].Path, ShouldEqual, "/folder/sub")
+		So(entries[1].IsDir, ShouldBeTrue)
+	})
+
+	Convey("Invalid stats are refused", t, func() {
+		code, err := h.ServeHTTP(httptest.NewRecorder(), newStatRequest(dir, "/folder", "", "w"))
+		So(err, ShouldEqual, ErrForbidden)
+		So(code, ShouldEqual, http.StatusForbidden)
+
+		code, _ = h.ServeHTTP(httptest.NewRecorder(), newStatRequest(dir, "/missing.txt", "", "r"))
+		So(code, ShouldEqual, http.StatusNotFound)
+
+		code, _ = h.ServeHTTP(httptest.NewRecorder(), newStatRequest(dir, "/../outside", "", "r"))
+		So(code, ShouldEqual, http.StatusForbidden)
+
+		code, err = h.ServeHTTP(httptest.NewRecorder(), newStatRequest(dir, "/folder/file.txt", "action=ls", "r"))
+		So(err, ShouldEqual, ErrNotFolder)
+		So(code, ShouldEqual, http.StatusConflict)
+
+		code, _ = h.ServeHTTP(httptest.NewRecorder(), newStatRequest(dir, "/folder", "action=rm", "r"))
+		So(code, ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("Other requests are passed through", t, func() {
+		code, _ := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/stat", nil))
+		So(code, ShouldEqual, http.StatusTeapot)
+	})
+}
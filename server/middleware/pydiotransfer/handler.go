@@ -0,0 +1,278 @@
+// Package pydiotransfer contains the logic for the pydiotransfer caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiotransfer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"github.com/pydio/pydio-booster/com"
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+	"github.com/pydio/pydio-booster/io/localio"
+	"github.com/pydio/pydio-booster/io/transfer"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+)
+
+// ProgressTopic on which the progress of the transfers is published
+const ProgressTopic = "transfer"
+
+var (
+	// ErrForbidden is returned when the user cannot read the source or write the target
+	ErrForbidden = errors.New("Transfer is not allowed for the user")
+
+	// ErrInvalidTarget is returned when the target path is missing or invalid
+	ErrInvalidTarget = errors.New("Invalid target path")
+
+	// ErrUnresolvedTarget is returned for a target in another repository
+	// when its options are not in the context
+	ErrUnresolvedTarget = errors.New("Target repository options could not be retrieved")
+)
+
+type (
+	// Handler structure
+	Handler struct {
+		Next       httpserver.Handler
+		Rules      []Rule
+		Dispatcher *pydioworker.Dispatcher
+		Transfers  *pydioworker.Dispatcher
+	}
+
+	// Rule for the transfers
+	Rule struct {
+		Path string
+	}
+)
+
+// ServeHTTP copies or moves the node of the request. The query holds the
+// action (copy or move), the target path, the optional target repository
+// and whether the transfer should run in the background.
+// The options of a target in another storage are read from the "target"
+// context value, filled by a pre middleware with "out target"
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+
+	switch r.Method {
+	case http.MethodPost:
+		for _, rule := range h.Rules {
+			if httpserver.Path(r.URL.Path).Matches(rule.Path) {
+
+				r = pydhttp.WithRequestID(w, r)
+
+				res := errHandle(r, handle(w, r, h))
+
+				if res.Err != nil {
+					pydhttp.RequestLogger(r.Context(), logger).Errorln("Pydio Transfer returns an error : ", res.Err)
+
+					return res.StatusCode, res.Err
+				}
+
+				return http.StatusOK, nil
+			}
+		}
+	}
+
+	return h.Next.ServeHTTP(w, r)
+}
+
+func errHandle(r *http.Request, f func() *pydhttp.Status) *pydhttp.Status {
+
+	ctx := r.Context()
+
+	c := make(chan *pydhttp.Status, 1)
+
+	if err := ctx.Err(); err != nil {
+		return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
+
+	go func() { c <- f() }()
+
+	select {
+	case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		}
+	case res := <-c:
+		return res
+	}
+
+	return pydhttp.NewStatusOK(r)
+}
+
+func handle(w http.ResponseWriter, r *http.Request, h *Handler) func() *pydhttp.Status {
+
+	return func() *pydhttp.Status {
+
+		logger := pydhttp.RequestLogger(r.Context(), logger)
+
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		user, err := pydhttp.UserFromContext(ctx)
+		if err != nil {
+			return pydhttp.NewStatusErr(http.StatusUnauthorized, err)
+		}
+
+		node, err := pydhttp.NodeFromContext(ctx)
+		if err != nil {
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		}
+
+		options, err := pydhttp.OptionsFromContext(ctx)
+		if err != nil {
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		}
+
+		if options.Path == "" {
+			return pydhttp.NewStatusErr(http.StatusFailedDependency, errors.New("Could not retrieve the context node or context options"))
+		}
+
+		sourceRepo := node.Repo.String()
+		targetRepo := query.Get("target_repo")
+		if targetRepo == "" {
+			targetRepo = sourceRepo
+		}
+
+		// Target in the same storage by default. Another repository is
+		// only written with the options resolved for it
+		targetOptions := *options
+		var contextOptions *pydio.Options
+		if err := pydhttp.FromContext(ctx, "target", &contextOptions); err == nil && contextOptions != nil {
+			targetOptions = *contextOptions
+		} else if err != nil && err != pydhttp.ErrNotInContext {
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		} else if targetRepo != sourceRepo {
+			return pydhttp.NewStatusErr(http.StatusBadRequest, ErrUnresolvedTarget)
+		}
+
+		targetPath := query.Get("target")
+		if targetPath == "" {
+			targetPath = targetOptions.Path
+		}
+
+		if !validPath(targetPath) {
+			return pydhttp.NewStatusErr(http.StatusBadRequest, ErrInvalidTarget)
+		}
+
+		targetOptions.Path = targetPath
+
+		action := query.Get("action")
+
+		// Moving removes the source, the target is the repository whose storage is written
+		if !user.GetRepo(sourceRepo).IsReadable() ||
+			(action == transfer.OpMove && !user.GetRepo(sourceRepo).IsWritable()) ||
+			!user.GetRepo(targetRepo).IsWritable() {
+			return pydhttp.NewStatusErr(http.StatusForbidden, ErrForbidden)
+		}
+
+		t, err := transfer.New(action, newNode(sourceRepo, options), newNode(targetRepo, &targetOptions))
+		if err != nil {
+			return pydhttp.NewStatusErr(http.StatusBadRequest, err)
+		}
+
+		t.Owner = user.ID
+		t.Dispatcher = h.Dispatcher
+		t.OnProgress = publish
+
+		logger.Infof("Transfer %s : %s %s to %s", t.ID, action, t.Source, t.Target)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if background, _ := strconv.ParseBool(query.Get("background")); background {
+			progress := t.Progress()
+
+			h.Transfers.Add(t)
+
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(progress)
+
+			return pydhttp.NewStatusOK(r, ctx)
+		}
+
+		if err := t.Run(ctx); err != nil {
+			return pydhttp.NewStatusErr(statusCode(err), err)
+		}
+
+		json.NewEncoder(w).Encode(t.Progress())
+
+		return pydhttp.NewStatusOK(r, ctx)
+	}
+}
+
+// newNode of the repository at the path of the options
+func newNode(repo string, options *pydio.Options) *pydio.Node {
+	node := pydio.NewNode(repo, path.Dir(options.Path), path.Base(options.Path))
+	node.Options = *options
+
+	return node
+}
+
+// validPath is an absolute path within the repository
+func validPath(p string) bool {
+	if p == "" || strings.IndexByte(p, 0) >= 0 {
+		return false
+	}
+
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+
+	return path.Clean("/"+p) != "/"
+}
+
+// publish the progress of the transfer if NSQ is running
+func publish(progress transfer.Progress) {
+	if !com.IsRunning() {
+		return
+	}
+
+	b, err := json.Marshal(progress)
+	if err != nil {
+		return
+	}
+
+	if err := com.Publish(com.Message{Topic: ProgressTopic, Content: b}); err != nil {
+		logger.Debugln("Could not publish the progress ", err)
+	}
+}
+
+// statusCode answered for an error of the transfer
+func statusCode(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case err == localio.ErrOutsideRoot:
+		return http.StatusForbidden
+	case err == pydio.ErrLocked:
+		return http.StatusLocked
+	case err == transfer.ErrSameNode, err == transfer.ErrTargetInsideSource:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,183 @@
+// Package pydiotransfer contains the logic for the pydiotransfer caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiotransfer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+	"github.com/pydio/pydio-booster/io/transfer"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newHandler() *Handler {
+	d := pydioworker.NewDispatcher(10)
+	d.Run()
+
+	transfers := pydioworker.NewDispatcher(1)
+	transfers.Run()
+
+	return &Handler{
+		Next: httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			return http.StatusTeapot, nil
+		}),
+		Rules:      []Rule{{Path: "/transfer"}},
+		Dispatcher: d,
+		Transfers:  transfers,
+	}
+}
+
+func newTransferRequest(root string, query string, acl string) *http.Request {
+	req := httptest.NewRequest("POST", "/transfer/my-files?"+query, nil)
+
+	options := &pydio.Options{
+		Path:        "/file.txt",
+		FileOptions: pydio.FileOptions{Type: "fs", Path: root},
+	}
+
+	user := &pydio.User{
+		ID:    "admin",
+		Repos: []pydio.Repo{{ID: "my-files", ACL: acl}},
+	}
+
+	ctx := pydhttp.NewContext(req.Context(), "node", pydio.NewNode("my-files", "file.txt"))
+	ctx = pydhttp.NewContext(ctx, "options", options)
+	ctx = pydhttp.NewContext(ctx, "user", user)
+
+	return req.WithContext(ctx)
+}
+
+func TestTransfer(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "pydiotransfer")
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0644)
+
+	h := newHandler()
+
+	Convey("Copying a node", t, func() {
+		w := httptest.NewRecorder()
+
+		code, err := h.ServeHTTP(w, newTransferRequest(dir, "action=copy&target=/copy/file.txt", "rw"))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusOK)
+
+		var progress transfer.Progress
+		So(json.NewDecoder(w.Body).Decode(&progress), ShouldBeNil)
+		So(progress.Done, ShouldBeTrue)
+		So(progress.Files, ShouldEqual, 1)
+
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "copy", "file.txt"))
+		So(string(content), ShouldEqual, "content")
+	})
+
+	Convey("Moving a node in the background", t, func() {
+		w := httptest.NewRecorder()
+
+		code, err := h.ServeHTTP(w, newTransferRequest(dir, "action=move&target=/moved.txt&background=true", "rw"))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusOK)
+		So(w.Code, ShouldEqual, http.StatusAccepted)
+
+		var progress transfer.Progress
+		So(json.NewDecoder(w.Body).Decode(&progress), ShouldBeNil)
+		So(progress.ID, ShouldNotBeEmpty)
+
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(filepath.Join(dir, "moved.txt")); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "moved.txt"))
+		So(string(content), ShouldEqual, "content")
+	})
+
+	Convey("Transfers need the rights on both repositories", t, func() {
+		code, err := h.ServeHTTP(httptest.NewRecorder(), newTransferRequest(dir, "action=copy&target=/other.txt", "r"))
+		So(err, ShouldEqual, ErrForbidden)
+		So(code, ShouldEqual, http.StatusForbidden)
+	})
+
+	Convey("Another repository is only written with its own options", t, func() {
+		ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0644)
+		defer os.Remove(filepath.Join(dir, "file.txt"))
+
+		req := newTransferRequest(dir, "action=copy&target=/common.txt&target_repo=common", "r")
+
+		user, _ := pydhttp.UserFromContext(req.Context())
+		user.Repos = append(user.Repos, pydio.Repo{ID: "common", ACL: "rw"})
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldEqual, ErrUnresolvedTarget)
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		_, err = os.Stat(filepath.Join(dir, "common.txt"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		commonDir, _ := ioutil.TempDir("", "pydiotransfer")
+		defer os.RemoveAll(commonDir)
+
+		req = req.WithContext(pydhttp.NewContext(req.Context(), "target", &pydio.Options{
+			FileOptions: pydio.FileOptions{Type: "fs", Path: commonDir},
+		}))
+
+		code, err = h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusOK)
+
+		content, _ := ioutil.ReadFile(filepath.Join(commonDir, "common.txt"))
+		So(string(content), ShouldEqual, "content")
+	})
+
+	Convey("Invalid transfers are refused", t, func() {
+		code, _ := h.ServeHTTP(httptest.NewRecorder(), newTransferRequest(dir, "action=copy&target=/../escape.txt", "rw"))
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		code, _ = h.ServeHTTP(httptest.NewRecorder(), newTransferRequest(dir, "action=rename&target=/other.txt", "rw"))
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		code, _ = h.ServeHTTP(httptest.NewRecorder(), newTransferRequest(dir, "action=copy&target=/other.txt&target_repo=common", "rw"))
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		code, _ = h.ServeHTTP(httptest.NewRecorder(), newTransferRequest(dir, "action=copy&target=/other.txt", "rw"))
+		So(code, ShouldEqual, http.StatusNotFound)
+	})
+
+	Convey("Other requests are passed through", t, func() {
+		code, _ := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/transfer", nil))
+		So(code, ShouldEqual, http.StatusTeapot)
+	})
+}
@@ -21,12 +21,14 @@
 package pydioupload
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -40,7 +42,6 @@ import (
 	"github.com/pydio/pydio-booster/io"
 	"github.com/pydio/pydio-booster/io/localio"
 	"github.com/pydio/pydio-booster/io/s3io"
-	"github.com/pydio/pydio-booster/log"
 	"github.com/pydio/pydio-booster/worker"
 )
 
@@ -55,24 +56,55 @@ type Handler struct {
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
 
 	switch r.Method {
-	case http.MethodOptions:
+	case http.MethodPost, http.MethodPut:
 		for _, rule := range h.Rules {
 			if httpserver.Path(r.URL.Path).Matches(rule.Path) {
-				return http.StatusOK, nil
+
+				r = pydhttp.WithRequestID(w, r)
+
+				// Refusing the request before reading any data
+				if err := rule.Limits.CheckRequest(r); err != nil {
+					return err.(*LimitError).StatusCode, err
+				}
+
+				rule.Limits.LimitBody(r)
+
+				f := handle(r, &rule, h.Dispatcher)
+				if r.Method == http.MethodPut {
+					f = handlePut(w, r, &rule, h.Dispatcher)
+				}
+
+				res := errHandle(r, f)
+
+				if res.Err != nil {
+					pydhttp.RequestLogger(r.Context(), logger).Errorln("Pydio Upload returns an error : ", res.Err)
+
+					return res.StatusCode, res.Err
+				}
+
+				r = r.WithContext(res.Context)
 			}
 		}
-	case http.MethodPost:
+	case MethodLock, MethodUnlock:
 		for _, rule := range h.Rules {
 			if httpserver.Path(r.URL.Path).Matches(rule.Path) {
 
-				res := errHandle(r, handle(r, h.Dispatcher))
+				r = pydhttp.WithRequestID(w, r)
+
+				f := handleLock(w, r)
+				if r.Method == MethodUnlock {
+					f = handleUnlock(w, r)
+				}
+
+				res := errHandle(r, f)
 
 				if res.Err != nil {
-					logger.Errorln("Pydio Upload returns an error : ", res.Err)
-					return http.StatusUnauthorized, res.Err
+					pydhttp.RequestLogger(r.Context(), logger).Errorln("Pydio Upload returns an error : ", res.Err)
+
+					return res.StatusCode, res.Err
 				}
 
-				r = r.WithContext(res.Context)
+				return http.StatusOK, nil
 			}
 		}
 	}
@@ -104,17 +136,19 @@ func errHandle(r *http.Request, f func() *pydhttp.Status) *pydhttp.Status {
 	return pydhttp.NewStatusOK(r)
 }
 
-func handle(r *http.Request, d *pydioworker.Dispatcher) func() *pydhttp.Status {
+func handle(r *http.Request, rule *Rule, d *pydioworker.Dispatcher) func() *pydhttp.Status {
 
 	return func() *pydhttp.Status {
 
+		logger := pydhttp.RequestLogger(r.Context(), logger)
+
 		logger.Infoln("REQ START")
 
 		start := time.Now()
 
 		defer func() {
 			elapsed := time.Since(start)
-			logger.Infoln("REQ END took %s", elapsed)
+			logger.Infof("REQ END took %s", elapsed)
 		}()
 
 		ctx := r.Context()
@@ -134,6 +168,10 @@ func handle(r *http.Request, d *pydioworker.Dispatcher) func() *pydhttp.Status {
 				break
 			}
 
+			if errors.Is(err, ErrRequestTooLarge) {
+				return limitStatus(&LimitError{http.StatusRequestEntityTooLarge, err})
+			}
+
 			if p == nil {
 				break
 			}
@@ -165,131 +203,418 @@ func handle(r *http.Request, d *pydioworker.Dispatcher) func() *pydhttp.Status {
 
 			if fileName != "" {
 
-				// Retrieving the node
-				var node = &pydio.Node{}
-				if err = getValueFromJSON(ctx, "node", node); err != nil {
-					return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+				node, status := contextNode(ctx)
+				if status != nil {
+					return status
 				}
 
-				// Retrieving the options
-				var options = &pydio.Options{}
-				if err = getValueFromJSON(ctx, "options", options); err != nil {
-					return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+				// Checking the file before it reaches the storage
+				if status := checkNode(&rule.Limits, node); status != nil {
+					return status
 				}
 
-				log.Debugln("Context Options ", node, options)
+				content, status := sniff(&rule.Limits, p)
+				if status != nil {
+					return status
+				}
 
-				if options.Path == "" {
-					return pydhttp.NewStatusErr(http.StatusFailedDependency, errors.New("Could not retrieve the context node or context options"))
+				staged, status := stage(&rule.Limits, content, -1)
+				if status != nil {
+					return status
 				}
+				defer unstage(staged)
 
-				// Retrieving request options
-				if options.PartialUpload {
-					fileName = fmt.Sprintf("%s.dpart", fileName)
+				if status := upload(ctx, d, node, staged, os.O_CREATE|os.O_WRONLY, "", lockToken(r)); status != nil {
+					return status
 				}
+			}
+		}
 
-				dir := path.Dir(options.Path)
-				name := path.Base(options.Path)
+		logger.Debugln(ctx.Value("node"))
 
-				node = pydio.NewNode(
-					node.Repo.String(),
-					dir,
-					name,
-				)
+		return pydhttp.NewStatusOK(r, ctx)
+	}
+}
 
-				node.Options = *options
+// handlePut writes the raw body of the request to the node
+func handlePut(w http.ResponseWriter, r *http.Request, rule *Rule, d *pydioworker.Dispatcher) func() *pydhttp.Status {
 
-				// Refreshing context
-				//ctx = pydhttp.NewContext(ctx, "node", node)
-				// ctx = pydhttp.NewContext(ctx, "options", options)
+	return func() *pydhttp.Status {
 
-				// Local file system, creating the Node
-				var file *pydio.File
-				if options.FileOptions.Type == "fs" {
-					localNode := pydio.NewNode("local", options.FileOptions.Path, dir, name)
-					file, err = localio.Open(localNode, os.O_CREATE|os.O_WRONLY)
-				} else if options.FileOptions.Type == "s3" {
-					file, err = s3io.Open(node, os.O_CREATE|os.O_WRONLY)
-				}
+		logger := pydhttp.RequestLogger(r.Context(), logger)
 
-				defer func() {
-					if file != nil {
-						file.Close()
-					}
-				}()
+		logger.Infoln("PUT START")
 
-				if err != nil {
-					logger.Errorln(err)
-					return pydhttp.NewStatusErr(http.StatusUnauthorized, err)
-				}
+		start := time.Now()
 
-				offset := int64(0)
+		defer func() {
+			elapsed := time.Since(start)
+			logger.Infof("PUT END took %s", elapsed)
+		}()
 
-				logger.Debugln("Starting the copy ?")
-				for {
-					var b bytes.Buffer
-					var n int64
+		ctx := r.Context()
 
-					logger.Debugln("Copying")
+		node, status := contextNode(ctx)
+		if status != nil {
+			return status
+		}
 
-					// 1 MB buffer
-					n, err = io.CopyN(&b, p, 1*1024*1024)
-					if err != nil && err != io.EOF {
-						break
-					}
+		if status := checkNode(&rule.Limits, node); status != nil {
+			return status
+		}
 
-					job := &Job{
-						File:     file,
-						Buf:      b,
-						Offset:   offset,
-						NumBytes: n,
-					}
+		// Making sure we receive everything that was announced
+		body := &lengthReader{Reader: r.Body, length: r.ContentLength}
+
+		// The size of the file is only known from a raw body
+		var content io.Reader = body
+		var size int64 = -1
+		switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+		case "", "identity":
+			size = r.ContentLength
+		case "gzip":
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				return pydhttp.NewStatusErr(http.StatusBadRequest, err)
+			}
+			defer gz.Close()
 
-					file.Add(1)
-					d.Add(job)
+			content = gz
+		default:
+			return limitStatus(newLimitError(http.StatusUnsupportedMediaType, "Content encoding %s is not supported", r.Header.Get("Content-Encoding")))
+		}
 
-					offset += n
+		content, status = sniff(&rule.Limits, content)
+		if status != nil {
+			return status
+		}
 
-					if err == io.EOF {
-						break
-					}
-				}
-			}
+		staged, status := stage(&rule.Limits, content, size)
+		if status != nil {
+			return status
 		}
+		defer unstage(staged)
 
-		logger.Debugln(ctx.Value("node"))
+		if status := upload(ctx, d, node, staged, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, r.Header.Get("If-Match"), lockToken(r)); status != nil {
+			return status
+		}
+
+		if tag, err := etag(node); err == nil {
+			w.Header().Set("ETag", tag)
+		}
 
 		return pydhttp.NewStatusOK(r, ctx)
 	}
 }
 
-// asynchronously retrieve values sitting in the context
-func getValueFromJSON(ctx context.Context, key string, value interface{}) error {
+// sniff the type of the content if the limits require it
+func sniff(limits *Limits, r io.Reader) (io.Reader, *pydhttp.Status) {
+	if !limits.SniffsType() {
+		return r, nil
+	}
 
-	// var node *pydio.Node
-	var buf bytes.Buffer
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		if errors.Is(err, ErrRequestTooLarge) {
+			return nil, limitStatus(&LimitError{http.StatusRequestEntityTooLarge, err})
+		}
+		return nil, pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
 
-	if err := pydhttp.FromContext(ctx, key, &buf); err != nil {
-		return err
+	if err := limits.CheckType(http.DetectContentType(head)); err != nil {
+		return nil, limitStatus(err)
 	}
 
-	data := buf.String()
-	if unquoted, err := strconv.Unquote(strings.Trim(data, "\n")); err == nil {
-		data = unquoted
+	return br, nil
+}
+
+// checkNode against the limits, under the name it is stored with
+func checkNode(limits *Limits, node *pydio.Node) *pydhttp.Status {
+	if err := limits.CheckName(path.Base(node.Options.Path)); err != nil {
+		return limitStatus(err)
 	}
 
-	dec := json.NewDecoder(strings.NewReader(data))
-	if err := dec.Decode(&value); err != nil {
-		logger.Errorf("value for %s : %v", key, err)
-		return err
+	// Size announced for a partial upload
+	if node.Options.PartialUpload {
+		if err := limits.CheckSize(node.Options.PartialTargetBytesize); err != nil {
+			return limitStatus(err)
+		}
 	}
 
 	return nil
 }
 
+// stage the content in a temporary file when the size limit can only be
+// checked once it is all read, so that a file over the limit never reaches
+// the storage. Otherwise the content is streamed and checked on the way
+func stage(limits *Limits, content io.Reader, size int64) (io.Reader, *pydhttp.Status) {
+
+	if limits.MaxFileSize <= 0 {
+		return content, nil
+	}
+
+	if size >= 0 {
+		if err := limits.CheckSize(size); err != nil {
+			return nil, limitStatus(err)
+		}
+
+		return &limitedReader{ioutil.NopCloser(content), limits.MaxFileSize}, nil
+	}
+
+	file, err := ioutil.TempFile("", "pydioupload")
+	if err != nil {
+		return nil, pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
+
+	// Reading one more byte than allowed to detect a file going over the limit
+	if limits.MaxFileSize > 0 {
+		content = io.LimitReader(content, limits.MaxFileSize+1)
+	}
+
+	n, err := io.Copy(file, content)
+	if err == nil {
+		err = limits.CheckSize(n)
+	}
+
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		unstage(file)
+
+		if errors.Is(err, ErrRequestTooLarge) {
+			return nil, limitStatus(&LimitError{http.StatusRequestEntityTooLarge, err})
+		}
+
+		if _, ok := err.(*LimitError); ok {
+			return nil, limitStatus(err)
+		}
+
+		return nil, limitStatus(newLimitError(http.StatusBadRequest, "Could not read the content : %v", err))
+	}
+
+	return file, nil
+}
+
+// unstage removes the temporary file of the staged content
+func unstage(content io.Reader) {
+	if file, ok := content.(*os.File); ok {
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
+
+// upload the content to the storage of the node.
+// If ifMatch is set, the current version of the node must have this ETag.
+// If lockToken is set, it must be the lock held on the node, otherwise a
+// transient lock is taken for the time of the upload
+func upload(ctx context.Context, d *pydioworker.Dispatcher, node *pydio.Node, content io.Reader, flag int, ifMatch string, lockToken string) *pydhttp.Status {
+
+	logger := pydhttp.RequestLogger(ctx, logger)
+
+	options := &node.Options
+
+	// Making sure no one else writes to the node
+	locks := pydio.DefaultLockManager()
+	if lockToken != "" {
+		if err := locks.Check(node, lockToken); err != nil {
+			return lockStatus(err)
+		}
+	} else {
+		lock, err := locks.Acquire(ctx, node, contextOwner(ctx))
+		if err != nil {
+			return lockStatus(err)
+		}
+
+		// Released once the file is closed and all the chunks are written
+		defer locks.Unlock(lock.Token)
+
+		lockToken = lock.Token
+	}
+
+	if ifMatch != "" {
+		if err := checkIfMatch(node, ifMatch); err != nil {
+			return limitStatus(err)
+		}
+	}
+
+	// Local file system, creating the Node
+	var file *pydio.File
+	var localNode, target *pydio.Node
+	var err error
+	if options.FileOptions.Type == "fs" {
+		normalization := pydio.GetNormalization(node.Repo.String())
+
+		localNode, err = localio.Lookup(options.FileOptions.Path, normalization, options.Path)
+
+		// A replaced file is written next to it and renamed once complete,
+		// so that a failed upload never leaves a partial content
+		if err == nil && flag&os.O_TRUNC != 0 {
+			target = localNode
+			localNode, err = localio.Lookup(options.FileOptions.Path, normalization, path.Dir(options.Path), fmt.Sprintf(".%s.%d.upload", path.Base(options.Path), time.Now().UnixNano()))
+		}
+
+		if err == nil {
+			file, err = localio.Open(localNode, flag)
+		}
+	} else if options.FileOptions.Type == "s3" {
+		file, err = s3io.Open(node, flag)
+	}
+
+	defer func() {
+		if file != nil {
+			file.Close()
+
+			if target != nil {
+				localio.Remove(localNode)
+			}
+		}
+	}()
+
+	if err == localio.ErrOutsideRoot {
+		return pydhttp.NewStatusErr(http.StatusForbidden, err)
+	}
+
+	if err != nil {
+		logger.Errorln(err)
+		return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
+
+	offset := int64(0)
+
+	logger.Debugln("Starting the copy ?")
+	for {
+		var b bytes.Buffer
+		var n int64
+
+		logger.Debugln("Copying")
+
+		// 1 MB buffer
+		n, err = io.CopyN(&b, content, 1*1024*1024)
+		if err != nil && err != io.EOF {
+			break
+		}
+
+		job := &Job{
+			File:     file,
+			Buf:      b,
+			Offset:   offset,
+			NumBytes: n,
+		}
+
+		file.Add(1)
+		d.Add(job)
+
+		offset += n
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if err != nil && err != io.EOF {
+		if errors.Is(err, ErrRequestTooLarge) {
+			return limitStatus(&LimitError{http.StatusRequestEntityTooLarge, err})
+		}
+
+		if _, ok := err.(*LimitError); ok {
+			return limitStatus(err)
+		}
+
+		return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
+
+	if target != nil {
+		// Waiting for all the chunks to be written
+		file.Close()
+		file = nil
+
+		// The lock may have been lost to another instance meanwhile
+		if err := locks.Check(node, lockToken); err != nil {
+			localio.Remove(localNode)
+			return lockStatus(err)
+		}
+
+		if err := localio.Rename(localNode, target); err != nil {
+			localio.Remove(localNode)
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		}
+	}
+
+	return nil
+}
+
+// etag of the current version of the node in its storage
+func etag(node *pydio.Node) (string, error) {
+	switch node.Options.FileOptions.Type {
+	case "fs":
+		localNode, err := localio.Lookup(node.Options.FileOptions.Path, pydio.GetNormalization(node.Repo.String()), node.Dir.String(), node.Basename)
+		if err != nil {
+			return "", err
+		}
+
+		return localio.ETag(localNode)
+	case "s3":
+		return s3io.ETag(node)
+	}
+
+	return "", fmt.Errorf("Unknown storage type %s", node.Options.FileOptions.Type)
+}
+
+// checkIfMatch verifies the If-Match precondition against the current version of the node
+func checkIfMatch(node *pydio.Node, ifMatch string) error {
+	current, err := etag(node)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if current != "" {
+		for _, tag := range strings.Split(ifMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == current {
+				return nil
+			}
+		}
+	}
+
+	return newLimitError(http.StatusPreconditionFailed, "The node has been modified")
+}
+
+// lengthReader fails at the end of the content if it is not of the
+// announced length
+type lengthReader struct {
+	io.Reader
+	n      int64
+	length int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	n, err := l.Reader.Read(p)
+	l.n += int64(n)
+
+	if err == io.EOF && l.length >= 0 && l.n != l.length {
+		return n, newLimitError(http.StatusBadRequest, "Received %d bytes out of %d", l.n, l.length)
+	}
+
+	return n, err
+}
+
+// limitStatus of a limit violation
+func limitStatus(err error) *pydhttp.Status {
+	code := http.StatusBadRequest
+	if limitErr, ok := err.(*LimitError); ok {
+		code = limitErr.StatusCode
+	}
+
+	return pydhttp.NewStatusErr(code, err)
+}
+
 // Rule for the uploader
 type (
 	Rule struct {
-		Path string
+		Path   string
+		Limits Limits
 	}
 )
@@ -0,0 +1,299 @@
+// Package pydioupload contains the logic for the pydioupload caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydioupload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mholt/caddy"
+)
+
+var (
+	// ErrRequestTooLarge is returned when the request body goes over the limit
+	ErrRequestTooLarge = errors.New("Request body is too large")
+
+	// ErrFileTooLarge is returned when an uploaded file goes over the limit
+	ErrFileTooLarge = errors.New("File is too large")
+)
+
+// sniffLen is the number of bytes used to detect the content type
+const sniffLen = 512
+
+// Limits applied to the uploads of a rule
+//
+//	pydioupload /io {
+//		max_file_size 100MB
+//		max_request_size 110MB
+//		allow_ext jpg png pdf
+//		deny_ext exe php
+//		allow_type image/* application/pdf
+//		deny_type application/x-msdownload
+//		filename ^[^<>:"|?*]+$
+//		max_filename_length 255
+//	}
+type Limits struct {
+	MaxFileSize       int64
+	MaxRequestSize    int64
+	AllowedExtensions []string
+	DeniedExtensions  []string
+	AllowedTypes      []string
+	DeniedTypes       []string
+	Filename          *regexp.Regexp
+	MaxFilenameLength int
+}
+
+// LimitError is a limit violation, answered with its status code
+type LimitError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *LimitError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap the error answered with the status code
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+func newLimitError(code int, format string, args ...interface{}) *LimitError {
+	return &LimitError{
+		StatusCode: code,
+		Err:        fmt.Errorf(format, args...),
+	}
+}
+
+// parseLimit reads a limit sub directive of the upload block
+func (l *Limits) parseLimit(c *caddy.Controller) error {
+	name := c.Val()
+	args := c.RemainingArgs()
+
+	if len(args) == 0 {
+		return c.ArgErr()
+	}
+
+	switch name {
+	case "max_file_size", "max_request_size":
+		size, err := parseSize(args[0])
+		if err != nil {
+			return c.Errf("Invalid size '%s' for %s", args[0], name)
+		}
+
+		if name == "max_file_size" {
+			l.MaxFileSize = size
+		} else {
+			l.MaxRequestSize = size
+		}
+	case "allow_ext":
+		l.AllowedExtensions = append(l.AllowedExtensions, normalizeExtensions(args)...)
+	case "deny_ext":
+		l.DeniedExtensions = append(l.DeniedExtensions, normalizeExtensions(args)...)
+	case "allow_type":
+		l.AllowedTypes = append(l.AllowedTypes, args...)
+	case "deny_type":
+		l.DeniedTypes = append(l.DeniedTypes, args...)
+	case "filename":
+		r, err := regexp.Compile(args[0])
+		if err != nil {
+			return c.Errf("Invalid filename pattern : %v", err)
+		}
+
+		l.Filename = r
+	case "max_filename_length":
+		length, err := strconv.Atoi(args[0])
+		if err != nil {
+			return c.ArgErr()
+		}
+
+		l.MaxFilenameLength = length
+	default:
+		return c.Errf("Unknown property '%s'", name)
+	}
+
+	return nil
+}
+
+// CheckRequest size as announced by the client. The raw body of a PUT is
+// the file itself
+func (l *Limits) CheckRequest(r *http.Request) error {
+	if l.MaxRequestSize > 0 && r.ContentLength > l.MaxRequestSize {
+		return newLimitError(http.StatusRequestEntityTooLarge, "Request size %d is over the limit of %d", r.ContentLength, l.MaxRequestSize)
+	}
+
+	if r.Method == http.MethodPut && r.Header.Get("Content-Encoding") == "" && l.MaxFileSize > 0 && r.ContentLength > l.MaxFileSize {
+		return newLimitError(http.StatusRequestEntityTooLarge, "File size %d is over the limit of %d", r.ContentLength, l.MaxFileSize)
+	}
+
+	return nil
+}
+
+// CheckName of an uploaded file, its characters and its extension
+func (l *Limits) CheckName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return newLimitError(http.StatusBadRequest, "Invalid file name")
+	}
+
+	if l.MaxFilenameLength > 0 && len(name) > l.MaxFilenameLength {
+		return newLimitError(http.StatusBadRequest, "File name is too long")
+	}
+
+	if l.Filename != nil && !l.Filename.MatchString(name) {
+		return newLimitError(http.StatusBadRequest, "File name %s is not allowed", name)
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+
+	if contains(l.DeniedExtensions, ext) || (len(l.AllowedExtensions) > 0 && !contains(l.AllowedExtensions, ext)) {
+		return newLimitError(http.StatusUnsupportedMediaType, "File extension '%s' is not allowed", ext)
+	}
+
+	return nil
+}
+
+// CheckType of the content, sniffed from its first bytes
+func (l *Limits) CheckType(contentType string) error {
+	if matchType(l.DeniedTypes, contentType) || (len(l.AllowedTypes) > 0 && !matchType(l.AllowedTypes, contentType)) {
+		return newLimitError(http.StatusUnsupportedMediaType, "File type '%s' is not allowed", contentType)
+	}
+
+	return nil
+}
+
+// CheckSize of the file uploaded so far
+func (l *Limits) CheckSize(size int64) error {
+	if l.MaxFileSize > 0 && size > l.MaxFileSize {
+		return &LimitError{http.StatusRequestEntityTooLarge, ErrFileTooLarge}
+	}
+
+	return nil
+}
+
+// SniffsType returns true if the content type of the files needs to be checked
+func (l *Limits) SniffsType() bool {
+	return len(l.AllowedTypes) > 0 || len(l.DeniedTypes) > 0
+}
+
+// LimitBody of the request to the maximum request size
+func (l *Limits) LimitBody(r *http.Request) {
+	if l.MaxRequestSize > 0 && r.Body != nil {
+		r.Body = &limitedReader{r.Body, l.MaxRequestSize}
+	}
+}
+
+// limitedReader fails with ErrRequestTooLarge once more than remaining bytes are read
+type limitedReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.ReadCloser.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+
+		return n, ErrRequestTooLarge
+	}
+
+	l.remaining -= int64(n)
+
+	return n, err
+}
+
+// parseSize in bytes, with an optional KB, MB or GB unit
+func parseSize(str string) (int64, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(str, unit.suffix) {
+			factor = unit.factor
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("Invalid size %s", str)
+	}
+
+	return size * factor, nil
+}
+
+func normalizeExtensions(exts []string) []string {
+	var normalized []string
+	for _, ext := range exts {
+		normalized = append(normalized, strings.ToLower(strings.TrimPrefix(ext, ".")))
+	}
+
+	return normalized
+}
+
+func contains(list []string, value string) bool {
+	for _, elem := range list {
+		if elem == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchType checks the content type against a list of types, accepting wildcards (image/*)
+func matchType(types []string, contentType string) bool {
+	// Removing the parameters (charset...)
+	contentType = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+
+	for _, t := range types {
+		if t == "*/*" || t == contentType {
+			return true
+		}
+
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
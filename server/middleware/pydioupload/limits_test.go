@@ -0,0 +1,234 @@
+// Package pydioupload contains the logic for the pydioupload caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydioupload
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newLimitedUploadHandler(input string) (*Handler, error) {
+	rules, _, err := parse(caddy.NewTestController("http", input))
+	if err != nil {
+		return nil, err
+	}
+
+	next := httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+		return http.StatusTeapot, nil
+	})
+
+	dispatcher := pydioworker.NewDispatcher(1)
+	dispatcher.Run()
+
+	return &Handler{Next: next, Rules: rules, Dispatcher: dispatcher}, nil
+}
+
+// newUploadRequest of the file to the path in the local dir
+func newUploadRequest(dir string, name string, target string, content []byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	p, _ := writer.CreateFormFile("userfile_0", name)
+	p.Write(content)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/io/my-files/dir", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	options := &pydio.Options{
+		Path:        target,
+		FileOptions: pydio.FileOptions{Type: "fs", Path: dir},
+	}
+
+	ctx := pydhttp.NewContext(req.Context(), "node", pydio.NewNode("my-files", target))
+	ctx = pydhttp.NewContext(ctx, "options", options)
+
+	return req.WithContext(ctx)
+}
+
+func TestLimits(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "pydioupload")
+	defer os.RemoveAll(dir)
+
+	h, err := newLimitedUploadHandler(`pydioupload /io {
+		max_file_size 1MB
+		max_request_size 2KB
+		deny_ext exe .PHP
+		allow_type image/* text/plain
+		filename ^[^<>:"|?*]+$
+		pre {
+			type node
+			out node
+		}
+	}`)
+
+	Convey("Parsing the limits along with the middlewares", t, func() {
+		So(err, ShouldBeNil)
+
+		limits := h.Rules[0].Limits
+		So(limits.MaxFileSize, ShouldEqual, 1<<20)
+		So(limits.MaxRequestSize, ShouldEqual, 2<<10)
+		So(limits.DeniedExtensions, ShouldResemble, []string{"exe", "php"})
+		So(limits.AllowedTypes, ShouldResemble, []string{"image/*", "text/plain"})
+
+		_, err := newLimitedUploadHandler(`pydioupload /io {
+			max_file_size lots
+		}`)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Checking the file names and types", t, func() {
+		limits := h.Rules[0].Limits
+
+		So(limits.CheckName("photo.jpg"), ShouldBeNil)
+		So(limits.CheckName("shell.PHP").(*LimitError).StatusCode, ShouldEqual, http.StatusUnsupportedMediaType)
+		So(limits.CheckName("what?.txt").(*LimitError).StatusCode, ShouldEqual, http.StatusBadRequest)
+		So(limits.CheckName("../passwd").(*LimitError).StatusCode, ShouldEqual, http.StatusBadRequest)
+
+		So(limits.CheckType("image/png"), ShouldBeNil)
+		So(limits.CheckType("text/plain; charset=utf-8"), ShouldBeNil)
+		So(limits.CheckType("application/pdf").(*LimitError).StatusCode, ShouldEqual, http.StatusUnsupportedMediaType)
+
+		So(limits.CheckSize(1<<20), ShouldBeNil)
+		So(limits.CheckSize(1<<20+1).(*LimitError).StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+	})
+
+	Convey("A request announcing a size over the limit is refused", t, func() {
+		req := newUploadRequest(dir, "photo.jpg", "/photo.jpg", bytes.Repeat([]byte("a"), 4096))
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusRequestEntityTooLarge)
+	})
+
+	Convey("A request going over the limit without announcing it is refused", t, func() {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("urlencoded_filename", string(bytes.Repeat([]byte("a"), 4096)))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/io/my-files/dir", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.ContentLength = -1
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusRequestEntityTooLarge)
+	})
+
+	Convey("A file with a forbidden sniffed type is refused", t, func() {
+		req := newUploadRequest(dir, "document.jpg", "/document.jpg", []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"))
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusUnsupportedMediaType)
+	})
+
+	Convey("A file with a forbidden extension is refused", t, func() {
+		req := newUploadRequest(dir, "setup.exe", "/setup.exe", []byte("hello"))
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusUnsupportedMediaType)
+
+		Convey("whatever the name sent by the client", func() {
+			req := newUploadRequest(dir, "notes.txt", "/setup.exe", []byte("hello"))
+
+			code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, http.StatusUnsupportedMediaType)
+
+			_, err = os.Stat(filepath.Join(dir, "setup.exe"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+
+	Convey("A file over the size limit never reaches the storage", t, func() {
+		h, _ := newLimitedUploadHandler(`pydioupload /io {
+			max_file_size 1MB
+		}`)
+
+		req := newUploadRequest(dir, "big.txt", "/big.txt", bytes.Repeat([]byte("a"), 2<<20))
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusRequestEntityTooLarge)
+
+		_, err = os.Stat(filepath.Join(dir, "big.txt"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		Convey("and a raw body announcing it is refused before being read", func() {
+			req := newPutRequest(dir, bytes.Repeat([]byte("a"), 2<<20))
+
+			code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, http.StatusRequestEntityTooLarge)
+
+			_, err = os.Stat(filepath.Join(dir, "file.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+	Convey("Files are only staged when their size must be checked before storing them", t, func() {
+		content := strings.NewReader("content")
+
+		staged, status := stage(&Limits{}, content, -1)
+		So(status, ShouldBeNil)
+		So(staged, ShouldEqual, content)
+
+		limits := &Limits{MaxFileSize: 4}
+
+		// A known size is checked straight away, the content is streamed
+		_, status = stage(limits, content, 7)
+		So(status, ShouldNotBeNil)
+		So(status.StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+
+		staged, status = stage(limits, strings.NewReader("more than announced"), 3)
+		So(status, ShouldBeNil)
+		So(staged, ShouldHaveSameTypeAs, &limitedReader{})
+
+		_, err := ioutil.ReadAll(staged)
+		So(err, ShouldEqual, ErrRequestTooLarge)
+
+		staged, status = stage(limits, strings.NewReader("abc"), -1)
+		So(status, ShouldBeNil)
+		defer unstage(staged)
+
+		So(staged, ShouldHaveSameTypeAs, &os.File{})
+
+		b, _ := ioutil.ReadAll(staged)
+		So(string(b), ShouldEqual, "abc")
+	})
+}
@@ -0,0 +1,219 @@
+// Package pydioupload contains the logic for the pydioupload caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydioupload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+)
+
+// WebDAV methods used to hold a lock across requests
+const (
+	MethodLock   = "LOCK"
+	MethodUnlock = "UNLOCK"
+)
+
+var (
+	// ErrMissingLockToken is returned when unlocking without a token
+	ErrMissingLockToken = errors.New("Missing Lock-Token header")
+
+	lockTokenRegexp = regexp.MustCompile(`<(opaquelocktoken:[^>]+)>`)
+)
+
+// handleLock locks the node for the user, or refreshes the lock given in the If header.
+// The token is sent back in the Lock-Token header
+func handleLock(w http.ResponseWriter, r *http.Request) func() *pydhttp.Status {
+
+	return func() *pydhttp.Status {
+
+		ctx := r.Context()
+
+		node, status := contextNode(ctx)
+		if status != nil {
+			return status
+		}
+
+		locks := pydio.DefaultLockManager()
+		timeout := parseLockTimeout(r.Header.Get("Timeout"))
+
+		var lock *pydio.Lock
+		var err error
+		if token := lockToken(r); token != "" {
+			if err = locks.Check(node, token); err == nil {
+				lock, err = locks.Refresh(token, timeout)
+			}
+		} else {
+			lock, err = locks.Lock(node, contextOwner(ctx), timeout)
+		}
+
+		if err != nil {
+			return lockStatus(err)
+		}
+
+		pydhttp.RequestLogger(ctx, logger).Infoln("Locked ", lock.Node)
+
+		w.Header().Set("Lock-Token", "<"+lock.Token+">")
+		w.Header().Set("Timeout", fmt.Sprintf("Second-%d", int(time.Until(lock.Expires).Seconds()+0.5)))
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(lock)
+
+		return pydhttp.NewStatusOK(r, ctx)
+	}
+}
+
+// handleUnlock releases the lock given in the Lock-Token header
+func handleUnlock(w http.ResponseWriter, r *http.Request) func() *pydhttp.Status {
+
+	return func() *pydhttp.Status {
+
+		ctx := r.Context()
+
+		node, status := contextNode(ctx)
+		if status != nil {
+			return status
+		}
+
+		token := lockToken(r)
+		if token == "" {
+			return pydhttp.NewStatusErr(http.StatusBadRequest, ErrMissingLockToken)
+		}
+
+		locks := pydio.DefaultLockManager()
+
+		lock := locks.Get(node)
+		if lock == nil || lock.Token != token {
+			return pydhttp.NewStatusErr(http.StatusConflict, pydio.ErrLockNotFound)
+		}
+
+		if err := locks.Unlock(token); err == pydio.ErrLockNotFound {
+			return pydhttp.NewStatusErr(http.StatusConflict, err)
+		} else if err != nil {
+			return lockStatus(err)
+		}
+
+		pydhttp.RequestLogger(ctx, logger).Infoln("Unlocked ", lock.Node)
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return pydhttp.NewStatusOK(r, ctx)
+	}
+}
+
+// contextNode built from the node and the options sitting in the context
+func contextNode(ctx context.Context) (*pydio.Node, *pydhttp.Status) {
+
+	logger := pydhttp.RequestLogger(ctx, logger)
+
+	// Retrieving the node
+	node, err := pydhttp.NodeFromContext(ctx)
+	if err != nil {
+		return nil, pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
+
+	// Retrieving the options
+	options, err := pydhttp.OptionsFromContext(ctx)
+	if err != nil {
+		return nil, pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+	}
+
+	logger.Debugln("Context Options ", node, options)
+
+	if options.Path == "" {
+		return nil, pydhttp.NewStatusErr(http.StatusFailedDependency, errors.New("Could not retrieve the context node or context options"))
+	}
+
+	dir := path.Dir(options.Path)
+	name := path.Base(options.Path)
+
+	node = pydio.NewNode(
+		node.Repo.String(),
+		dir,
+		name,
+	)
+
+	node.Options = *options
+
+	return node, nil
+}
+
+// contextOwner of the locks taken by the request
+func contextOwner(ctx context.Context) string {
+	user, err := pydhttp.UserFromContext(ctx)
+	if err != nil || user == nil {
+		return ""
+	}
+
+	return user.ID
+}
+
+// lockToken sent by the client, either in the Lock-Token header or in a WebDAV If header
+func lockToken(r *http.Request) string {
+	for _, header := range []string{"Lock-Token", "If"} {
+		if m := lockTokenRegexp.FindStringSubmatch(r.Header.Get(header)); m != nil {
+			return m[1]
+		}
+	}
+
+	return ""
+}
+
+// parseLockTimeout from a WebDAV Timeout header such as "Second-600" or "Infinite"
+func parseLockTimeout(header string) time.Duration {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+
+		if strings.EqualFold(value, "Infinite") {
+			return pydio.MaxLockTimeout
+		}
+
+		if strings.HasPrefix(value, "Second-") {
+			if n, err := strconv.Atoi(strings.TrimPrefix(value, "Second-")); err == nil && n > 0 {
+				return time.Duration(n) * time.Second
+			}
+		}
+	}
+
+	return pydio.DefaultLockTimeout
+}
+
+// lockStatus answers 423 to writes on a locked node and 412 to unknown tokens
+func lockStatus(err error) *pydhttp.Status {
+	switch err {
+	case pydio.ErrLocked:
+		return pydhttp.NewStatusErr(http.StatusLocked, err)
+	case pydio.ErrLockNotFound:
+		return pydhttp.NewStatusErr(http.StatusPreconditionFailed, err)
+	}
+
+	return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+}
@@ -0,0 +1,267 @@
+// Package pydioupload contains the logic for the pydioupload caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydioupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mholt/caddy"
+	"github.com/mholt/caddy/caddyfile"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+	pydio "github.com/pydio/pydio-booster/io"
+	"github.com/pydio/pydio-booster/server/middleware/pydiomiddleware"
+	pydioworker "github.com/pydio/pydio-booster/worker"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// contextJob resolves the node or the options of the request path, stored
+// in the local dir, as Pydio would
+type contextJob struct {
+	args *pydiomiddleware.JobArgs
+}
+
+func (j *contextJob) Do() error {
+	defer j.args.Close()
+
+	rel := strings.TrimPrefix(j.args.Request.URL.Path, "/io/my-files")
+
+	if j.args.Rule.Out.Name == "options" {
+		return j.args.Encoder.Encode(&pydio.Options{
+			Path:        rel,
+			FileOptions: pydio.FileOptions{Type: "fs", Path: j.args.Rule.Config.(string)},
+		})
+	}
+
+	return j.args.Encoder.Encode(pydio.NewNode("my-files", path.Dir(rel), path.Base(rel)))
+}
+
+func init() {
+	pydiomiddleware.RegisterQueryType("testcontext", pydiomiddleware.QueryType{
+		Action: func(ctx context.Context, args *pydiomiddleware.JobArgs) (pydioworker.Job, error) {
+			return &contextJob{args}, nil
+		},
+		Parse: func(d *caddyfile.Dispenser) (interface{}, error) {
+			var dir string
+			for d.Next() {
+				if d.Val() != "dir" || !d.NextArg() {
+					return nil, d.ArgErr()
+				}
+				dir = d.Val()
+			}
+			return dir, nil
+		},
+	})
+}
+
+// newChainedUploadHandler behind its pre middlewares
+func newChainedUploadHandler(input string) (httpserver.Handler, error) {
+	rules, middlewareRules, err := parse(caddy.NewTestController("http", input))
+	if err != nil {
+		return nil, err
+	}
+
+	next := httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+		return http.StatusTeapot, nil
+	})
+
+	dispatcher := pydioworker.NewDispatcher(1)
+	dispatcher.Run()
+
+	return &pydiomiddleware.Handler{
+		Next:       &Handler{Next: next, Rules: rules, Dispatcher: dispatcher},
+		Rules:      middlewareRules["pre"],
+		Dispatcher: dispatcher,
+	}, nil
+}
+
+func TestLock(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "pydioupload")
+	defer os.RemoveAll(dir)
+
+	pydio.SetLockManager(pydio.NewMemoryLockManager())
+
+	h, _ := newLimitedUploadHandler(`pydioupload /io`)
+
+	var token string
+
+	Convey("Locking a node", t, func() {
+		req := newPutRequest(dir, nil)
+		req.Method = MethodLock
+		req.Header.Set("Timeout", "Second-600")
+
+		w := httptest.NewRecorder()
+
+		code, err := h.ServeHTTP(w, req)
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusOK)
+
+		token = w.Header().Get("Lock-Token")
+		So(token, ShouldStartWith, "<opaquelocktoken:")
+		So(w.Header().Get("Timeout"), ShouldEqual, "Second-600")
+
+		code, err = h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusLocked)
+	})
+
+	Convey("Uploading to a locked node without the token fails", t, func() {
+		code, err := h.ServeHTTP(httptest.NewRecorder(), newPutRequest(dir, []byte("intruder")))
+		So(errors.Is(err, pydio.ErrLocked), ShouldBeTrue)
+		So(code, ShouldEqual, http.StatusLocked)
+
+		_, err = os.Stat(filepath.Join(dir, "file.txt"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Uploading to a locked node with the token", t, func() {
+		req := newPutRequest(dir, []byte("owner"))
+		req.Header.Set("If", "("+token+")")
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusTeapot)
+
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		So(string(content), ShouldEqual, "owner")
+	})
+
+	Convey("Uploading with an unknown token fails", t, func() {
+		req := newPutRequest(dir, []byte("unknown"))
+		req.Header.Set("If", "(<opaquelocktoken:unknown>)")
+
+		code, _ := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(code, ShouldEqual, http.StatusPreconditionFailed)
+	})
+
+	Convey("Unlocking the node", t, func() {
+		req := newPutRequest(dir, nil)
+		req.Method = MethodUnlock
+
+		code, _ := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		req.Header.Set("Lock-Token", token)
+
+		w := httptest.NewRecorder()
+
+		code, err := h.ServeHTTP(w, req)
+		So(err, ShouldBeNil)
+		So(w.Code, ShouldEqual, http.StatusNoContent)
+
+		code, err = h.ServeHTTP(httptest.NewRecorder(), newPutRequest(dir, []byte("anyone")))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusTeapot)
+	})
+
+	Convey("A file is not replaced once its lock is lost to another instance", t, func() {
+		pydio.SetLockManager(&lostLockManager{pydio.NewMemoryLockManager()})
+		defer pydio.SetLockManager(pydio.NewMemoryLockManager())
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), newPutRequest(dir, []byte("too late")))
+		So(err, ShouldEqual, pydio.ErrLockNotFound)
+		So(code, ShouldEqual, http.StatusPreconditionFailed)
+
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		So(string(content), ShouldEqual, "anyone")
+
+		files, _ := ioutil.ReadDir(dir)
+		So(files, ShouldHaveLength, 1)
+	})
+}
+
+// lostLockManager loses the locks it grants, as when another instance
+// takes the node first
+type lostLockManager struct {
+	*pydio.MemoryLockManager
+}
+
+func (m *lostLockManager) Check(node *pydio.Node, token string) error {
+	return pydio.ErrLockNotFound
+}
+
+func TestLockMiddlewares(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "pydioupload")
+	defer os.RemoveAll(dir)
+
+	pydio.SetLockManager(pydio.NewMemoryLockManager())
+
+	h, err := newChainedUploadHandler(fmt.Sprintf(`pydioupload /io {
+		pre {
+			type testcontext
+			dir %s
+			out node
+		}
+		pre {
+			type testcontext
+			dir %s
+			out options
+		}
+	}`, dir, dir))
+
+	Convey("Locking and unlocking a node through the middlewares", t, func() {
+		So(err, ShouldBeNil)
+
+		req := httptest.NewRequest(MethodLock, "/io/my-files/locked.txt", nil)
+
+		w := httptest.NewRecorder()
+
+		code, err := h.ServeHTTP(w, req)
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusOK)
+
+		token := w.Header().Get("Lock-Token")
+		So(token, ShouldStartWith, "<opaquelocktoken:")
+
+		Convey("the token does not allow writing to another node", func() {
+			req := httptest.NewRequest("PUT", "/io/my-files/other.txt", strings.NewReader("other"))
+			req.Header.Set("If", "("+token+")")
+
+			code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+			So(err, ShouldEqual, pydio.ErrLockNotFound)
+			So(code, ShouldEqual, http.StatusPreconditionFailed)
+
+			_, err = os.Stat(filepath.Join(dir, "other.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		req = httptest.NewRequest(MethodUnlock, "/io/my-files/locked.txt", nil)
+		req.Header.Set("Lock-Token", token)
+
+		w = httptest.NewRecorder()
+
+		code, err = h.ServeHTTP(w, req)
+		So(err, ShouldBeNil)
+		So(w.Code, ShouldEqual, http.StatusNoContent)
+	})
+}
@@ -0,0 +1,155 @@
+// Package pydioupload contains the logic for the pydioupload caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydioupload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydio "github.com/pydio/pydio-booster/io"
+	"github.com/pydio/pydio-booster/io/localio"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newPutRequest(dir string, body []byte) *http.Request {
+	req := httptest.NewRequest("PUT", "/io/my-files/file.txt", bytes.NewReader(body))
+
+	options := &pydio.Options{
+		Path:        "/file.txt",
+		FileOptions: pydio.FileOptions{Type: "fs", Path: dir},
+	}
+
+	ctx := pydhttp.NewContext(req.Context(), "node", pydio.NewNode("my-files", "file.txt"))
+	ctx = pydhttp.NewContext(ctx, "options", options)
+
+	return req.WithContext(ctx)
+}
+
+func TestPut(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "pydioupload")
+	defer os.RemoveAll(dir)
+
+	h, _ := newLimitedUploadHandler(`pydioupload /io`)
+
+	var tag string
+
+	Convey("Uploading a raw body", t, func() {
+		w := httptest.NewRecorder()
+
+		code, err := h.ServeHTTP(w, newPutRequest(dir, []byte("first version")))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusTeapot)
+
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		So(string(content), ShouldEqual, "first version")
+
+		tag = w.Header().Get("ETag")
+		So(tag, ShouldNotBeEmpty)
+	})
+
+	Convey("Uploading a gzipped body matching the current version", t, func() {
+		var body bytes.Buffer
+		gz := gzip.NewWriter(&body)
+		gz.Write([]byte("second"))
+		gz.Close()
+
+		req := newPutRequest(dir, body.Bytes())
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("If-Match", tag)
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusTeapot)
+
+		// The previous content is replaced, not overwritten
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		So(string(content), ShouldEqual, "second")
+	})
+
+	Convey("Uploading with an outdated ETag fails", t, func() {
+		req := newPutRequest(dir, []byte("lost update"))
+		req.Header.Set("If-Match", tag)
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusPreconditionFailed)
+
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		So(string(content), ShouldEqual, "second")
+	})
+
+	Convey("Uploading a body shorter than announced fails", t, func() {
+		req := newPutRequest(dir, []byte("short"))
+		req.ContentLength = 100
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		// The current version is kept, and nothing is left behind
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		So(string(content), ShouldEqual, "second")
+
+		files, _ := ioutil.ReadDir(dir)
+		So(files, ShouldHaveLength, 1)
+	})
+
+	Convey("Uploading a body that is not gzipped as announced is a bad request", t, func() {
+		req := newPutRequest(dir, []byte("not gzipped"))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		So(string(content), ShouldEqual, "second")
+	})
+
+	Convey("A preflight request is left to the next handlers", t, func() {
+		code, err := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("OPTIONS", "/io/my-files/file.txt", nil))
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, http.StatusTeapot)
+	})
+
+	Convey("Uploading outside of the repository is forbidden", t, func() {
+		req := newPutRequest(dir, []byte("escape"))
+
+		options, _ := pydhttp.OptionsFromContext(req.Context())
+		options.Path = "/../escape.txt"
+
+		code, err := h.ServeHTTP(httptest.NewRecorder(), req)
+		So(err, ShouldEqual, localio.ErrOutsideRoot)
+		So(code, ShouldEqual, http.StatusForbidden)
+
+		_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escape.txt"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
@@ -100,7 +100,9 @@ func parse(c *caddy.Controller) (rules []Rule, middlewareRules map[string][]pydi
 		}
 
 		if c.NextBlock() {
-			middlewareRules, _ = pydiomiddleware.Parse(c, rule.Path, "pre", "post")
+			if middlewareRules, err = pydiomiddleware.ParseWithDirectives(c, rule.Path, rule.Limits.parseLimit, "pre", "post"); err != nil {
+				return
+			}
 		}
 
 		rules = append(rules, rule)
@@ -25,21 +25,16 @@
 package pydiows
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/xml"
 	"io"
+	"math"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
-
-	"golang.org/x/net/context"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/mholt/caddy/caddyhttp/httpserver"
 	pydhttp "github.com/pydio/pydio-booster/http"
-	"github.com/pydio/pydio-booster/io"
 	"github.com/pydio/pydio-booster/server/middleware/pydiomiddleware"
 	pydiows "github.com/pydio/pydio-booster/websocket"
 )
@@ -53,9 +48,6 @@ const (
 
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer.
-	maxMessageSize = 1024 * 1024 * 10 // 10 MB default.
 )
 
 type (
@@ -70,11 +62,14 @@ type (
 	// endpoint which may serve multiple websocket connections.
 	Config struct {
 		Path string
-	}
 
-	// UserResponse from the server
-	UserResponse struct {
-		User pydio.User `xml:"user"`
+		// Compression negotiates permessage-deflate with the clients, at
+		// the compression level if it is not zero
+		Compression      bool
+		CompressionLevel int
+
+		// ReadLimit is the size of the largest message read from a client
+		ReadLimit int64
 	}
 )
 
@@ -84,10 +79,21 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error)
 	for _, sockconfig := range h.Sockets {
 		if httpserver.Path(r.URL.Path).Matches(sockconfig.Path) {
 
-			res := errHandle(r, handle(w, r, &sockconfig))
+			r = pydhttp.WithRequestID(w, r)
+
+			f := handle(w, r, &sockconfig)
+			if isPresence(r, &sockconfig) {
+				f = handlePresence(w, r)
+			}
+
+			res := errHandle(r, f)
 
 			if res.Err != nil {
-				logger.Errorln("PydioWS returns an error : ", res.Err)
+				pydhttp.RequestLogger(r.Context(), logger).Errorln("PydioWS returns an error : ", res.Err)
+
+				if res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusBadRequest {
+					return res.StatusCode, res.Err
+				}
 
 				return http.StatusUnauthorized, res.Err
 			}
@@ -129,26 +135,29 @@ func errHandle(r *http.Request, f func() *pydhttp.Status) *pydhttp.Status {
 func handle(w http.ResponseWriter, r *http.Request, config *Config) func() *pydhttp.Status {
 
 	return func() *pydhttp.Status {
+		logger := pydhttp.RequestLogger(r.Context(), logger)
+
 		logger.Infoln("PydioWS : handler START")
 
 		var conn *websocket.Conn
 		var err error
 
 		upgrader := websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin:     func(r *http.Request) bool { return true },
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			CheckOrigin:       func(r *http.Request) bool { return true },
+			Subprotocols:      pydiows.Protocols,
+			EnableCompression: config.Compression,
 		}
 
 		ctx := r.Context()
 
-		// Retrieving the node
+		// Retrieving the user
 		logger.Debugln("PydioWS : get context user")
-		userResponse := UserResponse{}
-		if err = getValue(ctx, "user", &userResponse); err != nil {
+		user, err := pydhttp.UserFromContext(ctx)
+		if err != nil {
 			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
 		}
-		user := userResponse.User
 		logger.Debugln("PydioWS : got context user ", user)
 
 		logger.Debugln("PydioWS : Upgrader")
@@ -159,33 +168,56 @@ func handle(w http.ResponseWriter, r *http.Request, config *Config) func() *pydh
 		}
 
 		defer conn.Close()
-		logger.Infoln("PydioWS : Upgraded")
+		logger.Infoln("PydioWS : Upgraded with protocol ", conn.Subprotocol())
 
-		// Request Read / Writer
-		reqr, reqw := io.Pipe()
+		if config.Compression && config.CompressionLevel != 0 {
+			if err := conn.SetCompressionLevel(config.CompressionLevel); err != nil {
+				return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+			}
+		}
+
+		// Request Read / Writer, the messages being framed with their length
+		pr, pw := io.Pipe()
+		reqr, reqw := pydiows.NewFrameReader(pr, config.ReadLimit), pydiows.NewFrameWriter(pw)
 
-		// Response Read / Writer
-		respr, respw := io.Pipe()
+		// Response Read / Writer, without limit for the large diffs
+		pr, pw = io.Pipe()
+		respr, respw := pydiows.NewFrameReader(pr, math.MaxUint32), pydiows.NewFrameWriter(pw)
 		defer respw.Close()
 
 		// Creating Websocket Connection
-		connection, err := pydiows.NewConnection(&user, reqr, respw)
+		connection, err := pydiows.NewConnectionWithProtocol(user, conn.Subprotocol(), reqr, respw)
 		if err != nil {
 			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
 		}
 
 		done := make(chan struct{})
 		go pumpStdout(conn, respr, done)
-		pumpStdin(conn, reqw)
+
+		stdinDone := make(chan struct{})
+		go func() {
+			pumpStdin(conn, reqw, config.ReadLimit)
+			close(stdinDone)
+		}()
+
+		// The client goes away, or the connection stops. On shutdown, the
+		// client receives a close frame from pumpStdout
+		select {
+		case <-stdinDone:
+		case err := <-connection.ExitChan:
+			if err != nil {
+				logger.Errorln("PydioWS : connection stopped ", err)
+			}
+		}
 
 		reqw.Close() // close stdin to end the process
+		connection.Close()
 
 		logger.Debugln("Closed the websocket")
 
 		select {
-		case <-connection.ExitChan:
+		case <-done:
 		case <-time.After(time.Second):
-			<-done
 		}
 
 		logger.Infoln("PydioWS : handler END")
@@ -194,36 +226,12 @@ func handle(w http.ResponseWriter, r *http.Request, config *Config) func() *pydh
 	}
 }
 
-// asynchronously retrieve values sitting in the context
-func getValue(ctx context.Context, key string, value interface{}) error {
-
-	// var node *pydio.Node
-	var buf bytes.Buffer
-
-	if err := pydhttp.FromContext(ctx, key, &buf); err != nil {
-		return err
-	}
-
-	data := buf.String()
-	if unquoted, err := strconv.Unquote(strings.Trim(data, "\n")); err == nil {
-		data = unquoted
-	}
-
-	dec := xml.NewDecoder(strings.NewReader(data))
-	if err := dec.Decode(&value); err != nil {
-		logger.Errorf("value for %s : %v", key, err)
-		return err
-	}
-
-	return nil
-}
-
 // pumpStdin handles reading data from the websocket connection and writing
-// it to stdin of the process.
-func pumpStdin(conn *websocket.Conn, stdin io.WriteCloser) {
+// it to stdin of the process, a message at a time.
+func pumpStdin(conn *websocket.Conn, stdin io.WriteCloser, limit int64) {
 	// Setup our connection's websocket ping/pong handlers from our const values.
 	defer conn.Close()
-	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadLimit(limit)
 	conn.SetReadDeadline(time.Now().Add(pongWait))
 	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
@@ -231,7 +239,6 @@ func pumpStdin(conn *websocket.Conn, stdin io.WriteCloser) {
 		if err != nil {
 			break
 		}
-		message = append(message, '\n')
 		if _, err := stdin.Write(message); err != nil {
 			break
 		}
@@ -239,24 +246,37 @@ func pumpStdin(conn *websocket.Conn, stdin io.WriteCloser) {
 }
 
 // pumpStdout handles reading data from stdout of the process and writing
-// it to websocket connection.
-func pumpStdout(conn *websocket.Conn, stdout io.Reader, done chan struct{}) {
+// it to websocket connection. Each message is sent whole, as a binary
+// message if it is not valid UTF-8.
+func pumpStdout(conn *websocket.Conn, stdout *pydiows.FrameReader, done chan struct{}) {
 	go pinger(conn, done)
 	defer func() {
 		conn.Close()
 		close(done) // make sure to close the pinger when we are done.
 	}()
 
-	s := bufio.NewScanner(stdout)
-	for s.Scan() {
+	for {
+		message, err := stdout.ReadFrame()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, err.Error()), time.Time{})
+			return
+		}
+
+		message = bytes.TrimSpace(message)
+
+		messageType := websocket.TextMessage
+		if !utf8.Valid(message) {
+			messageType = websocket.BinaryMessage
+		}
+
 		conn.SetWriteDeadline(time.Now().Add(writeWait))
-		if err := conn.WriteMessage(websocket.TextMessage, bytes.TrimSpace(s.Bytes())); err != nil {
-			break
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			return
 		}
 	}
-	if s.Err() != nil {
-		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, s.Err().Error()), time.Time{})
-	}
 }
 
 // pinger simulates the websocket to keep it alive with ping messages.
@@ -0,0 +1,155 @@
+// Package pydiotransfer contains the logic for the pydiotransfer caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiows
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/mholt/caddy"
+	pydiows "github.com/pydio/pydio-booster/websocket"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serve the websocket connections with fn, the client being dialed with compression
+func serve(fn func(conn *websocket.Conn)) (*websocket.Conn, *http.Response, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{EnableCompression: true}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		fn(conn)
+	}))
+
+	dialer := websocket.Dialer{EnableCompression: true}
+
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	So(err, ShouldBeNil)
+
+	return conn, resp, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestParse(t *testing.T) {
+
+	Convey("Parsing the websocket options", t, func() {
+		websocks, _, err := webSocketParse(caddy.NewTestController("http", `pydiows /ws {
+			compression 6
+			read_limit 1MB
+		}`))
+		So(err, ShouldBeNil)
+		So(websocks, ShouldResemble, []Config{{Path: "/ws", Compression: true, CompressionLevel: 6, ReadLimit: 1 << 20}})
+
+		websocks, _, err = webSocketParse(caddy.NewTestController("http", `pydiows /ws`))
+		So(err, ShouldBeNil)
+		So(websocks, ShouldResemble, []Config{{Path: "/ws", ReadLimit: pydiows.DefaultReadLimit}})
+
+		_, _, err = webSocketParse(caddy.NewTestController("http", `pydiows /ws {
+			compression 12
+		}`))
+		So(err, ShouldNotBeNil)
+
+		_, _, err = webSocketParse(caddy.NewTestController("http", `pydiows /ws {
+			read_limit lots
+		}`))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPumps(t *testing.T) {
+
+	Convey("Sending large messages whole", t, func() {
+		large := bytes.Repeat([]byte("<node path=\"/a\"/>\n"), 10000)
+		invalid := []byte{0xff, 0xfe, 0xfd}
+
+		conn, resp, stop := serve(func(conn *websocket.Conn) {
+			pr, pw := io.Pipe()
+			out := pydiows.NewFrameWriter(pw)
+
+			go func() {
+				out.Write(large)
+				out.Write(invalid)
+				out.Close()
+			}()
+
+			pumpStdout(conn, pydiows.NewFrameReader(pr, math.MaxUint32), make(chan struct{}))
+		})
+		defer stop()
+
+		So(resp.Header.Get("Sec-Websocket-Extensions"), ShouldContainSubstring, "permessage-deflate")
+
+		messageType, message, err := conn.ReadMessage()
+		So(err, ShouldBeNil)
+		So(messageType, ShouldEqual, websocket.TextMessage)
+		So(message, ShouldResemble, bytes.TrimSpace(large))
+
+		messageType, message, err = conn.ReadMessage()
+		So(err, ShouldBeNil)
+		So(messageType, ShouldEqual, websocket.BinaryMessage)
+		So(message, ShouldResemble, invalid)
+	})
+
+	Convey("Reading the messages of the client up to the limit", t, func() {
+		received := make(chan []byte, 10)
+
+		conn, _, stop := serve(func(conn *websocket.Conn) {
+			pr, pw := io.Pipe()
+			in := pydiows.NewFrameReader(pr, 1024)
+
+			go func() {
+				defer close(received)
+
+				for {
+					b, err := in.ReadFrame()
+					if err != nil {
+						return
+					}
+
+					received <- b
+				}
+			}()
+
+			pumpStdin(conn, pydiows.NewFrameWriter(pw), 1024)
+			pw.Close()
+		})
+		defer stop()
+
+		So(conn.WriteMessage(websocket.TextMessage, []byte("{\n\"type\": \"ping\"\n}")), ShouldBeNil)
+		So(conn.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte("a"), 2048)), ShouldBeNil)
+
+		So(string(<-received), ShouldEqual, "{\n\"type\": \"ping\"\n}")
+
+		_, ok := <-received
+		So(ok, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,85 @@
+// Package pydiows contains the logic for the pydiows caddy directive
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package pydiows
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	pydhttp "github.com/pydio/pydio-booster/http"
+	pydiows "github.com/pydio/pydio-booster/websocket"
+)
+
+var (
+	// ErrMissingRepo is returned when the presence query has no repository
+	ErrMissingRepo = errors.New("No repository in the query")
+
+	// ErrForbidden is returned when the repository is not readable by the user
+	ErrForbidden = errors.New("Repository is not readable by the user")
+)
+
+// PresenceResponse structure
+type PresenceResponse struct {
+	Repo  string             `json:"repo"`
+	Users []pydiows.Presence `json:"users"`
+}
+
+// isPresence query of the users connected to a repository, sent to the
+// presence path under the websocket one
+func isPresence(r *http.Request, config *Config) bool {
+	return r.Method == http.MethodGet &&
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		path.Clean(r.URL.Path) == path.Join(config.Path, "presence")
+}
+
+// handlePresence answers the users connected to the repository of the query
+func handlePresence(w http.ResponseWriter, r *http.Request) func() *pydhttp.Status {
+
+	return func() *pydhttp.Status {
+		ctx := r.Context()
+
+		user, err := pydhttp.UserFromContext(ctx)
+		if err != nil {
+			return pydhttp.NewStatusErr(http.StatusInternalServerError, err)
+		}
+
+		repo := r.URL.Query().Get("repo")
+		if repo == "" {
+			return pydhttp.NewStatusErr(http.StatusBadRequest, ErrMissingRepo)
+		}
+
+		if !user.GetRepo(repo).IsReadable() {
+			return pydhttp.NewStatusErr(http.StatusForbidden, ErrForbidden)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(&PresenceResponse{
+			Repo:  repo,
+			Users: pydiows.DefaultRegistry().Presence(repo),
+		})
+
+		return pydhttp.NewStatusOK(r, ctx)
+	}
+}
@@ -21,11 +21,17 @@
 package pydiows
 
 import (
+	"compress/flate"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/mholt/caddy"
 	"github.com/mholt/caddy/caddyhttp/httpserver"
 	"github.com/pydio/pydio-booster/server/middleware/pydiomiddleware"
 
 	pydiolog "github.com/pydio/pydio-booster/log"
+	pydiows "github.com/pydio/pydio-booster/websocket"
 	pydioworker "github.com/pydio/pydio-booster/worker"
 )
 
@@ -58,6 +64,12 @@ func setup(c *caddy.Controller) error {
 	dispatcher := pydioworker.NewDispatcher(900)
 	dispatcher.Run()
 
+	// Telling the clients to reconnect elsewhere when booster stops
+	c.OnFinalShutdown(func() error {
+		pydiows.DefaultRegistry().CloseAll()
+		return nil
+	})
+
 	// Pre Middlewares
 	cfg.AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
 		return &pydiomiddleware.Handler{
@@ -89,14 +101,88 @@ func webSocketParse(c *caddy.Controller) (websocks []Config, middlewareRules map
 
 		path = c.Val()
 
-		websocks = append(websocks, Config{
-			Path: path,
-		})
+		config := Config{
+			Path:      path,
+			ReadLimit: pydiows.DefaultReadLimit,
+		}
 
 		if c.NextBlock() {
-			middlewareRules, _ = pydiomiddleware.Parse(c, path, "pre")
+			if middlewareRules, err = pydiomiddleware.ParseWithDirectives(c, path, config.parseDirective, "pre"); err != nil {
+				return websocks, nil, err
+			}
 		}
+
+		websocks = append(websocks, config)
 	}
 
 	return websocks, middlewareRules, nil
 }
+
+// parseDirective of the websocket block
+func (config *Config) parseDirective(c *caddy.Controller) error {
+	switch c.Val() {
+	case "compression":
+		args := c.RemainingArgs()
+		if len(args) > 1 {
+			return c.ArgErr()
+		}
+
+		config.Compression = true
+
+		if len(args) == 1 {
+			level, err := strconv.Atoi(args[0])
+			if err != nil || level < flate.HuffmanOnly || level > flate.BestCompression {
+				return c.Errf("Invalid compression level '%s'", args[0])
+			}
+
+			config.CompressionLevel = level
+		}
+	case "read_limit":
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+
+		limit, err := parseSize(args[0])
+		if err != nil || limit <= 0 {
+			return c.Errf("Invalid read limit '%s'", args[0])
+		}
+
+		config.ReadLimit = limit
+	default:
+		return c.Errf("Unknown property '%s'", c.Val())
+	}
+
+	return nil
+}
+
+// parseSize in bytes, with an optional KB, MB or GB unit
+func parseSize(str string) (int64, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(str, unit.suffix) {
+			factor = unit.factor
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("Invalid size %s", str)
+	}
+
+	return size * factor, nil
+}
@@ -0,0 +1,221 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/nu7hatch/gouuid"
+	"github.com/pydio/pydio-booster/com"
+)
+
+// DefaultBacklogSize is the number of events kept per repository
+const DefaultBacklogSize = 1000
+
+var (
+	backlog   *Backlog
+	backlogMu sync.RWMutex
+)
+
+// Event is an instant message numbered in the order it was received.
+// The ids are shared by all the repositories, so that a client needs a
+// single last event id to resume all its subscriptions
+type Event struct {
+	ID uint64
+
+	PydioInstantMessage
+
+	msgID nsq.MessageID
+}
+
+// Backlog keeps the last events of each repository, so that the clients
+// reconnecting can receive the events they missed
+type Backlog struct {
+	size int
+
+	mu       sync.Mutex
+	last     uint64
+	repos    map[string]*repoLog
+	messages map[nsq.MessageID]*Event
+
+	consumer *com.Consumer
+}
+
+// repoLog of the events of a repository, the oldest first
+type repoLog struct {
+	events []*Event
+
+	// Id of the last event dropped from the log
+	dropped uint64
+}
+
+// NewBacklog keeping size events per repository
+func NewBacklog(size int) *Backlog {
+	if size <= 0 {
+		size = DefaultBacklogSize
+	}
+
+	return &Backlog{
+		size:     size,
+		repos:    make(map[string]*repoLog),
+		messages: make(map[nsq.MessageID]*Event),
+	}
+}
+
+// SetBacklog used by the new connections. A nil backlog disables the replays
+func SetBacklog(b *Backlog) {
+	backlogMu.Lock()
+	defer backlogMu.Unlock()
+
+	backlog = b
+}
+
+// DefaultBacklog used by the connections, nil if there is none
+func DefaultBacklog() *Backlog {
+	backlogMu.RLock()
+	defer backlogMu.RUnlock()
+
+	return backlog
+}
+
+// Start recording the instant messages. The NSQ com must be running
+func (b *Backlog) Start() error {
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	c, err := com.NewConsumer("im", u4.String()+"#ephemeral")
+	if err != nil {
+		return err
+	}
+
+	c.AddHandler(func(m *nsq.Message) error {
+		var pm PydioInstantMessage
+
+		if err := json.Unmarshal(m.Body, &pm); err != nil {
+			return err
+		}
+
+		b.Add(m.ID, pm)
+
+		return nil
+	})
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	b.consumer = c
+
+	return nil
+}
+
+// Stop recording the instant messages
+func (b *Backlog) Stop() {
+	if b.consumer != nil {
+		b.consumer.Stop()
+	}
+}
+
+// Add the instant message to the log of its repository. Every channel of
+// the topic receives the same message, so adding it again returns the
+// event that was first recorded
+func (b *Backlog) Add(msgID nsq.MessageID, pm PydioInstantMessage) *Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if event, ok := b.messages[msgID]; ok {
+		return event
+	}
+
+	b.last++
+
+	event := &Event{
+		ID:                  b.last,
+		PydioInstantMessage: pm,
+		msgID:               msgID,
+	}
+
+	history, ok := b.repos[pm.RepoID]
+	if !ok {
+		history = &repoLog{}
+		b.repos[pm.RepoID] = history
+	}
+
+	if len(history.events) >= b.size {
+		oldest := history.events[0]
+
+		history.dropped = oldest.ID
+		history.events = append(history.events[:0], history.events[1:]...)
+
+		delete(b.messages, oldest.msgID)
+	}
+
+	history.events = append(history.events, event)
+	b.messages[msgID] = event
+
+	return event
+}
+
+// Last id given to an event
+func (b *Backlog) Last() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.last
+}
+
+// Since returns the events of the repositories received after the last
+// event id, the oldest first. The repositories whose events after the
+// last one were dropped, or that were never seen by this backlog, are
+// returned in resync as the client must reload them
+func (b *Backlog) Since(last uint64, repos ...string) (events []*Event, resync []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, repo := range repos {
+		if last > b.last {
+			resync = append(resync, repo)
+			continue
+		}
+
+		history, ok := b.repos[repo]
+		if !ok {
+			continue
+		}
+
+		if history.dropped > last {
+			resync = append(resync, repo)
+			continue
+		}
+
+		i := sort.Search(len(history.events), func(i int) bool { return history.events[i].ID > last })
+		events = append(events, history.events[i:]...)
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return
+}
@@ -0,0 +1,67 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBacklog(t *testing.T) {
+
+	Convey("Events are numbered once for all the channels", t, func() {
+		b := NewBacklog(10)
+
+		first := b.Add(nsq.MessageID{'a'}, PydioInstantMessage{RepoID: "test"})
+		second := b.Add(nsq.MessageID{'b'}, PydioInstantMessage{RepoID: "other"})
+
+		So(first.ID, ShouldEqual, 1)
+		So(second.ID, ShouldEqual, 2)
+		So(b.Add(nsq.MessageID{'a'}, PydioInstantMessage{RepoID: "test"}), ShouldEqual, first)
+		So(b.Last(), ShouldEqual, 2)
+	})
+
+	Convey("Events are returned since the last event id", t, func() {
+		b := NewBacklog(2)
+
+		for i, repo := range []string{"test", "other", "test", "test", "other"} {
+			b.Add(nsq.MessageID{byte(i)}, PydioInstantMessage{RepoID: repo})
+		}
+
+		events, resync := b.Since(2, "test", "other", "unknown")
+		So(resync, ShouldBeEmpty)
+		So(events, ShouldHaveLength, 3)
+		So(events[0].ID, ShouldEqual, 3)
+		So(events[1].ID, ShouldEqual, 4)
+		So(events[2].ID, ShouldEqual, 5)
+
+		// Event 1 of test was dropped
+		events, resync = b.Since(0, "test", "other")
+		So(resync, ShouldResemble, []string{"test"})
+		So(events, ShouldHaveLength, 2)
+
+		// Ids from another backlog
+		_, resync = b.Since(42, "test")
+		So(resync, ShouldResemble, []string{"test"})
+	})
+}
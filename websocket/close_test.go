@@ -0,0 +1,127 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+
+	pydio "github.com/pydio/pydio-booster/io"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClose(t *testing.T) {
+
+	user := &pydio.User{ID: "closing", GroupPath: "/team", Repos: []pydio.Repo{{ID: "closing"}}}
+
+	Convey("Closing a connection", t, func() {
+		reqr, reqw := io.Pipe()
+		respr, respw := io.Pipe()
+		defer reqw.Close()
+
+		go io.Copy(ioutil.Discard, respr)
+
+		c, err := NewConnection(user, reqr, respw)
+		So(err, ShouldBeNil)
+
+		c.Subscribe("closing")
+		So(DefaultRegistry().Presence("closing"), ShouldHaveLength, 1)
+
+		So(c.Close(), ShouldBeNil)
+		So(c.Close(), ShouldBeNil)
+
+		_, ok := <-c.ExitChan
+		So(ok, ShouldBeFalse)
+
+		So(DefaultRegistry().Presence("closing"), ShouldBeEmpty)
+
+		_, err = reqw.Write([]byte("ping\n"))
+		So(err, ShouldEqual, io.ErrClosedPipe)
+	})
+
+	Convey("Shutting down the server", t, func() {
+		reqr, reqw := io.Pipe()
+		respr, respw := io.Pipe()
+		defer reqw.Close()
+
+		c, err := NewConnectionWithProtocol(user, ProtocolSSE, reqr, respw)
+		So(err, ShouldBeNil)
+
+		scanner := bufio.NewScanner(respr)
+
+		go DefaultRegistry().CloseAll()
+
+		So(scanner.Scan(), ShouldBeTrue)
+		So(scanner.Text(), ShouldStartWith, "data: ")
+
+		var frame Frame
+		So(json.Unmarshal(scanner.Bytes()[len("data: "):], &frame), ShouldBeNil)
+		So(frame.Type, ShouldEqual, FrameClose)
+		So(frame.Error, ShouldEqual, ErrShutdown.Error())
+
+		for scanner.Scan() {
+		}
+		So(scanner.Err(), ShouldEqual, ErrShutdown)
+
+		for range c.ExitChan {
+		}
+	})
+
+	Convey("Connecting and disconnecting repeatedly", t, func() {
+		goroutines := runtime.NumGoroutine()
+
+		for i := 0; i < 50; i++ {
+			reqr, reqw := io.Pipe()
+			respr, respw := io.Pipe()
+
+			go io.Copy(ioutil.Discard, respr)
+
+			c, err := NewConnection(user, reqr, respw)
+			So(err, ShouldBeNil)
+
+			c.Subscribe("closing")
+
+			// Half of the clients go away, the others are closed by the server
+			if i%2 == 0 {
+				reqw.Close()
+			} else {
+				c.Close()
+			}
+
+			for range c.ExitChan {
+			}
+		}
+
+		So(DefaultRegistry().Presence("closing"), ShouldBeEmpty)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > goroutines+5 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		So(runtime.NumGoroutine(), ShouldBeLessThanOrEqualTo, goroutines+5)
+	})
+}
@@ -0,0 +1,120 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// DefaultReadLimit is the size of the largest message read from a client
+const DefaultReadLimit = 10 << 20
+
+// ErrFrameTooLarge is returned for a frame longer than the read limit
+var ErrFrameTooLarge = errors.New("Frame too large")
+
+// FrameWriter writes each message with its length, so that the reader gets
+// the messages whole, whatever their size and the newlines they contain
+type FrameWriter struct {
+	w io.WriteCloser
+}
+
+// FrameReader reads the messages written by a FrameWriter
+type FrameReader struct {
+	r     io.ReadCloser
+	limit int64
+}
+
+// NewFrameWriter writing the messages to w
+func NewFrameWriter(w io.WriteCloser) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+// Write the message, prefixed with its length, in a single write
+func (f *FrameWriter) Write(p []byte) (int, error) {
+	b := make([]byte, 4+len(p))
+	binary.BigEndian.PutUint32(b, uint32(len(p)))
+	copy(b[4:], p)
+
+	if _, err := f.w.Write(b); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close the underlying writer
+func (f *FrameWriter) Close() error {
+	return f.w.Close()
+}
+
+// CloseWithError the underlying writer, if it is a pipe
+func (f *FrameWriter) CloseWithError(err error) error {
+	if closer, ok := f.w.(interface {
+		CloseWithError(error) error
+	}); ok {
+		return closer.CloseWithError(err)
+	}
+
+	return f.w.Close()
+}
+
+// NewFrameReader reading the messages from r. Messages longer than the
+// limit are refused, DefaultReadLimit being used if it is not positive
+func NewFrameReader(r io.ReadCloser, limit int64) *FrameReader {
+	if limit <= 0 {
+		limit = DefaultReadLimit
+	}
+
+	return &FrameReader{r: r, limit: limit}
+}
+
+// ReadFrame returns the next message
+func (f *FrameReader) ReadFrame() ([]byte, error) {
+	var size [4]byte
+
+	if _, err := io.ReadFull(f.r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := int64(binary.BigEndian.Uint32(size[:]))
+	if n > f.limit {
+		return nil, ErrFrameTooLarge
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(f.r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Read the raw bytes of the underlying reader
+func (f *FrameReader) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+// Close the underlying reader
+func (f *FrameReader) Close() error {
+	return f.r.Close()
+}
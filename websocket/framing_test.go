@@ -0,0 +1,86 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFraming(t *testing.T) {
+
+	Convey("Reading the frames whole", t, func() {
+		pr, pw := io.Pipe()
+
+		w := NewFrameWriter(pw)
+		r := NewFrameReader(pr, 100*1024)
+
+		large := bytes.Repeat([]byte("line\n"), 20*1024)
+
+		go func() {
+			w.Write([]byte("first\nsecond"))
+			w.Write(large)
+			w.Write(make([]byte, 100*1024+1))
+		}()
+
+		b, err := r.ReadFrame()
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "first\nsecond")
+
+		b, err = r.ReadFrame()
+		So(err, ShouldBeNil)
+		So(b, ShouldResemble, large)
+
+		_, err = r.ReadFrame()
+		So(err, ShouldEqual, ErrFrameTooLarge)
+
+		So(w.CloseWithError(ErrShutdown), ShouldBeNil)
+		_, err = r.Read(make([]byte, 1))
+		So(err, ShouldEqual, ErrShutdown)
+	})
+
+	Convey("Handling the frames of a client", t, func() {
+		pr, pw := io.Pipe()
+		defer pw.Close()
+
+		respr, respw := io.Pipe()
+		defer respw.Close()
+
+		_, err := NewConnectionWithProtocol(fakeUser, ProtocolJSON, NewFrameReader(pr, 0), respw)
+		So(err, ShouldBeNil)
+
+		// A frame on several lines
+		go NewFrameWriter(pw).Write([]byte("{\n  \"type\": \"ping\",\n  \"id\": \"1\"\n}\n"))
+
+		scanner := bufio.NewScanner(respr)
+		So(scanner.Scan(), ShouldBeTrue)
+
+		var frame Frame
+		So(json.Unmarshal(scanner.Bytes(), &frame), ShouldBeNil)
+		So(frame.Type, ShouldEqual, FrameAck)
+		So(frame.ID, ShouldEqual, "1")
+	})
+}
@@ -0,0 +1,87 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	pydio "github.com/pydio/pydio-booster/io"
+)
+
+// prefixes of the paths in the repository, as keys of its normalization.
+// None is returned for the whole repository
+func prefixes(repo string, paths []string) []string {
+	n := pydio.GetNormalization(repo)
+
+	keys := make(map[string]struct{})
+
+	for _, p := range paths {
+		key := n.Key(path.Clean("/" + p))
+		if key == "/" {
+			return nil
+		}
+
+		keys[key] = struct{}{}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	res := make([]string, 0, len(keys))
+	for key := range keys {
+		res = append(res, key)
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// matches if one of the node paths is under a prefix, or is a parent of
+// one, whose changes also affect the nodes under it. A message without
+// node paths matches any prefix
+func matches(repo string, prefixes []string, nodePaths []string) bool {
+	if len(prefixes) == 0 || len(nodePaths) == 0 {
+		return true
+	}
+
+	n := pydio.GetNormalization(repo)
+
+	for _, p := range nodePaths {
+		key := n.Key(path.Clean("/" + p))
+
+		for _, prefix := range prefixes {
+			if isUnder(key, prefix) || isUnder(prefix, key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isUnder if p is the parent path or one of its children
+func isUnder(p string, parent string) bool {
+	return p == parent || parent == "/" || strings.HasPrefix(p, parent+"/")
+}
@@ -0,0 +1,108 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"testing"
+
+	pydio "github.com/pydio/pydio-booster/io"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPaths(t *testing.T) {
+
+	Convey("Normalizing the path prefixes", t, func() {
+		So(prefixes("test", nil), ShouldBeNil)
+		So(prefixes("test", []string{"docs/", "/photos/../docs", "/music"}), ShouldResemble, []string{"/docs", "/music"})
+		So(prefixes("test", []string{"/docs", "/"}), ShouldBeNil)
+
+		pydio.SetNormalization("folded", pydio.Normalization{FoldCase: true})
+		defer pydio.SetNormalization("folded", pydio.Normalization{})
+
+		So(prefixes("folded", []string{"/Docs"}), ShouldResemble, []string{"/docs"})
+		So(matches("folded", []string{"/docs"}, []string{"/DOCS/report.pdf"}), ShouldBeTrue)
+	})
+
+	Convey("Matching the node paths", t, func() {
+		docs := []string{"/docs"}
+
+		So(matches("test", nil, []string{"/photos/a.jpg"}), ShouldBeTrue)
+		So(matches("test", docs, nil), ShouldBeTrue)
+
+		So(matches("test", docs, []string{"/docs"}), ShouldBeTrue)
+		So(matches("test", docs, []string{"/docs/2017/report.pdf"}), ShouldBeTrue)
+		So(matches("test", docs, []string{"/"}), ShouldBeTrue)
+		So(matches("test", []string{"/docs/2017"}, []string{"/docs"}), ShouldBeTrue)
+
+		So(matches("test", docs, []string{"/documents/report.pdf"}), ShouldBeFalse)
+		So(matches("test", docs, []string{"/photos/a.jpg"}), ShouldBeFalse)
+
+		// A node moved into the folder
+		So(matches("test", docs, []string{"/photos/a.jpg", "/docs/a.jpg"}), ShouldBeTrue)
+	})
+
+	Convey("Forwarding the events of the subscribed paths", t, func() {
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		respr, respw := io.Pipe()
+		defer respw.Close()
+
+		connection, err := NewConnectionWithProtocol(fakeUser, ProtocolJSON, reqr, respw)
+		So(err, ShouldBeNil)
+
+		scanner := bufio.NewScanner(respr)
+		read := func() Frame {
+			var frame Frame
+			So(scanner.Scan(), ShouldBeTrue)
+			So(json.Unmarshal(scanner.Bytes(), &frame), ShouldBeNil)
+
+			return frame
+		}
+
+		go reqw.Write([]byte(`{"type":"subscribe","id":"1","repos":["test"],"paths":["/docs"]}` + "\n"))
+		So(read().Type, ShouldEqual, FrameAck)
+		So(connection.Paths("test"), ShouldResemble, []string{"/docs"})
+
+		outside := PydioInstantMessage{RepoID: "test", XMLContent: "outside", NodePaths: []string{"/photos/a.jpg"}}
+		inside := PydioInstantMessage{RepoID: "test", XMLContent: "inside", NodePaths: []string{"/docs/a.pdf"}}
+
+		So(connection.accepts(outside), ShouldBeFalse)
+		So(connection.accepts(inside), ShouldBeTrue)
+
+		go func() {
+			for _, pm := range []PydioInstantMessage{outside, inside} {
+				if connection.accepts(pm) {
+					connection.writeEvent(&Event{PydioInstantMessage: pm})
+				}
+			}
+		}()
+		So(read().Content, ShouldEqual, "inside")
+
+		// Subscribing again without paths follows the whole repository
+		connection.Subscribe("test")
+		So(connection.Paths("test"), ShouldBeNil)
+		So(connection.accepts(outside), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,314 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Actions of the presence frames
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+var registry = NewRegistry()
+
+// Presence of a user in a repository
+type Presence struct {
+	UserID      string    `json:"user_id"`
+	GroupPath   string    `json:"group_path,omitempty"`
+	Connections int       `json:"connections"`
+	Since       time.Time `json:"since"`
+}
+
+// Registry of the connections of this instance, tracking the presence of
+// the users in the repositories they are subscribed to. The subscribers of
+// a repository are told when a user joins it or leaves it
+type Registry struct {
+	mu          sync.Mutex
+	connections map[*Connection]struct{}
+
+	// Presences by repository then by user
+	presences map[string]map[string]*Presence
+
+	// Messages dropped or coalesced for the connections removed, and the
+	// slow clients disconnected
+	dropped      uint64
+	coalesced    uint64
+	disconnected uint64
+}
+
+// Metrics of the outbound queues of the connections. The counts include
+// the connections that were closed
+type Metrics struct {
+	Connections  int    `json:"connections"`
+	Queued       int    `json:"queued"`
+	MaxDepth     int    `json:"max_depth"`
+	Dropped      uint64 `json:"dropped"`
+	Coalesced    uint64 `json:"coalesced"`
+	Disconnected uint64 `json:"disconnected"`
+
+	Queues []ConnectionQueue `json:"queues,omitempty"`
+}
+
+// ConnectionQueue is the outbound queue of a connection
+type ConnectionQueue struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	Protocol string `json:"protocol"`
+
+	QueueStats
+}
+
+// NewRegistry without connections
+func NewRegistry() *Registry {
+	return &Registry{
+		connections: make(map[*Connection]struct{}),
+		presences:   make(map[string]map[string]*Presence),
+	}
+}
+
+// DefaultRegistry of the connections
+func DefaultRegistry() *Registry {
+	return registry
+}
+
+// Add the connection to the registry
+func (r *Registry) Add(c *Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.connections[c] = struct{}{}
+}
+
+// Remove the connection from the registry, leaving all its repositories
+func (r *Registry) Remove(c *Connection) {
+	r.leave(c, c.Repos())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.connections[c]; !ok {
+		return
+	}
+
+	delete(r.connections, c)
+
+	stats := c.queue.Stats()
+	r.dropped += stats.Dropped
+	r.coalesced += stats.Coalesced
+
+	if c.queue.isOverflowed() {
+		r.disconnected++
+	}
+}
+
+// CloseAll the connections, telling the clients that the server is shutting down
+func (r *Registry) CloseAll() {
+	r.mu.Lock()
+
+	connections := make([]*Connection, 0, len(r.connections))
+	for c := range r.connections {
+		connections = append(connections, c)
+	}
+
+	r.mu.Unlock()
+
+	for _, c := range connections {
+		c.stop(ErrShutdown)
+	}
+}
+
+// Len is the number of connections
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.connections)
+}
+
+// Metrics of the queues. The queue of each connection is listed with detail,
+// the deepest first
+func (r *Registry) Metrics(detail bool) Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := Metrics{
+		Connections:  len(r.connections),
+		Dropped:      r.dropped,
+		Coalesced:    r.coalesced,
+		Disconnected: r.disconnected,
+	}
+
+	for c := range r.connections {
+		stats := c.queue.Stats()
+
+		m.Queued += stats.Depth
+		m.Dropped += stats.Dropped
+		m.Coalesced += stats.Coalesced
+
+		if stats.Depth > m.MaxDepth {
+			m.MaxDepth = stats.Depth
+		}
+
+		if detail {
+			m.Queues = append(m.Queues, ConnectionQueue{
+				ID:         c.uniqueID,
+				UserID:     c.User.ID,
+				Protocol:   c.Protocol,
+				QueueStats: stats,
+			})
+		}
+	}
+
+	sort.Slice(m.Queues, func(i, j int) bool { return m.Queues[i].Depth > m.Queues[j].Depth })
+
+	return m
+}
+
+// Presence of the users in the repository, sorted by user id
+func (r *Registry) Presence(repo string) []Presence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.presence(repo)
+}
+
+// All the presences, by repository
+func (r *Registry) All() map[string][]Presence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make(map[string][]Presence, len(r.presences))
+	for repo := range r.presences {
+		all[repo] = r.presence(repo)
+	}
+
+	return all
+}
+
+func (r *Registry) presence(repo string) []Presence {
+	users := r.presences[repo]
+
+	list := make([]Presence, 0, len(users))
+	for _, p := range users {
+		list = append(list, *p)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+
+	return list
+}
+
+// join the repositories with the connection. The first connection of a
+// user in a repository makes the user join it
+func (r *Registry) join(c *Connection, repos []string) {
+	var events []Frame
+
+	r.mu.Lock()
+
+	for _, repo := range repos {
+		users, ok := r.presences[repo]
+		if !ok {
+			users = make(map[string]*Presence)
+			r.presences[repo] = users
+		}
+
+		if p, ok := users[c.User.ID]; ok {
+			p.Connections++
+			continue
+		}
+
+		p := &Presence{
+			UserID:      c.User.ID,
+			GroupPath:   c.User.GroupPath,
+			Connections: 1,
+			Since:       time.Now(),
+		}
+
+		users[c.User.ID] = p
+
+		events = append(events, Frame{Type: FramePresence, Action: PresenceJoin, Repo: repo, Users: []Presence{*p}})
+	}
+
+	r.mu.Unlock()
+
+	r.notify(c, events)
+}
+
+// leave the repositories with the connection. The last connection of a
+// user in a repository makes the user leave it
+func (r *Registry) leave(c *Connection, repos []string) {
+	var events []Frame
+
+	r.mu.Lock()
+
+	for _, repo := range repos {
+		p, ok := r.presences[repo][c.User.ID]
+		if !ok {
+			continue
+		}
+
+		if p.Connections--; p.Connections > 0 {
+			continue
+		}
+
+		delete(r.presences[repo], c.User.ID)
+		if len(r.presences[repo]) == 0 {
+			delete(r.presences, repo)
+		}
+
+		events = append(events, Frame{Type: FramePresence, Action: PresenceLeave, Repo: repo, Users: []Presence{*p}})
+	}
+
+	r.mu.Unlock()
+
+	r.notify(c, events)
+}
+
+// notify the other subscribers of the repositories of the events
+func (r *Registry) notify(from *Connection, events []Frame) {
+	if len(events) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+
+	connections := make([]*Connection, 0, len(r.connections))
+	for c := range r.connections {
+		if c != from && c.Protocol != ProtocolLegacy {
+			connections = append(connections, c)
+		}
+	}
+
+	r.mu.Unlock()
+
+	for _, event := range events {
+		for _, c := range connections {
+			if c.IsSubscribed(event.Repo) {
+				c.writeFrame(event)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"testing"
+
+	pydio "github.com/pydio/pydio-booster/io"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPresence(t *testing.T) {
+
+	alice := &pydio.User{ID: "alice", GroupPath: "/team", Repos: []pydio.Repo{{ID: "shared"}}}
+	bob := &pydio.User{ID: "bob", GroupPath: "/team", Repos: []pydio.Repo{{ID: "shared"}}}
+
+	newConnection := func(u *pydio.User, protocol string) (*Connection, *bufio.Scanner, func()) {
+		reqr, reqw := io.Pipe()
+		respr, respw := io.Pipe()
+
+		c, err := NewConnectionWithProtocol(u, protocol, reqr, respw)
+		So(err, ShouldBeNil)
+
+		return c, bufio.NewScanner(respr), func() {
+			DefaultRegistry().Remove(c)
+			reqw.Close()
+			respw.Close()
+		}
+	}
+
+	read := func(scanner *bufio.Scanner) Frame {
+		var frame Frame
+		So(scanner.Scan(), ShouldBeTrue)
+		So(json.Unmarshal(scanner.Bytes(), &frame), ShouldBeNil)
+
+		return frame
+	}
+
+	Convey("Users joining and leaving a repository", t, func() {
+		registry := DefaultRegistry()
+
+		watcher, scanner, closeWatcher := newConnection(alice, ProtocolJSON)
+		defer closeWatcher()
+
+		watcher.Subscribe("shared")
+		So(registry.Presence("shared"), ShouldHaveLength, 1)
+
+		first, _, closeFirst := newConnection(bob, ProtocolLegacy)
+		second, _, closeSecond := newConnection(bob, ProtocolLegacy)
+
+		go first.Subscribe("shared")
+
+		frame := read(scanner)
+		So(frame.Type, ShouldEqual, FramePresence)
+		So(frame.Action, ShouldEqual, PresenceJoin)
+		So(frame.Repo, ShouldEqual, "shared")
+		So(frame.Users[0].UserID, ShouldEqual, "bob")
+
+		// Another connection of a present user is not a join
+		second.Subscribe("shared")
+
+		presence := registry.Presence("shared")
+		So(presence, ShouldHaveLength, 2)
+		So(presence[0].UserID, ShouldEqual, "alice")
+		So(presence[1].UserID, ShouldEqual, "bob")
+		So(presence[1].Connections, ShouldEqual, 2)
+
+		closeFirst()
+		So(registry.Presence("shared")[1].Connections, ShouldEqual, 1)
+
+		go closeSecond()
+
+		frame = read(scanner)
+		So(frame.Action, ShouldEqual, PresenceLeave)
+		So(frame.Users[0].UserID, ShouldEqual, "bob")
+
+		So(registry.Presence("shared"), ShouldHaveLength, 1)
+	})
+}
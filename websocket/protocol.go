@@ -0,0 +1,303 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	pydio "github.com/pydio/pydio-booster/io"
+)
+
+const (
+	// ProtocolLegacy is the newline delimited text protocol, sending the
+	// raw XML content of the messages. It is used when no subprotocol is negotiated
+	ProtocolLegacy = ""
+
+	// ProtocolJSON is the Sec-WebSocket-Protocol of the JSON frames
+	ProtocolJSON = "pydio.json.v1"
+
+	// ProtocolSSE streams the JSON frames as server-sent events. The
+	// events carry their id, for the Last-Event-ID of the reconnections
+	ProtocolSSE = "sse"
+)
+
+// Types of the JSON frames
+const (
+	// Sent by the client
+	FrameSubscribe   = "subscribe"
+	FrameUnsubscribe = "unsubscribe"
+	FramePing        = "ping"
+	FramePublish     = "publish"
+
+	// Sent by the server
+	FrameAck      = "ack"
+	FrameError    = "error"
+	FrameEvent    = "event"
+	FrameResync   = "resync"
+	FramePresence = "presence"
+	FrameClose    = "close"
+)
+
+var (
+	// Protocols supported by the server, in order of preference
+	Protocols = []string{ProtocolJSON}
+
+	// ErrInvalidFrame is sent back for a frame that is not valid JSON
+	ErrInvalidFrame = errors.New("Invalid frame")
+
+	// ErrUnknownFrame is sent back for a frame of an unknown type
+	ErrUnknownFrame = errors.New("Unknown frame type")
+
+	// ErrMissingRepos is sent back for a subscription without repositories
+	ErrMissingRepos = errors.New("No repository in the frame")
+
+	// ErrInvalidEventID is sent back for a last event id that is not a number
+	ErrInvalidEventID = errors.New("Invalid last event id")
+)
+
+// Frame of the JSON protocol. The ID set by the client is sent back in
+// the ack or the error answering the frame. The paths of a subscription
+// restrict it to the events of the nodes under them. A client publishes
+// an event with its repository, paths and data. The ID of an event is its
+// id in the backlog, to be sent as the last event id of a subscription
+// when reconnecting
+type Frame struct {
+	Type        string          `json:"type"`
+	ID          string          `json:"id,omitempty"`
+	Repo        string          `json:"repo,omitempty"`
+	Repos       []string        `json:"repos,omitempty"`
+	Paths       []string        `json:"paths,omitempty"`
+	Refused     []string        `json:"refused,omitempty"`
+	LastEventID string          `json:"last_event_id,omitempty"`
+	Action      string          `json:"action,omitempty"`
+	Event       string          `json:"event,omitempty"`
+	Users       []Presence      `json:"users,omitempty"`
+	Content     string          `json:"content,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// handle a line received from the client
+func (c *Connection) handle(text string) {
+	if c.Protocol == ProtocolJSON {
+		c.handleFrame(text)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(text, "register:"):
+		// Legacy clients follow a single repository
+		c.UnsubscribeAll()
+		c.subscribe([]string{strings.TrimPrefix(text, "register:")}, nil)
+	case strings.HasPrefix(text, "subscribe:"):
+		c.subscribe(strings.Split(strings.TrimPrefix(text, "subscribe:"), ","), nil)
+	case strings.HasPrefix(text, "unsubscribe:"):
+		c.unsubscribe(strings.Split(strings.TrimPrefix(text, "unsubscribe:"), ","))
+	case strings.HasPrefix(text, "unregister"):
+		c.unsubscribe(nil)
+	}
+}
+
+// handleFrame received from a client of the JSON protocol
+func (c *Connection) handleFrame(text string) {
+	var frame Frame
+
+	if err := json.Unmarshal([]byte(text), &frame); err != nil {
+		c.writeFrame(Frame{Type: FrameError, Error: ErrInvalidFrame.Error()})
+		return
+	}
+
+	ack := Frame{Type: FrameAck, ID: frame.ID}
+
+	switch frame.Type {
+	case FrameSubscribe:
+		if len(frame.Repos) == 0 {
+			c.writeFrame(Frame{Type: FrameError, ID: frame.ID, Error: ErrMissingRepos.Error()})
+			return
+		}
+
+		var last uint64
+		if frame.LastEventID != "" {
+			var err error
+			if last, err = strconv.ParseUint(frame.LastEventID, 10, 64); err != nil {
+				c.writeFrame(Frame{Type: FrameError, ID: frame.ID, Error: ErrInvalidEventID.Error()})
+				return
+			}
+		}
+
+		ack.Refused = c.subscribe(frame.Repos, frame.Paths)
+		ack.Repos = c.Repos()
+
+		c.writeFrame(ack)
+
+		if frame.LastEventID != "" {
+			c.replay(frame.ID, last, subtract(frame.Repos, ack.Refused))
+		}
+
+		return
+	case FrameUnsubscribe:
+		c.unsubscribe(frame.Repos)
+	case FramePing:
+	case FramePublish:
+		if err := c.Publish(frame.Event, frame.Repo, frame.Paths, frame.Data); err != nil {
+			c.Logger.Errorln("Could not publish the event ", frame.Event, " ", err)
+			c.writeFrame(Frame{Type: FrameError, ID: frame.ID, Error: err.Error()})
+			return
+		}
+	default:
+		c.writeFrame(Frame{Type: FrameError, ID: frame.ID, Error: ErrUnknownFrame.Error()})
+		return
+	}
+
+	ack.Repos = c.Repos()
+
+	c.writeFrame(ack)
+}
+
+// replay the events of the repositories received after the last event id.
+// Live events are held until the replay is done, and the ones it already
+// sent are skipped
+func (c *Connection) replay(requestID string, last uint64, repos []string) {
+	b := DefaultBacklog()
+	if b == nil {
+		c.writeFrame(Frame{Type: FrameResync, ID: requestID, Repos: repos})
+		return
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	upTo := b.Last()
+
+	events, resync := b.Since(last, append(repos, "*")...)
+
+	for _, event := range events {
+		if event.ID > upTo {
+			upTo = event.ID
+		}
+
+		if c.accepts(event.PydioInstantMessage) {
+			c.send(event.RepoID, c.encodeEvent(event))
+		}
+	}
+
+	for _, repo := range append(repos, "*") {
+		c.replayed[repo] = upTo
+	}
+
+	if len(resync) > 0 {
+		c.send("", c.encodeFrame(Frame{Type: FrameResync, ID: requestID, Repos: resync}))
+	}
+}
+
+// Resume the subscriptions of a client reconnecting after the last event
+// id it received, by replaying the events it missed
+func (c *Connection) Resume(last uint64, repos ...string) {
+	c.replay("", last, repos)
+}
+
+// writeEvent in the protocol of the connection
+func (c *Connection) writeEvent(event *Event) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if event.ID != 0 && event.ID <= c.replayed[event.RepoID] {
+		return
+	}
+
+	c.send(event.RepoID, c.encodeEvent(event))
+}
+
+// writeFrame to the client
+func (c *Connection) writeFrame(frame Frame) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.send("", c.encodeFrame(frame))
+}
+
+// encodeEvent in the protocol of the connection. Paths are sent in the
+// unicode form of the repository
+func (c *Connection) encodeEvent(event *Event) []byte {
+	content := pydio.GetNormalization(event.RepoID).Path(event.XMLContent)
+
+	if c.Protocol == ProtocolLegacy {
+		return []byte(content + "\n")
+	}
+
+	frame := Frame{Type: FrameEvent, Repo: event.RepoID, Content: content}
+	if event.ID != 0 {
+		frame.ID = strconv.FormatUint(event.ID, 10)
+	}
+
+	if c.Protocol == ProtocolSSE && frame.ID != "" {
+		return append([]byte("id: "+frame.ID+"\n"), c.encodeFrame(frame)...)
+	}
+
+	return c.encodeFrame(frame)
+}
+
+// encodeFrame as a single line of JSON, or as the data of a server-sent
+// event. The XML content is left unescaped
+func (c *Connection) encodeFrame(frame Frame) []byte {
+	var buf bytes.Buffer
+
+	if c.Protocol == ProtocolSSE {
+		buf.WriteString("data: ")
+	}
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(frame); err != nil {
+		c.Logger.Errorln("Could not encode the frame ", err)
+		return nil
+	}
+
+	// The blank line ending the event
+	if c.Protocol == ProtocolSSE {
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+// subtract the ids of b from a
+func subtract(a []string, b []string) []string {
+	var res []string
+
+	for _, id := range a {
+		found := false
+		for _, other := range b {
+			found = found || id == other
+		}
+
+		if !found {
+			res = append(res, id)
+		}
+	}
+
+	return res
+}
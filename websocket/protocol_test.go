@@ -0,0 +1,151 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/nsqio/go-nsq"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJSONProtocol(t *testing.T) {
+
+	Convey("Exchanging JSON frames", t, func() {
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		respr, respw := io.Pipe()
+		defer respw.Close()
+
+		connection, err := NewConnectionWithProtocol(fakeUser, ProtocolJSON, reqr, respw)
+		So(err, ShouldBeNil)
+
+		scanner := bufio.NewScanner(respr)
+
+		send := func(text string) Frame {
+			go reqw.Write([]byte(text + "\n"))
+
+			var frame Frame
+			So(scanner.Scan(), ShouldBeTrue)
+			So(json.Unmarshal(scanner.Bytes(), &frame), ShouldBeNil)
+
+			return frame
+		}
+
+		frame := send(`{"type":"subscribe","id":"1","repos":["test","other","writeonly"]}`)
+		So(frame, ShouldResemble, Frame{Type: FrameAck, ID: "1", Repos: []string{"other", "test"}, Refused: []string{"writeonly"}})
+
+		frame = send(`{"type":"unsubscribe","id":"2","repos":["test"]}`)
+		So(frame, ShouldResemble, Frame{Type: FrameAck, ID: "2", Repos: []string{"other"}})
+		So(connection.Repos(), ShouldResemble, []string{"other"})
+
+		frame = send(`{"type":"ping","id":"3"}`)
+		So(frame.Type, ShouldEqual, FrameAck)
+		So(frame.ID, ShouldEqual, "3")
+
+		frame = send(`{"type":"subscribe","id":"4"}`)
+		So(frame, ShouldResemble, Frame{Type: FrameError, ID: "4", Error: ErrMissingRepos.Error()})
+
+		frame = send(`{"type":"register","id":"5"}`)
+		So(frame, ShouldResemble, Frame{Type: FrameError, ID: "5", Error: ErrUnknownFrame.Error()})
+
+		frame = send(`register:test`)
+		So(frame, ShouldResemble, Frame{Type: FrameError, Error: ErrInvalidFrame.Error()})
+		So(connection.Repos(), ShouldResemble, []string{"other"})
+	})
+
+	Convey("Events are written in the protocol of the connection", t, func() {
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		respr, respw := io.Pipe()
+		defer respw.Close()
+
+		connection, _ := NewConnectionWithProtocol(fakeUser, ProtocolJSON, reqr, respw)
+		legacy, _ := NewConnection(fakeUser, reqr, respw)
+
+		scanner := bufio.NewScanner(respr)
+
+		event := &Event{PydioInstantMessage: PydioInstantMessage{RepoID: "test", XMLContent: "<tree/>"}}
+
+		go connection.writeEvent(event)
+		So(scanner.Scan(), ShouldBeTrue)
+		So(scanner.Text(), ShouldEqual, `{"type":"event","repo":"test","content":"<tree/>"}`)
+
+		go legacy.writeEvent(event)
+		So(scanner.Scan(), ShouldBeTrue)
+		So(scanner.Text(), ShouldEqual, "<tree/>")
+	})
+
+	Convey("Replaying the events missed by a reconnecting client", t, func() {
+		b := NewBacklog(2)
+		SetBacklog(b)
+		defer SetBacklog(nil)
+
+		b.Add(nsq.MessageID{'1'}, PydioInstantMessage{RepoID: "test", XMLContent: "first"})
+		b.Add(nsq.MessageID{'2'}, PydioInstantMessage{RepoID: "other", XMLContent: "second"})
+		b.Add(nsq.MessageID{'3'}, PydioInstantMessage{RepoID: "test", UserID: "evil", XMLContent: "third"})
+		live := b.Add(nsq.MessageID{'4'}, PydioInstantMessage{RepoID: "test", XMLContent: "fourth"})
+
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		respr, respw := io.Pipe()
+		defer respw.Close()
+
+		connection, _ := NewConnectionWithProtocol(fakeUser, ProtocolJSON, reqr, respw)
+
+		scanner := bufio.NewScanner(respr)
+		read := func() Frame {
+			var frame Frame
+			So(scanner.Scan(), ShouldBeTrue)
+			So(json.Unmarshal(scanner.Bytes(), &frame), ShouldBeNil)
+
+			return frame
+		}
+
+		go reqw.Write([]byte(`{"type":"subscribe","id":"1","repos":["test","other"],"last_event_id":"1"}` + "\n"))
+
+		So(read().Type, ShouldEqual, FrameAck)
+		So(read(), ShouldResemble, Frame{Type: FrameEvent, ID: "2", Repo: "other", Content: "second"})
+		So(read(), ShouldResemble, Frame{Type: FrameEvent, ID: "4", Repo: "test", Content: "fourth"})
+
+		// Already replayed
+		go func() {
+			connection.writeEvent(live)
+			connection.writeFrame(Frame{Type: FrameAck, ID: "done"})
+		}()
+		So(read().ID, ShouldEqual, "done")
+
+		// The first events of test were dropped
+		go reqw.Write([]byte(`{"type":"subscribe","id":"2","repos":["test"],"last_event_id":"0"}` + "\n"))
+
+		So(read().Type, ShouldEqual, FrameAck)
+		So(read(), ShouldResemble, Frame{Type: FrameResync, ID: "2", Repos: []string{"test"}})
+
+		go reqw.Write([]byte(`{"type":"subscribe","id":"3","repos":["test"],"last_event_id":"abc"}` + "\n"))
+		So(read(), ShouldResemble, Frame{Type: FrameError, ID: "3", Error: ErrInvalidEventID.Error()})
+	})
+}
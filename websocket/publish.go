@@ -0,0 +1,135 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pydio/pydio-booster/com"
+	pydio "github.com/pydio/pydio-booster/io"
+)
+
+var (
+	// ErrUnknownEvent is sent back for an event that clients may not publish
+	ErrUnknownEvent = errors.New("Unknown event")
+
+	// ErrMissingRepo is sent back for an event without repository
+	ErrMissingRepo = errors.New("No repository for the event")
+
+	// ErrForbiddenEvent is sent back for an event on a repository the
+	// user may not read, or write for the events that require it
+	ErrForbiddenEvent = errors.New("Event not allowed on the repository")
+
+	events   = make(map[string]EventPolicy)
+	eventsMu sync.RWMutex
+
+	// publish the messages to NSQ, replaced by the tests
+	publish = com.Publish
+)
+
+// EventPolicy of an event the clients may publish. Write events, like the
+// cancellation of a task, require a writable repository
+type EventPolicy struct {
+	Topic string
+	Write bool
+}
+
+// ClientEvent published to the topic of its policy, for the backend or
+// any other service consuming it
+type ClientEvent struct {
+	Event        string          `json:"EVENT"`
+	RepoID       string          `json:"REPO_ID"`
+	UserID       string          `json:"USER_ID"`
+	GroupPath    string          `json:"GROUP_PATH"`
+	NodePaths    []string        `json:"NODE_PATHS,omitempty"`
+	Data         json.RawMessage `json:"DATA,omitempty"`
+	ConnectionID string          `json:"CONNECTION_ID"`
+	Time         time.Time       `json:"TIME"`
+}
+
+// SetEventPolicy allowing the clients to publish the event. A policy
+// without topic forbids it again
+func SetEventPolicy(event string, p EventPolicy) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	if p.Topic == "" {
+		delete(events, event)
+		return
+	}
+
+	events[event] = p
+}
+
+// GetEventPolicy of the event, false if the clients may not publish it
+func GetEventPolicy(event string) (EventPolicy, bool) {
+	eventsMu.RLock()
+	defer eventsMu.RUnlock()
+
+	p, ok := events[event]
+
+	return p, ok
+}
+
+// Publish the event of the client on the repository, with the paths of
+// the nodes it is about and its data
+func (c *Connection) Publish(event string, repo string, paths []string, data json.RawMessage) error {
+	p, ok := GetEventPolicy(event)
+	if !ok {
+		return ErrUnknownEvent
+	}
+
+	if repo == "" {
+		return ErrMissingRepo
+	}
+
+	r := c.User.GetRepo(repo)
+	if !r.IsReadable() || (p.Write && !r.IsWritable()) {
+		return ErrForbiddenEvent
+	}
+
+	n := pydio.GetNormalization(repo)
+
+	e := ClientEvent{
+		Event:        event,
+		RepoID:       repo,
+		UserID:       c.User.ID,
+		GroupPath:    c.User.GroupPath,
+		Data:         data,
+		ConnectionID: c.uniqueID,
+		Time:         time.Now(),
+	}
+
+	for _, nodePath := range paths {
+		e.NodePaths = append(e.NodePaths, n.Path(path.Clean("/"+nodePath)))
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return publish(com.Message{Topic: p.Topic, Content: b})
+}
@@ -0,0 +1,123 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/pydio/pydio-booster/com"
+	pydio "github.com/pydio/pydio-booster/io"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPublish(t *testing.T) {
+
+	Convey("Publishing the events of the clients", t, func() {
+		var published []com.Message
+		publish = func(m com.Message) error {
+			published = append(published, m)
+			return nil
+		}
+		defer func() { publish = com.Publish }()
+
+		SetEventPolicy("viewing", EventPolicy{Topic: "activity"})
+		SetEventPolicy("cancel_task", EventPolicy{Topic: "tasks", Write: true})
+		defer SetEventPolicy("viewing", EventPolicy{})
+		defer SetEventPolicy("cancel_task", EventPolicy{})
+
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		respr, respw := io.Pipe()
+		defer respw.Close()
+
+		connection, err := NewConnectionWithProtocol(fakeUser, ProtocolJSON, reqr, respw)
+		So(err, ShouldBeNil)
+
+		scanner := bufio.NewScanner(respr)
+		send := func(text string) Frame {
+			go reqw.Write([]byte(text + "\n"))
+
+			var frame Frame
+			So(scanner.Scan(), ShouldBeTrue)
+			So(json.Unmarshal(scanner.Bytes(), &frame), ShouldBeNil)
+
+			return frame
+		}
+
+		frame := send(`{"type":"publish","id":"1","event":"viewing","repo":"test","paths":["docs/a.pdf"],"data":{"page":2}}`)
+		So(frame.Type, ShouldEqual, FrameAck)
+		So(frame.ID, ShouldEqual, "1")
+
+		So(published, ShouldHaveLength, 1)
+		So(published[0].Topic, ShouldEqual, "activity")
+
+		var e ClientEvent
+		So(json.Unmarshal(published[0].Content, &e), ShouldBeNil)
+		So(e.Event, ShouldEqual, "viewing")
+		So(e.RepoID, ShouldEqual, "test")
+		So(e.UserID, ShouldEqual, "test")
+		So(e.NodePaths, ShouldResemble, []string{"/docs/a.pdf"})
+		So(string(e.Data), ShouldEqual, `{"page":2}`)
+		So(e.ConnectionID, ShouldEqual, connection.uniqueID)
+
+		frame = send(`{"type":"publish","id":"2","event":"evil","repo":"test"}`)
+		So(frame, ShouldResemble, Frame{Type: FrameError, ID: "2", Error: ErrUnknownEvent.Error()})
+
+		frame = send(`{"type":"publish","id":"3","event":"viewing"}`)
+		So(frame, ShouldResemble, Frame{Type: FrameError, ID: "3", Error: ErrMissingRepo.Error()})
+
+		frame = send(`{"type":"publish","id":"4","event":"viewing","repo":"writeonly"}`)
+		So(frame, ShouldResemble, Frame{Type: FrameError, ID: "4", Error: ErrForbiddenEvent.Error()})
+
+		frame = send(`{"type":"publish","id":"5","event":"cancel_task","repo":"test"}`)
+		So(frame.Type, ShouldEqual, FrameAck)
+		So(published[1].Topic, ShouldEqual, "tasks")
+
+		publish = func(m com.Message) error { return errors.New("NSQ is down") }
+
+		frame = send(`{"type":"publish","id":"6","event":"viewing","repo":"test"}`)
+		So(frame, ShouldResemble, Frame{Type: FrameError, ID: "6", Error: "NSQ is down"})
+	})
+
+	Convey("Write events need a writable repository", t, func() {
+		SetEventPolicy("cancel_task", EventPolicy{Topic: "tasks", Write: true})
+		defer SetEventPolicy("cancel_task", EventPolicy{})
+
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		_, respw := io.Pipe()
+		defer respw.Close()
+
+		user := &pydio.User{ID: "reader", Repos: []pydio.Repo{{ID: "readonly", ACL: "r"}}}
+
+		connection, err := NewConnection(user, reqr, respw)
+		So(err, ShouldBeNil)
+
+		So(connection.Publish("cancel_task", "readonly", nil, nil), ShouldEqual, ErrForbiddenEvent)
+		So(connection.Publish("cancel_task", "missing", nil, nil), ShouldEqual, ErrForbiddenEvent)
+	})
+}
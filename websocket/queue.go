@@ -0,0 +1,273 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"errors"
+	"sync"
+)
+
+// Policies applied when the outbound queue of a slow client is full
+const (
+	// PolicyDropOldest drops the oldest message waiting
+	PolicyDropOldest = "drop_oldest"
+
+	// PolicyCoalesce drops the event waiting for the repository of the new
+	// one, or the oldest message if there is none. A client then receives
+	// at least the last event of each repository
+	PolicyCoalesce = "coalesce"
+
+	// PolicyDisconnect closes the connection of the client
+	PolicyDisconnect = "disconnect"
+)
+
+// DefaultQueueSize is the number of messages waiting for a client
+const DefaultQueueSize = 256
+
+var (
+	// ErrUnknownPolicy is returned for a queue configuration with an unknown policy
+	ErrUnknownPolicy = errors.New("Unknown queue policy")
+
+	// ErrSlowConsumer stops the connection of a client too slow to
+	// receive its messages, with the disconnect policy
+	ErrSlowConsumer = errors.New("Client too slow to receive the messages")
+
+	queueConfig   = QueueConfig{Size: DefaultQueueSize, Policy: PolicyDropOldest}
+	queueConfigMu sync.RWMutex
+)
+
+// QueueConfig of the outbound queues of the connections
+type QueueConfig struct {
+	Size   int
+	Policy string
+}
+
+// QueueStats of the outbound queue of a connection
+type QueueStats struct {
+	Depth     int    `json:"depth"`
+	Size      int    `json:"size"`
+	Dropped   uint64 `json:"dropped"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+// message waiting to be written to the client. Only the events have a repository
+type message struct {
+	repo string
+	b    []byte
+}
+
+// queue of the messages waiting for a client, bounded by its size so
+// that a slow client does not hold the NSQ consumer
+type queue struct {
+	config QueueConfig
+
+	mu       sync.Mutex
+	messages []message
+	closed   bool
+	stats    QueueStats
+
+	// Set when the client was too slow for the disconnect policy
+	overflowed bool
+
+	// Set once the last message is queued
+	draining bool
+
+	ready chan struct{}
+}
+
+// SetQueueConfig used by the new connections. Zero values are replaced by the defaults
+func SetQueueConfig(config QueueConfig) error {
+	switch config.Policy {
+	case "":
+		config.Policy = PolicyDropOldest
+	case PolicyDropOldest, PolicyCoalesce, PolicyDisconnect:
+	default:
+		return ErrUnknownPolicy
+	}
+
+	if config.Size <= 0 {
+		config.Size = DefaultQueueSize
+	}
+
+	queueConfigMu.Lock()
+	defer queueConfigMu.Unlock()
+
+	queueConfig = config
+
+	return nil
+}
+
+// DefaultQueueConfig used by the new connections
+func DefaultQueueConfig() QueueConfig {
+	queueConfigMu.RLock()
+	defer queueConfigMu.RUnlock()
+
+	return queueConfig
+}
+
+func newQueue(config QueueConfig) *queue {
+	return &queue{
+		config: config,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push the message, applying the policy if the queue is full.
+// ErrSlowConsumer is returned if the client must be disconnected
+func (q *queue) push(m message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// The client is already being disconnected
+	if q.closed || q.overflowed || q.draining {
+		return nil
+	}
+
+	if len(q.messages) >= q.config.Size {
+		switch {
+		case q.config.Policy == PolicyDisconnect:
+			q.stats.Dropped++
+			q.overflowed = true
+
+			return ErrSlowConsumer
+		case q.config.Policy == PolicyCoalesce && q.coalesce(m):
+			q.stats.Coalesced++
+
+			return nil
+		default:
+			q.messages[0] = message{}
+			q.messages = q.messages[1:]
+			q.stats.Dropped++
+		}
+	}
+
+	q.messages = append(q.messages, m)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// coalesce the event with the last one waiting for its repository. The
+// new event goes to the end of the queue, to keep the order of the ids
+func (q *queue) coalesce(m message) bool {
+	if m.repo == "" {
+		return false
+	}
+
+	for i := len(q.messages) - 1; i >= 0; i-- {
+		if q.messages[i].repo == m.repo {
+			copy(q.messages[i:], q.messages[i+1:])
+			q.messages[len(q.messages)-1] = m
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// pop the oldest message, waiting for one. False once the queue is closed
+func (q *queue) pop() (message, bool) {
+	for {
+		q.mu.Lock()
+
+		if q.closed {
+			q.mu.Unlock()
+			return message{}, false
+		}
+
+		if len(q.messages) > 0 {
+			m := q.messages[0]
+			q.messages[0] = message{}
+			q.messages = q.messages[1:]
+
+			q.mu.Unlock()
+			return m, true
+		}
+
+		if q.draining {
+			q.mu.Unlock()
+			return message{}, false
+		}
+
+		q.mu.Unlock()
+
+		<-q.ready
+	}
+}
+
+// drain the queue with a last message, dropping the ones waiting. Nothing
+// is popped after it
+func (q *queue) drain(m message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || q.draining {
+		return
+	}
+
+	q.draining = true
+	q.messages = []message{m}
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// close the queue, dropping the messages waiting
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.closed = true
+	q.messages = nil
+
+	close(q.ready)
+}
+
+// Stats of the queue
+func (q *queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Depth = len(q.messages)
+	stats.Size = q.config.Size
+
+	return stats
+}
+
+// isOverflowed if the client was too slow for the disconnect policy
+func (q *queue) isOverflowed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.overflowed
+}
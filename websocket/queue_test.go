@@ -0,0 +1,237 @@
+// Package websocket contains the code to create and handle a Pydio websocket connection
+/*
+ * Copyright 2007-2016 Abstrium <contact (at) pydio.com>
+ * This file is part of Pydio.
+ *
+ * Pydio is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Pydio is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Pydio.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * The latest code can be found at <https://pydio.com/>.
+ */
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	pydio "github.com/pydio/pydio-booster/io"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stoppedConsumer reports when the connection stops it
+type stoppedConsumer struct {
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (c *stoppedConsumer) AddHandler(func(*nsq.Message) error) {}
+func (c *stoppedConsumer) Start() error                        { return nil }
+func (c *stoppedConsumer) Stop()                               { c.stopOnce.Do(func() { close(c.stopped) }) }
+
+// brokenWriter fails like the stream of a client that is gone
+type brokenWriter struct{}
+
+func (brokenWriter) Write([]byte) (int, error) { return 0, errBroken }
+func (brokenWriter) Close() error              { return nil }
+
+var errBroken = errors.New("broken pipe")
+
+// closedBefore tells if the channel is closed before the timeout
+func closedBefore(c <-chan struct{}, timeout <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+func TestQueue(t *testing.T) {
+
+	contents := func(q *queue) []string {
+		var res []string
+		for _, m := range q.messages {
+			res = append(res, string(m.b))
+		}
+
+		return res
+	}
+
+	Convey("Configuring the queues", t, func() {
+		defer SetQueueConfig(QueueConfig{})
+
+		So(SetQueueConfig(QueueConfig{Policy: "evil"}), ShouldEqual, ErrUnknownPolicy)
+
+		So(SetQueueConfig(QueueConfig{Policy: PolicyCoalesce}), ShouldBeNil)
+		So(DefaultQueueConfig(), ShouldResemble, QueueConfig{Size: DefaultQueueSize, Policy: PolicyCoalesce})
+
+		So(SetQueueConfig(QueueConfig{}), ShouldBeNil)
+		So(DefaultQueueConfig(), ShouldResemble, QueueConfig{Size: DefaultQueueSize, Policy: PolicyDropOldest})
+	})
+
+	Convey("Dropping the oldest messages", t, func() {
+		q := newQueue(QueueConfig{Size: 2, Policy: PolicyDropOldest})
+
+		So(q.push(message{repo: "a", b: []byte("1")}), ShouldBeNil)
+		So(q.push(message{b: []byte("ack")}), ShouldBeNil)
+		So(q.push(message{repo: "a", b: []byte("2")}), ShouldBeNil)
+
+		So(contents(q), ShouldResemble, []string{"ack", "2"})
+		So(q.Stats(), ShouldResemble, QueueStats{Depth: 2, Size: 2, Dropped: 1})
+	})
+
+	Convey("Coalescing the events of a repository", t, func() {
+		q := newQueue(QueueConfig{Size: 3, Policy: PolicyCoalesce})
+
+		So(q.push(message{repo: "a", b: []byte("a1")}), ShouldBeNil)
+		So(q.push(message{repo: "b", b: []byte("b1")}), ShouldBeNil)
+		So(q.push(message{repo: "a", b: []byte("a2")}), ShouldBeNil)
+
+		So(q.push(message{repo: "b", b: []byte("b2")}), ShouldBeNil)
+		So(contents(q), ShouldResemble, []string{"a1", "a2", "b2"})
+
+		// Without a pending event for the repository, the oldest is dropped
+		So(q.push(message{repo: "c", b: []byte("c1")}), ShouldBeNil)
+		So(contents(q), ShouldResemble, []string{"a2", "b2", "c1"})
+
+		So(q.Stats(), ShouldResemble, QueueStats{Depth: 3, Size: 3, Dropped: 1, Coalesced: 1})
+
+		m, ok := q.pop()
+		So(ok, ShouldBeTrue)
+		So(string(m.b), ShouldEqual, "a2")
+
+		q.close()
+
+		_, ok = q.pop()
+		So(ok, ShouldBeFalse)
+		So(q.push(message{b: []byte("late")}), ShouldBeNil)
+	})
+
+	Convey("Disconnecting a slow client", t, func() {
+		So(SetQueueConfig(QueueConfig{Size: 2, Policy: PolicyDisconnect}), ShouldBeNil)
+		defer SetQueueConfig(QueueConfig{})
+
+		registry := DefaultRegistry()
+		before := registry.Metrics(false)
+
+		user := &pydio.User{ID: "slow", Repos: []pydio.Repo{{ID: "slow"}}}
+
+		reqr, reqw := io.Pipe()
+		respr, respw := io.Pipe()
+		defer reqw.Close()
+
+		c, err := NewConnectionWithProtocol(user, ProtocolJSON, reqr, respw)
+		So(err, ShouldBeNil)
+
+		// The client does not read, the writer holds the first event
+		// and the two next ones wait in the queue
+		for i := 0; i < 4; i++ {
+			c.writeEvent(&Event{PydioInstantMessage: PydioInstantMessage{RepoID: "slow", XMLContent: "<tree/>"}})
+		}
+
+		for range c.ExitChan {
+		}
+
+		after := registry.Metrics(false)
+		So(after.Disconnected, ShouldEqual, before.Disconnected+1)
+		So(after.Dropped, ShouldEqual, before.Dropped+1)
+
+		scanner := bufio.NewScanner(respr)
+		So(scanner.Scan(), ShouldBeFalse)
+		So(scanner.Err(), ShouldEqual, ErrSlowConsumer)
+	})
+	Convey("Disconnecting a slow event stream", t, func() {
+		So(SetQueueConfig(QueueConfig{Size: 2, Policy: PolicyDisconnect}), ShouldBeNil)
+		defer SetQueueConfig(QueueConfig{})
+
+		// The connections of the other tests may still create theirs
+		var mu sync.Mutex
+		consumers := make(map[string]*stoppedConsumer)
+		consumer := func(channel string) *stoppedConsumer {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if consumers[channel] == nil {
+				consumers[channel] = &stoppedConsumer{stopped: make(chan struct{})}
+			}
+
+			return consumers[channel]
+		}
+
+		SetConsumerFunc(func(topic string, channel string) (Consumer, error) {
+			return consumer(channel), nil
+		})
+		defer SetConsumerFunc(nil)
+
+		user := &pydio.User{ID: "slow", Repos: []pydio.Repo{{ID: "slow"}}}
+
+		// The client never reads, not even the close frame
+		_, respw := io.Pipe()
+
+		c, err := NewConnectionWithProtocol(user, ProtocolSSE, nil, respw)
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 4; i++ {
+			c.writeEvent(&Event{PydioInstantMessage: PydioInstantMessage{RepoID: "slow", XMLContent: "<tree/>"}})
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			for range c.ExitChan {
+			}
+			close(stopped)
+		}()
+
+		timeout := time.After(5 * CloseTimeout)
+
+		So(closedBefore(stopped, timeout), ShouldBeTrue)
+		So(closedBefore(consumer(c.uniqueID+"#ephemeral").stopped, timeout), ShouldBeTrue)
+	})
+
+	Convey("Disconnecting a client that is gone", t, func() {
+		consumer := &stoppedConsumer{stopped: make(chan struct{})}
+
+		SetConsumerFunc(func(topic string, channel string) (Consumer, error) {
+			return consumer, nil
+		})
+		defer SetConsumerFunc(nil)
+
+		registry := DefaultRegistry()
+		before := registry.Len()
+
+		user := &pydio.User{ID: "gone", Repos: []pydio.Repo{{ID: "gone"}}}
+
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		c, err := NewConnectionWithProtocol(user, ProtocolJSON, reqr, brokenWriter{})
+		So(err, ShouldBeNil)
+		So(registry.Len(), ShouldEqual, before+1)
+
+		c.writeEvent(&Event{PydioInstantMessage: PydioInstantMessage{RepoID: "gone", XMLContent: "<tree/>"}})
+
+		var errs []error
+		for err := range c.ExitChan {
+			errs = append(errs, err)
+		}
+
+		So(errs, ShouldResemble, []error{errBroken})
+		So(closedBefore(consumer.stopped, time.After(CloseTimeout)), ShouldBeTrue)
+		So(registry.Len(), ShouldEqual, before)
+	})
+}
@@ -27,7 +27,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/nsqio/go-nsq"
 	"github.com/nu7hatch/gouuid"
@@ -42,26 +45,105 @@ type Connection struct {
 	uniqueID string
 
 	User *pydio.User
-	Repo *pydio.Repo
 
+	// Protocol negotiated with the client
+	Protocol string
+
+	// Repositories the connection is subscribed to, by id, and the path
+	// prefixes the subscriptions are restricted to
+	repos   map[string]*pydio.Repo
+	paths   map[string][]string
+	reposMu sync.RWMutex
+
+	// ExitChan receives the error stopping the connection, if any, and is
+	// closed once the connection is closed
 	ExitChan chan (error)
 
+	consumer  Consumer
+	closed    bool
+	closeMu   sync.Mutex
+	closeOnce sync.Once
+
 	Incoming io.Reader
 	Outgoing io.Writer
+	writeMu  sync.Mutex
+
+	// Messages waiting to be written to the outgoing stream, closed once
+	// the writer is done
+	queue   *queue
+	written chan struct{}
+
+	// Last event id replayed for each repository
+	replayed map[string]uint64
+
+	// Registry of the connections, tracking the presence of the users
+	registry *Registry
 
 	Logger *pydiolog.Logger
 }
 
+// ErrShutdown is sent to the connections closed when booster stops
+var ErrShutdown = errors.New("Server is shutting down")
+
+// CloseTimeout is the time given to a client to receive the frame telling
+// why its stream ends
+const CloseTimeout = time.Second
+
+// Consumer of the instant messages of a connection
+type Consumer interface {
+	AddHandler(handler func(*nsq.Message) error)
+	Start() error
+	Stop()
+}
+
+// ConsumerFunc creates the consumer of the topic, on the channel of a connection
+type ConsumerFunc func(topic string, channel string) (Consumer, error)
+
+var (
+	newConsumer   ConsumerFunc = nsqConsumer
+	newConsumerMu sync.RWMutex
+)
+
+// SetConsumerFunc used by the new connections, NSQ by default
+func SetConsumerFunc(f ConsumerFunc) {
+	newConsumerMu.Lock()
+	defer newConsumerMu.Unlock()
+
+	if f == nil {
+		f = nsqConsumer
+	}
+
+	newConsumer = f
+}
+
+// nsqConsumer of the topic
+func nsqConsumer(topic string, channel string) (Consumer, error) {
+	c, err := com.NewConsumer(topic, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
 // PydioInstantMessage format
 type PydioInstantMessage struct {
 	UserID     string `json:"USER_ID"`
 	GroupPath  string `json:"GROUP_PATH"`
 	RepoID     string `json:"REPO_ID"`
 	XMLContent string `json:"CONTENT"`
+
+	// Paths of the nodes affected by the message, if known
+	NodePaths []string `json:"NODE_PATHS,omitempty"`
 }
 
-// NewConnection via a websocket
+// NewConnection via a websocket, with the legacy protocol
 func NewConnection(u *pydio.User, incoming io.Reader, outgoing io.Writer) (*Connection, error) {
+	return NewConnectionWithProtocol(u, ProtocolLegacy, incoming, outgoing)
+}
+
+// NewConnectionWithProtocol via a websocket, with the protocol negotiated with the client
+func NewConnectionWithProtocol(u *pydio.User, protocol string, incoming io.Reader, outgoing io.Writer) (*Connection, error) {
 
 	// Creating a Unique ID for the connection
 	u4, err := uuid.NewV4()
@@ -80,11 +162,18 @@ func NewConnection(u *pydio.User, incoming io.Reader, outgoing io.Writer) (*Conn
 	}
 
 	// Creating the channel for messages that the websocket
-	exitChan := make(chan (error))
+	exitChan := make(chan (error), 1)
 
 	connection := &Connection{
 		uniqueID: u4.String(),
 		User:     u,
+		Protocol: protocol,
+		repos:    make(map[string]*pydio.Repo),
+		paths:    make(map[string][]string),
+		replayed: make(map[string]uint64),
+		registry: DefaultRegistry(),
+		queue:    newQueue(DefaultQueueConfig()),
+		written:  make(chan struct{}),
 		ExitChan: exitChan,
 		Incoming: rc,
 		Outgoing: wc,
@@ -92,93 +181,364 @@ func NewConnection(u *pydio.User, incoming io.Reader, outgoing io.Writer) (*Conn
 		Logger: pydiolog.New(pydiolog.GetLevel(), "[ws] ", pydiolog.Ldate|pydiolog.Ltime|pydiolog.Lmicroseconds),
 	}
 
-	// Create the incoming handler
-	go func() {
-		reader := connection.Incoming
-
-		scanner := bufio.NewScanner(reader)
-
-		for scanner.Scan() {
-			text := scanner.Text()
-
-			if strings.Index(text, "register") == 0 {
-				repoID := strings.TrimPrefix(text, "register:")
-
-				repo := connection.User.GetRepo(repoID)
-				if !repo.IsReadable() {
-					// Retrieving a nil value
-					connection.Repo = connection.User.GetRepo("")
-					connection.Logger.SetPrefix("[ws] ")
-					connection.Logger.Infof("Register %s : repo is writeonly", repoID)
-				} else {
-					connection.Repo = repo
-					connection.Logger.SetPrefix(fmt.Sprintf("[ws %s] ", connection))
-					connection.Logger.Infof("Register %s %v", repoID, connection.User.Repos)
+	connection.registry.Add(connection)
+
+	go connection.write()
+
+	// Create the incoming handler, the server-sent events have none.
+	// The connection is closed once the client stops sending
+	if connection.Incoming != nil {
+		go func() {
+			defer connection.Close()
+
+			// Messages are whole frames, or lines of text
+			if reader, ok := connection.Incoming.(*FrameReader); ok {
+				for {
+					b, err := reader.ReadFrame()
+					if err != nil {
+						return
+					}
+
+					connection.handle(strings.TrimSpace(string(b)))
 				}
-			} else if strings.Index(text, "unregister") == 0 {
-				// Retrieving a nil value
-				connection.Repo = connection.User.GetRepo("")
-				connection.Logger.SetPrefix("[ws] ")
-				connection.Logger.Infof("Unregister", connection)
 			}
-		}
-	}()
+
+			scanner := bufio.NewScanner(connection.Incoming)
+
+			for scanner.Scan() {
+				connection.handle(scanner.Text())
+			}
+		}()
+	}
 
 	// Create the handler for incoming messages from the back (NSQ messages)
 	go func() {
-		// Create consumer for User
-		c, err := com.NewConsumer("im", u4.String())
+		newConsumerMu.RLock()
+		consumerFunc := newConsumer
+		newConsumerMu.RUnlock()
+
+		// Create consumer for User, on a channel deleted by NSQ once it stops
+		c, err := consumerFunc("im", u4.String()+"#ephemeral")
 		if err != nil {
-			connection.ExitChan <- err
+			connection.exit(err)
+			return
+		}
+
+		c.AddHandler(connection.receive)
+
+		connection.closeMu.Lock()
+		defer connection.closeMu.Unlock()
+
+		// The goroutines of the consumer live until it is stopped
+		if connection.closed {
+			c.Stop()
 			return
 		}
 
-		writer := connection.Outgoing
+		if err := c.Start(); err != nil {
+			c.Stop()
+			connection.sendExit(err)
+			return
+		}
 
-		c.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
-			var pm PydioInstantMessage
+		connection.consumer = c
+	}()
 
-			body := m.Body
-			user := connection.User
-			repo := connection.Repo
+	return connection, nil
+}
 
-			if repo == nil {
-				connection.Logger.Debugln("Returning - Empty repository")
-				return nil
-			}
+// receive a message from NSQ, written to the client if it is accepted
+func (c *Connection) receive(m *nsq.Message) error {
+	var pm PydioInstantMessage
 
-			if !repo.IsReadable() {
-				connection.Logger.Debugf("Returning - Write Only repository %v", repo)
-				return nil
-			}
+	err := json.Unmarshal(m.Body, &pm)
+	if err != nil {
+		c.Logger.Errorln(err)
+		return err
+	}
 
-			err := json.Unmarshal(body, &pm)
-			if err != nil {
-				connection.Logger.Errorln(err)
-				return err
-			}
+	if !c.accepts(pm) {
+		return nil
+	}
 
-			if pm.RepoID == "*" || repo.ID == pm.RepoID {
-				if pm.UserID != "" && pm.UserID != user.ID {
-					return nil
-				}
+	event := &Event{PydioInstantMessage: pm}
+	if b := DefaultBacklog(); b != nil {
+		event = b.Add(m.ID, pm)
+	}
 
-				if pm.GroupPath != "" && pm.GroupPath != user.GroupPath {
-					return nil
-				}
+	c.Logger.Debugf("Writing to websocket : %s", pm.XMLContent)
+
+	c.writeEvent(event)
 
-				connection.Logger.Debugf("Writing to websocket : %s", pm.XMLContent)
+	return nil
+}
 
-				writer.Write([]byte(pm.XMLContent + "\n"))
+// Close the connection. Its NSQ consumer is stopped, its streams are
+// closed and it leaves the repositories it was subscribed to
+func (c *Connection) Close() error {
+	c.stop(nil)
+
+	return nil
+}
+
+// stop the connection once, sending the error to the exit channel
+func (c *Connection) stop(err error) {
+	c.closeOnce.Do(func() {
+		c.closeMu.Lock()
+		c.closed = true
+		consumer := c.consumer
+		c.closeMu.Unlock()
+
+		if consumer != nil {
+			consumer.Stop()
+		}
+
+		// Clients without control frames are told why the stream ends,
+		// instead of the messages waiting. A client that does not read is
+		// not waited for
+		if c.Protocol == ProtocolSSE && (err == ErrShutdown || err == ErrSlowConsumer) {
+			c.queue.drain(message{b: c.encodeFrame(Frame{Type: FrameClose, Error: err.Error()})})
+
+			timer := time.NewTimer(CloseTimeout)
+
+			select {
+			case <-c.written:
+			case <-timer.C:
 			}
 
-			return nil
-		}))
+			timer.Stop()
+		}
 
-		c.Start()
-	}()
+		c.queue.close()
 
-	return connection, nil
+		// Unblocking the pending writes. A pipe reader gets the error, to
+		// forward it to the client
+		if closer, ok := c.Outgoing.(interface {
+			CloseWithError(error) error
+		}); ok {
+			closer.CloseWithError(err)
+		} else if closer, ok := c.Outgoing.(io.Closer); ok {
+			closer.Close()
+		}
+
+		if closer, ok := c.Incoming.(io.Closer); ok {
+			closer.Close()
+		}
+
+		c.registry.Remove(c)
+
+		if err != nil {
+			c.sendExit(err)
+		}
+
+		close(c.ExitChan)
+	})
+}
+
+// exit reports the error to the handler of a connection still open
+func (c *Connection) exit(err error) {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+
+	if !c.closed {
+		c.sendExit(err)
+	}
+}
+
+// sendExit keeps the first error for the handler
+func (c *Connection) sendExit(err error) {
+	select {
+	case c.ExitChan <- err:
+	default:
+	}
+}
+
+// Subscribe the connection to the repositories readable by the user. The
+// ids of the repositories that were refused are returned
+func (c *Connection) Subscribe(ids ...string) (refused []string) {
+	return c.SubscribePaths(nil, ids...)
+}
+
+// SubscribePaths subscribes the connection to the events of the nodes
+// under the path prefixes in the repositories. Without prefixes, the
+// connection receives the events of the whole repositories
+func (c *Connection) SubscribePaths(paths []string, ids ...string) (refused []string) {
+	var joined []string
+
+	c.reposMu.Lock()
+
+	for _, id := range ids {
+		repo := c.User.GetRepo(id)
+		if !repo.IsReadable() {
+			refused = append(refused, id)
+			continue
+		}
+
+		if _, ok := c.repos[id]; !ok {
+			joined = append(joined, id)
+		}
+
+		c.repos[id] = repo
+		c.paths[id] = prefixes(id, paths)
+	}
+
+	c.reposMu.Unlock()
+
+	c.registry.join(c, joined)
+
+	return
+}
+
+// Unsubscribe the connection from the repositories
+func (c *Connection) Unsubscribe(ids ...string) {
+	var left []string
+
+	c.reposMu.Lock()
+
+	for _, id := range ids {
+		if _, ok := c.repos[id]; ok {
+			left = append(left, id)
+		}
+
+		delete(c.repos, id)
+		delete(c.paths, id)
+	}
+
+	c.reposMu.Unlock()
+
+	c.registry.leave(c, left)
+}
+
+// UnsubscribeAll the repositories of the connection
+func (c *Connection) UnsubscribeAll() {
+	c.Unsubscribe(c.Repos()...)
+}
+
+// IsSubscribed to the repository. Messages sent to all repositories ("*")
+// reach any connection subscribed to at least one
+func (c *Connection) IsSubscribed(id string) bool {
+	c.reposMu.RLock()
+	defer c.reposMu.RUnlock()
+
+	if id == "*" {
+		return len(c.repos) > 0
+	}
+
+	_, ok := c.repos[id]
+	return ok
+}
+
+// Paths the subscription to the repository is restricted to, none for the whole repository
+func (c *Connection) Paths(id string) []string {
+	c.reposMu.RLock()
+	defer c.reposMu.RUnlock()
+
+	return c.paths[id]
+}
+
+// Repos the connection is subscribed to, sorted by id
+func (c *Connection) Repos() []string {
+	c.reposMu.RLock()
+	defer c.reposMu.RUnlock()
+
+	ids := make([]string, 0, len(c.repos))
+	for id := range c.repos {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// subscribe to the repositories and log the result
+func (c *Connection) subscribe(ids []string, paths []string) (refused []string) {
+	refused = c.SubscribePaths(paths, ids...)
+
+	c.Logger.SetPrefix(fmt.Sprintf("[ws %s] ", c))
+
+	if len(refused) > 0 {
+		c.Logger.Infof("Subscribe %s : repos are not readable", strings.Join(refused, ","))
+	}
+
+	if len(refused) < len(ids) {
+		c.Logger.Infof("Subscribe %s %v %v", strings.Join(ids, ","), paths, c.Repos())
+	}
+
+	return
+}
+
+// unsubscribe from the repositories, or all of them when none is given
+func (c *Connection) unsubscribe(ids []string) {
+	if len(ids) == 0 {
+		c.UnsubscribeAll()
+	} else {
+		c.Unsubscribe(ids...)
+	}
+
+	c.Logger.SetPrefix(fmt.Sprintf("[ws %s] ", c))
+	c.Logger.Infof("Unsubscribe %s", strings.Join(ids, ","))
+}
+
+// accepts the message if it was sent to a subscribed repository, and to
+// the user or group of the connection. A message with node paths must
+// match the path prefixes of the subscription
+func (c *Connection) accepts(pm PydioInstantMessage) bool {
+	if !c.IsSubscribed(pm.RepoID) {
+		return false
+	}
+
+	if !matches(pm.RepoID, c.Paths(pm.RepoID), pm.NodePaths) {
+		return false
+	}
+
+	if pm.UserID != "" && pm.UserID != c.User.ID {
+		return false
+	}
+
+	if pm.GroupPath != "" && pm.GroupPath != c.User.GroupPath {
+		return false
+	}
+
+	return true
+}
+
+// send to the outgoing stream, through the queue. The events are sent
+// with their repository. The write lock must be held
+func (c *Connection) send(repo string, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+
+	if err := c.queue.push(message{repo: repo, b: b}); err != nil {
+		go c.stop(err)
+	}
+}
+
+// write the messages of the queue to the outgoing stream, until the
+// connection is closed or the client is gone
+func (c *Connection) write() {
+	defer close(c.written)
+
+	for {
+		m, ok := c.queue.pop()
+		if !ok {
+			return
+		}
+
+		if _, err := c.Outgoing.Write(m.b); err != nil {
+			c.Logger.Debugln("Could not write to websocket ", err)
+
+			// Stopping waits for this writer to be done
+			go c.stop(err)
+			return
+		}
+	}
+}
+
+// QueueStats of the messages waiting for the client
+func (c *Connection) QueueStats() QueueStats {
+	return c.queue.Stats()
 }
 
 // ResetPrefix for the logger based on arguments
@@ -186,8 +546,8 @@ func (c *Connection) String() string {
 
 	str := fmt.Sprintf("%s:%s", c.User.ID, c.User.GroupPath)
 
-	if c.Repo != nil {
-		str = fmt.Sprintf("%s@%s", str, c.Repo.ID)
+	if repos := c.Repos(); len(repos) > 0 {
+		str = fmt.Sprintf("%s@%s", str, strings.Join(repos, ","))
 	}
 
 	return str
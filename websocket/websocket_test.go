@@ -29,6 +29,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/nsqio/go-nsq"
 	"github.com/pydio/pydio-booster/com"
 	pydioconf "github.com/pydio/pydio-booster/conf"
 	pydio "github.com/pydio/pydio-booster/io"
@@ -60,6 +61,8 @@ func init() {
 		GroupPath: "test",
 		Repos: []pydio.Repo{
 			{ID: "test"},
+			{ID: "other"},
+			{ID: "writeonly", ACL: "w"},
 		},
 	}
 
@@ -116,16 +119,15 @@ func TestSuccess(t *testing.T) {
 
 		// Registering to a wrong repo
 		reqw.Write([]byte("register:evil\n"))
-		So(connection.Repo, ShouldBeNil)
+		So(connection.Repos(), ShouldBeEmpty)
 
 		// Registering
 		reqw.Write([]byte("register:test\n"))
-		So(connection.Repo, ShouldNotBeNil)
-		So(connection.Repo.ID, ShouldEqual, "test")
+		So(connection.Repos(), ShouldResemble, []string{"test"})
 
 		// Unregistering
 		reqw.Write([]byte("unregister\n"))
-		So(connection.Repo, ShouldBeNil)
+		So(connection.Repos(), ShouldBeEmpty)
 
 		// Publishing a message while not registered
 		com.Publish(com.Message{
@@ -150,8 +152,7 @@ func TestSuccess(t *testing.T) {
 
 		// Registering
 		reqw.Write([]byte("register:test\n"))
-		So(connection.Repo, ShouldNotBeNil)
-		So(connection.Repo.ID, ShouldEqual, "test")
+		So(connection.Repos(), ShouldResemble, []string{"test"})
 
 		// Publishing a message for another group while registered to the repo
 		com.Publish(com.Message{
@@ -176,6 +177,102 @@ func TestSuccess(t *testing.T) {
 	})
 }
 
+func TestSubscriptions(t *testing.T) {
+
+	Convey("Subscribing to several repositories", t, func() {
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		_, respw := io.Pipe()
+		defer respw.Close()
+
+		connection, err := NewConnection(fakeUser, reqr, respw)
+		So(err, ShouldBeNil)
+
+		So(connection.Subscribe("test", "other", "writeonly", "evil"), ShouldResemble, []string{"writeonly", "evil"})
+		So(connection.Repos(), ShouldResemble, []string{"other", "test"})
+		So(connection.IsSubscribed("other"), ShouldBeTrue)
+		So(connection.IsSubscribed("writeonly"), ShouldBeFalse)
+		So(connection.IsSubscribed("*"), ShouldBeTrue)
+
+		connection.Unsubscribe("test")
+		So(connection.Repos(), ShouldResemble, []string{"other"})
+		So(connection.IsSubscribed("test"), ShouldBeFalse)
+
+		connection.UnsubscribeAll()
+		So(connection.Repos(), ShouldBeEmpty)
+		So(connection.IsSubscribed("*"), ShouldBeFalse)
+	})
+}
+
+// eventually polls the condition until it is true, for a second at most
+func eventually(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return true
+}
+
+// readLine from the scanner, for a second at most
+func readLine(scanner *bufio.Scanner) string {
+	line := make(chan string, 1)
+
+	go func() {
+		if scanner.Scan() {
+			line <- scanner.Text()
+		}
+		close(line)
+	}()
+
+	select {
+	case text := <-line:
+		return text
+	case <-time.After(time.Second):
+		return "TIMEOUT"
+	}
+}
+
+func TestMultipleRepos(t *testing.T) {
+
+	Convey("Receiving the messages of several repositories on one connection", t, func() {
+		reqr, reqw := io.Pipe()
+		defer reqw.Close()
+
+		respr, respw := io.Pipe()
+		defer respw.Close()
+
+		scanner := bufio.NewScanner(respr)
+
+		connection, err := NewConnection(fakeUser, reqr, respw)
+		So(err, ShouldBeNil)
+		defer connection.Close()
+
+		reqw.Write([]byte("subscribe:test,other\n"))
+		So(eventually(func() bool { return len(connection.Repos()) == 2 }), ShouldBeTrue)
+		So(connection.Repos(), ShouldResemble, []string{"other", "test"})
+
+		// Delivering the messages as NSQ would
+		connection.receive(&nsq.Message{Body: []byte("{\"REPO_ID\":\"other\", \"CONTENT\":\"Other repo\"}")})
+		So(readLine(scanner), ShouldEqual, "Other repo")
+
+		reqw.Write([]byte("unsubscribe:test\n"))
+		So(eventually(func() bool { return len(connection.Repos()) == 1 }), ShouldBeTrue)
+		So(connection.Repos(), ShouldResemble, []string{"other"})
+
+		// The message of the left repository is never written, the next one is
+		connection.receive(&nsq.Message{Body: []byte("{\"REPO_ID\":\"test\", \"CONTENT\":\"This is a simple test\"}")})
+		connection.receive(&nsq.Message{Body: []byte("{\"REPO_ID\":\"other\", \"CONTENT\":\"Still other repo\"}")})
+		So(readLine(scanner), ShouldEqual, "Still other repo")
+	})
+}
+
 func BenchmarkPublish(b *testing.B) {
 
 	com.NewProducer()
@@ -192,8 +289,7 @@ func BenchmarkPublish(b *testing.B) {
 	Convey("Testing a websocket connection", b, func() {
 
 		reqw.Write([]byte("register:test\n"))
-		So(connection.Repo, ShouldNotBeNil)
-		So(connection.Repo.ID, ShouldEqual, "test")
+		So(connection.Repos(), ShouldResemble, []string{"test"})
 
 		b.RunParallel(func(pb *testing.PB) {
 			for pb.Next() {